@@ -0,0 +1,136 @@
+/******************************************************************************
+ * \file        accesslog.go
+ *
+ * \brief       GO File that assigns request IDs and writes the JSON access log
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const HEADER_REQUEST_ID = "X-Request-ID"
+
+// Request IDs of callers are kept if they are safe to log and echo
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Access Log, one JSON line per request
+var accessLog = newAccessLogger()
+
+func newAccessLogger() *log.Logger {
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	return logger
+}
+
+type requestKey struct{}
+
+// requestInfo of a request, the subject is set once the request is authenticated
+type requestInfo struct {
+	ID      string
+	Subject string
+}
+
+func requestInfoOf(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestKey{}).(*requestInfo)
+	return info
+}
+
+/******************************************************************************************
+ *
+ * Request ID Middleware - keeps the X-Request-ID of the caller or assigns a new one,
+ * returns it in the response and attaches a logger with it to the request
+ *
+*******************************************************************************************/
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HEADER_REQUEST_ID)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(HEADER_REQUEST_ID, id)
+
+		info := &requestInfo{ID: id}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, info)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/******************************************************************************************
+ *
+ * ID of a request, empty outside of the Request ID Middleware
+ *
+*******************************************************************************************/
+func RequestID(r *http.Request) string {
+	if info := requestInfoOf(r.Context()); info != nil {
+		return info.ID
+	}
+	return ""
+}
+
+/******************************************************************************************
+ *
+ * Logger of the request of a context, the standard logger without a request
+ *
+*******************************************************************************************/
+func ContextLog(ctx context.Context) *log.Entry {
+	return ModuleLog(ctx, MODULE_APP)
+}
+
+/******************************************************************************************
+ *
+ * Access Log Middleware - logs method, route, status, bytes, latency and client of
+ * each request once it is served
+ *
+*******************************************************************************************/
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		fields := log.Fields{
+			"request_id": RequestID(r),
+			"method":     r.Method,
+			"route":      RouteName(r),
+			"path":       r.URL.Path,
+			"status":     recorder.status,
+			"bytes":      recorder.bytes,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"client":     client,
+			"user_agent": r.UserAgent(),
+		}
+		if info := requestInfoOf(r.Context()); info != nil && len(info.Subject) > 0 {
+			fields["subject"] = info.Subject
+		}
+		accessLog.WithFields(fields).Info("access")
+	})
+}
+
+// setRequestSubject records the authenticated subject for the access log
+func setRequestSubject(r *http.Request, subject string) {
+	if info := requestInfoOf(r.Context()); info != nil {
+		info.Subject = subject
+	}
+}
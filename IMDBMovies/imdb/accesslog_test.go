@@ -0,0 +1,140 @@
+/******************************************************************************
+ * \file        accesslog_test.go
+ *
+ * \brief       GO File that tests request IDs and the access log
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// withAccessLog captures the access log lines of a test
+func withAccessLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	accessLog.SetOutput(&buf)
+	t.Cleanup(func() { accessLog.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func serveWithRequestID(method string, path string, key string, id string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.10:52100"
+	if len(key) > 0 {
+		req.Header.Set(HEADER_API_KEY, key)
+	}
+	if len(id) > 0 {
+		req.Header.Set(HEADER_REQUEST_ID, id)
+	}
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+func TestRequestID(t *testing.T) {
+	withAccessLog(t)
+
+	response := serveWithRequestID("GET", "/imdb/version", "", "")
+	if id := response.Header().Get(HEADER_REQUEST_ID); len(id) != 32 {
+		t.Errorf("Expected a new request ID, got %q", id)
+	}
+
+	response = serveWithRequestID("GET", "/imdb/version", "", "lb-7f3a.42")
+	if id := response.Header().Get(HEADER_REQUEST_ID); id != "lb-7f3a.42" {
+		t.Errorf("Expected the request ID of the caller, got %q", id)
+	}
+
+	response = serveWithRequestID("GET", "/imdb/version", "", "<script>")
+	if id := response.Header().Get(HEADER_REQUEST_ID); id == "<script>" || len(id) != 32 {
+		t.Errorf("Expected an unsafe request ID to be replaced, got %q", id)
+	}
+}
+
+func TestErrorBodyRequestID(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+
+	response := serveWithRequestID("GET", "/imdb/uploads", "", "req-401")
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	var body map[string]string
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["request_id"] != "req-401" {
+		t.Errorf("Expected the request ID in the error body, got %v", body)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := withAccessLog(t)
+	withAPIKeys(t)
+
+	serveWithRequestID("GET", "/imdb/uploads", "uploader-key", "req-1")
+	serveWithRequestID("GET", "/imdb/unknown", "", "req-2")
+
+	decoder := json.NewDecoder(buf)
+	var entries []map[string]interface{}
+	for decoder.More() {
+		var entry map[string]interface{}
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Expected JSON lines, got %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected a line per request, got %d", len(entries))
+	}
+
+	entry := entries[0]
+	expected := map[string]interface{}{
+		"request_id": "req-1",
+		"method":     "GET",
+		"route":      "GetUploads",
+		"status":     float64(http.StatusOK),
+		"client":     "192.0.2.10",
+		"subject":    "uploader-client",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s %v, got %v", key, value, entry[key])
+		}
+	}
+	if entry["bytes"].(float64) == 0 {
+		t.Errorf("Expected the response size")
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("Expected the latency, got %v", entry["latency_ms"])
+	}
+
+	// requests without a route are logged too
+	if entries[1]["status"] != float64(http.StatusNotFound) || entries[1]["request_id"] != "req-2" {
+		t.Errorf("Expected the unknown path to be logged, got %v", entries[1])
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	var logged interface{}
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logged = RequestLog(r).Data["RequestID"]
+	}))
+	req, _ := http.NewRequest("GET", "/imdb/movies", nil)
+	req.Header.Set(HEADER_REQUEST_ID, "req-log")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if logged != "req-log" {
+		t.Errorf("Expected the request ID on the request logger, got %v", logged)
+	}
+	if ContextLog(nil).Data["RequestID"] != nil {
+		t.Errorf("Expected no request ID without a request")
+	}
+}
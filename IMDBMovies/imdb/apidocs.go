@@ -0,0 +1,515 @@
+/******************************************************************************
+ * \file        apidocs.go
+ *
+ * \brief       GO File that documents every REST Endpoint for the OpenAPI document
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/******************************************************************************************
+ *
+ * RouteDoc - documentation of a route. Body has the request body schemas by content
+ * type and Response the body of the Status response, each a *Schema or a value of the
+ * Go type the handler decodes or encodes. Errors are the error codes the handler
+ * responds with, those of the auth, tenant, rate limit and validation middlewares are
+ * added. Exclusive parameters cannot be sent together, and Requires has the parameters
+ * a parameter needs. The error codes of requests the Validation Middleware rejects are
+ * ERR_REQUEST_INVALID unless given. A StreamBody is read by the handler as it arrives
+ * and is not validated.
+ *
+*******************************************************************************************/
+type RouteDoc struct {
+	Tag             string
+	Summary         string
+	Description     string
+	Params          []Parameter
+	Body            map[string]interface{}
+	BodyDescription string
+	BodyOptional    bool
+	Status          int
+	Response        interface{}
+	ContentType     string
+	Responses       map[int]interface{}
+	Errors          []ErrorCode
+	Exclusive       [][]string
+	Requires        map[string][]string
+	ExclusiveError  *ErrorCode
+	RequiresError   *ErrorCode
+	BodyError       *ErrorCode
+	StreamBody      bool
+}
+
+// Tags the operations are grouped by
+const (
+	TAG_MOVIES   = "movies"
+	TAG_REVIEWS  = "reviews"
+	TAG_UPLOADS  = "uploads"
+	TAG_USERS    = "users"
+	TAG_WEBHOOKS = "webhooks"
+	TAG_TENANTS  = "tenants"
+	TAG_SERVICE  = "service"
+)
+
+var apiTags = []OpenAPITag{
+	{Name: TAG_MOVIES, Description: "Movies of the catalog"},
+	{Name: TAG_REVIEWS, Description: "Reviews of movies and their moderation"},
+	{Name: TAG_UPLOADS, Description: "Progress of movie uploads"},
+	{Name: TAG_USERS, Description: "Watchlists and personal ratings"},
+	{Name: TAG_WEBHOOKS, Description: "Webhook subscriptions and deliveries"},
+	{Name: TAG_TENANTS, Description: "Tenants and their catalogs"},
+	{Name: TAG_SERVICE, Description: "Version, documentation, logging, health and metrics"},
+}
+
+// Year bounds of the year query parameters, four digit years
+const (
+	MIN_QUERY_YEAR = 1000
+	MAX_QUERY_YEAR = 9999
+)
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+func errorCode(code ErrorCode) *ErrorCode {
+	return &code
+}
+
+func pathParam(name string, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Description: description, Required: true, Schema: schema}
+}
+
+func queryParam(name string, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// invalidWith sets the error code of invalid values of a parameter
+func invalidWith(code ErrorCode, param Parameter) Parameter {
+	param.Error = errorCode(code)
+	return param
+}
+
+func jsonBody(body interface{}) map[string]interface{} {
+	return map[string]interface{}{CONTENT_TYPE_JSON: body}
+}
+
+func objectIDSchema() *Schema {
+	return &Schema{Type: "string", Pattern: OBJECT_ID_PATTERN}
+}
+
+func yearSchema() *Schema {
+	return &Schema{Type: "integer", Minimum: bound(MIN_QUERY_YEAR), Maximum: bound(MAX_QUERY_YEAR)}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func reviewSortNames() []string {
+	names := make(map[string]bool)
+	for name := range reviewSorts {
+		names[name] = true
+	}
+	return sortedKeys(names)
+}
+
+func logLevelNames() []string {
+	names := []string{}
+	for _, level := range log.AllLevels {
+		names = append(names, level.String())
+	}
+	return append(names, "warn")
+}
+
+// Parameters shared by several routes
+var (
+	movieIDParam  = invalidWith(ERR_ID_INVALID, pathParam("id", "Id of the movie", objectIDSchema()))
+	reviewIDParam = invalidWith(ERR_ID_INVALID, pathParam("rid", "Id of the review", objectIDSchema()))
+	hookIDParam   = invalidWith(ERR_ID_INVALID, pathParam("id", "Id of the webhook", objectIDSchema()))
+	userParam     = pathParam("uid", "User, 'me' for the current user", &Schema{Type: "string"})
+	uidParam      = queryParam("uid", "User when authentication is disabled", &Schema{Type: "string"})
+	watchedParam  = invalidWith(ERR_WATCHED_INVALID, queryParam("watched", "Only watched or only unwatched movies", &Schema{Type: "boolean"}))
+	pageParams    = []Parameter{
+		invalidWith(ERR_PAGINATION_INVALID, queryParam("page", "Page number, from 1", &Schema{Type: "integer", Minimum: bound(1)})),
+		invalidWith(ERR_PAGINATION_INVALID, queryParam("per_page", "Reviews per page", &Schema{Type: "integer", Minimum: bound(1), Maximum: bound(MAX_PAGE_SIZE)})),
+	}
+)
+
+/******************************************************************************************
+ *
+ * Documentation of the routes by name, a route without documentation fails the
+ * OpenAPI tests
+ *
+*******************************************************************************************/
+var routeDocs = map[string]RouteDoc{
+	"GetVersion": {
+		Tag:     TAG_SERVICE,
+		Summary: "Get the name and version of the service",
+		Response: &Schema{Type: "object", Properties: map[string]*Schema{
+			"name": {Type: "string"}, "version": {Type: "string"}}},
+		Errors: []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"PostCSV": {
+		Tag:     TAG_MOVIES,
+		Summary: "Upload movies",
+		Description: "Upload movies as a CSV, gzip or zip file in a multipart form, a JSON array or newline delimited JSON. " +
+			"A gzip request body is sent with 'Content-Encoding: gzip'. The progress can be followed by the upload id.",
+		Params: []Parameter{
+			{Name: HEADER_UPLOAD_ID, In: "header", Description: "Id to follow the progress of the upload by",
+				Schema: &Schema{Type: "string", Pattern: uploadIDPattern.String()}, Error: errorCode(ERR_ID_INVALID)},
+			invalidWith(ERR_ID_INVALID, queryParam(PARAM_UPLOAD_ID, "Id of the upload when it is not sent in the header",
+				&Schema{Type: "string", Pattern: uploadIDPattern.String()})),
+		},
+		StreamBody: true,
+		Body: map[string]interface{}{
+			"multipart/form-data": &Schema{Type: "object", Required: []string{"file"}, Properties: map[string]*Schema{
+				"file":      {Type: "string", Format: "binary", Description: "CSV file, plain, gzip or zip"},
+				"profile":   {Type: "string", Description: "CSV mapping profile of the columns"},
+				"delimiter": {Type: "string", Description: "Single character delimiter instead of the one of the profile"},
+			}},
+			CONTENT_TYPE_JSON:      []MovieJSON{},
+			"application/x-ndjson": &Schema{Type: "string", Description: "One movie object per line"},
+		},
+		Response: UploadResults{},
+		Errors: []ErrorCode{ERR_CONTENT_TYPE_INVALID, ERR_UPLOAD_ID_IN_USE, ERR_FILE_TOO_BIG, ERR_FILE_INVALID,
+			ERR_FILE_INVALID_FORMAT, ERR_CSV_PROFILE_INVALID, ERR_DELIMITER_INVALID},
+	},
+	"GetMovies": {
+		Tag:     TAG_MOVIES,
+		Summary: "Get the top movies by year or year range and genre",
+		Description: "Top 10 movies ranked by rating. Without year and range the default year of the tenant is used. " +
+			"The year cannot be combined with a range, and a range needs both year_from and year_to.",
+		Params: []Parameter{
+			invalidWith(ERR_YEAR_INVALID, queryParam("year", "Year, e.g. 2015", yearSchema())),
+			invalidWith(ERR_YEAR_INVALID, queryParam("year_from", "First year of the range", yearSchema())),
+			invalidWith(ERR_YEAR_INVALID, queryParam("year_to", "Last year of the range, not before year_from", yearSchema())),
+			invalidWith(ERR_GENRE_INVALID, queryParam("genre", "Genre, e.g. Adventure", &Schema{Type: "string", Pattern: "."})),
+			watchedParam,
+			uidParam,
+		},
+		Exclusive:      [][]string{{"year", "year_from"}, {"year", "year_to"}},
+		Requires:       map[string][]string{"year_from": {"year_to"}, "year_to": {"year_from"}},
+		ExclusiveError: errorCode(ERR_YEAR_AND_RANGE),
+		RequiresError:  errorCode(ERR_YEAR_RANGE_INVALID),
+		Response:       []MovieGet{},
+		Errors: []ErrorCode{ERR_NO_CONTENT, ERR_GENRE_INVALID, ERR_YEAR_AND_RANGE, ERR_YEAR_INVALID, ERR_YEAR_RANGE_INVALID,
+			ERR_WATCHED_INVALID, ERR_USER_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"CreateMovie": {
+		Tag:       TAG_MOVIES,
+		Summary:   "Create a movie",
+		Body:      jsonBody(MovieJSON{}),
+		BodyError: errorCode(ERR_MOVIE_INVALID),
+		Status:    http.StatusCreated,
+		Response:  Movie{},
+		Errors:    []ErrorCode{ERR_MOVIE_INVALID, ERR_DUPLICATE, ERR_INTERNAL_SERVER},
+	},
+	"CleanMovies": {
+		Tag:     TAG_MOVIES,
+		Summary: "Delete all movies",
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"GetMovie": {
+		Tag:      TAG_MOVIES,
+		Summary:  "Get a movie",
+		Params:   []Parameter{movieIDParam},
+		Response: Movie{},
+		Errors:   []ErrorCode{ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"UpdateMovie": {
+		Tag:       TAG_MOVIES,
+		Summary:   "Update a movie",
+		Params:    []Parameter{movieIDParam},
+		Body:      jsonBody(MovieJSON{}),
+		BodyError: errorCode(ERR_MOVIE_INVALID),
+		Response:  Movie{},
+		Errors:    []ErrorCode{ERR_ID_INVALID, ERR_MOVIE_INVALID, ERR_NOT_FOUND, ERR_DUPLICATE, ERR_INTERNAL_SERVER},
+	},
+	"DeleteMovie": {
+		Tag:     TAG_MOVIES,
+		Summary: "Delete a movie",
+		Params:  []Parameter{movieIDParam},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetReviews": {
+		Tag:     TAG_REVIEWS,
+		Summary: "Get the approved reviews of a movie",
+		Params: append([]Parameter{movieIDParam}, append(pageParams,
+			invalidWith(ERR_PAGINATION_INVALID, queryParam("sort", "Order of the reviews", &Schema{Type: "string", Enum: reviewSortNames()})),
+			invalidWith(ERR_PAGINATION_INVALID, queryParam("status", "Status of the reviews, for administrators", &Schema{Type: "string",
+				Enum: []string{REVIEW_PENDING, REVIEW_APPROVED, REVIEW_REJECTED}})))...),
+		Response: ReviewPage{},
+		Errors:   []ErrorCode{ERR_ID_INVALID, ERR_PAGINATION_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"CreateReview": {
+		Tag:         TAG_REVIEWS,
+		Summary:     "Review a movie",
+		Description: "The review is pending moderation, and flagged if it uses banned words.",
+		Params:      []Parameter{movieIDParam, uidParam},
+		Body:        jsonBody(reviewBodySchema()),
+		BodyError:   errorCode(ERR_REVIEW_INVALID),
+		Status:      http.StatusCreated,
+		Response:    Review{},
+		Errors:      []ErrorCode{ERR_ID_INVALID, ERR_USER_INVALID, ERR_REVIEW_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"UpdateReview": {
+		Tag:         TAG_REVIEWS,
+		Summary:     "Edit a review",
+		Description: "Only the author can edit a review, it is moderated again.",
+		Params:      []Parameter{movieIDParam, reviewIDParam, uidParam},
+		Body:        jsonBody(reviewBodySchema()),
+		BodyError:   errorCode(ERR_REVIEW_INVALID),
+		Response:    Review{},
+		Errors:      []ErrorCode{ERR_ID_INVALID, ERR_REVIEW_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"DeleteReview": {
+		Tag:     TAG_REVIEWS,
+		Summary: "Delete a review, by its author or an administrator",
+		Params:  []Parameter{movieIDParam, reviewIDParam, uidParam},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"PostReviewHelpful": {
+		Tag:      TAG_REVIEWS,
+		Summary:  "Vote a review helpful, once per user",
+		Params:   []Parameter{movieIDParam, reviewIDParam, uidParam},
+		Response: Review{},
+		Errors:   []ErrorCode{ERR_ID_INVALID, ERR_USER_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetModerationQueue": {
+		Tag:     TAG_REVIEWS,
+		Summary: "Get the reviews pending moderation, flagged reviews first",
+		Params: append(append([]Parameter{}, pageParams...),
+			invalidWith(ERR_PAGINATION_INVALID, queryParam("flagged", "Only flagged or only unflagged reviews", &Schema{Type: "boolean"}))),
+		Response: ReviewPage{},
+		Errors:   []ErrorCode{ERR_PAGINATION_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"ModerateReview": {
+		Tag:     TAG_REVIEWS,
+		Summary: "Approve or reject a review",
+		Params:  []Parameter{reviewIDParam},
+		Body: jsonBody(&Schema{Type: "object", Required: []string{"status"}, Properties: map[string]*Schema{
+			"status": {Type: "string", Enum: []string{REVIEW_APPROVED, REVIEW_REJECTED}},
+			"reason": {Type: "string"},
+		}}),
+		BodyError: errorCode(ERR_REVIEW_STATUS_INVALID),
+		Response:  Review{},
+		Errors:    []ErrorCode{ERR_ID_INVALID, ERR_REVIEW_STATUS_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetUploads": {
+		Tag:      TAG_UPLOADS,
+		Summary:  "List running and recently finished uploads",
+		Response: []UploadProgressEvent{},
+	},
+	"GetUploadEvents": {
+		Tag:         TAG_UPLOADS,
+		Summary:     "Stream the progress of an upload",
+		Description: "Server-Sent Events: 'progress' events while the upload runs and a 'summary' event when it ends.",
+		Params:      []Parameter{invalidWith(ERR_NOT_FOUND, pathParam("id", "Id of the upload", &Schema{Type: "string", Pattern: uploadIDPattern.String()}))},
+		Response:    &Schema{Type: "string"},
+		ContentType: "text/event-stream",
+		Errors:      []ErrorCode{ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetWatchlist": {
+		Tag:      TAG_USERS,
+		Summary:  "Get the watchlist of a user",
+		Params:   []Parameter{userParam, watchedParam},
+		Response: []WatchlistEntry{},
+		Errors:   []ErrorCode{ERR_USER_INVALID, ERR_WATCHED_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"PutWatchlistMovie": {
+		Tag:     TAG_USERS,
+		Summary: "Add a movie to the watchlist or change its watched state",
+		Params:  []Parameter{userParam, movieIDParam},
+		Body: jsonBody(&Schema{Type: "object", Properties: map[string]*Schema{
+			"watched": {Type: "boolean"},
+		}}),
+		BodyOptional: true,
+		BodyError:    errorCode(ERR_WATCHED_INVALID),
+		Response:     WatchlistEntry{},
+		Errors:       []ErrorCode{ERR_USER_INVALID, ERR_ID_INVALID, ERR_WATCHED_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"DeleteWatchlistMovie": {
+		Tag:     TAG_USERS,
+		Summary: "Remove a movie from the watchlist",
+		Params:  []Parameter{userParam, movieIDParam},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_USER_INVALID, ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetUserRatings": {
+		Tag:      TAG_USERS,
+		Summary:  "Get the personal ratings of a user",
+		Params:   []Parameter{userParam},
+		Response: []UserRating{},
+		Errors:   []ErrorCode{ERR_USER_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"PutUserRating": {
+		Tag:     TAG_USERS,
+		Summary: "Rate a movie, the community rating of the movie is updated",
+		Params:  []Parameter{userParam, movieIDParam},
+		Body: jsonBody(&Schema{Type: "object", Required: []string{"rating"}, Properties: map[string]*Schema{
+			"rating": {Type: "integer", Minimum: bound(MIN_RATING), Maximum: bound(MAX_RATING)},
+		}}),
+		BodyError: errorCode(ERR_RATING_INVALID),
+		Response:  UserRating{},
+		Errors:    []ErrorCode{ERR_USER_INVALID, ERR_ID_INVALID, ERR_RATING_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"DeleteUserRating": {
+		Tag:     TAG_USERS,
+		Summary: "Remove the rating of a movie",
+		Params:  []Parameter{userParam, movieIDParam},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_USER_INVALID, ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetWebhooks": {
+		Tag:      TAG_WEBHOOKS,
+		Summary:  "List webhook subscriptions",
+		Response: []Webhook{},
+		Errors:   []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"CreateWebhook": {
+		Tag:         TAG_WEBHOOKS,
+		Summary:     "Subscribe a URL to events",
+		Description: "Deliveries are signed with the secret, a random one is created without it and only returned here.",
+		Body: jsonBody(&Schema{Type: "object", Required: []string{"url"}, Properties: map[string]*Schema{
+			"url":    {Type: "string", Format: "uri"},
+			"events": {Type: "array", Items: &Schema{Type: "string", Enum: sortedKeys(webhookEvents)}},
+			"secret": {Type: "string"},
+		}}),
+		BodyError: errorCode(ERR_WEBHOOK_INVALID),
+		Status:    http.StatusCreated,
+		Response:  Webhook{},
+		Errors:    []ErrorCode{ERR_WEBHOOK_INVALID, ERR_INTERNAL_SERVER},
+	},
+	"DeleteWebhook": {
+		Tag:     TAG_WEBHOOKS,
+		Summary: "Delete a webhook subscription and its delivery log",
+		Params:  []Parameter{hookIDParam},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetWebhookDeliveries": {
+		Tag:      TAG_WEBHOOKS,
+		Summary:  "List the latest delivery attempts of a webhook",
+		Params:   []Parameter{hookIDParam},
+		Response: []DeliveryAttempt{},
+		Errors:   []ErrorCode{ERR_ID_INVALID, ERR_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetTenants": {
+		Tag:      TAG_TENANTS,
+		Summary:  "List tenants",
+		Response: []Tenant{},
+		Errors:   []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"CreateTenant": {
+		Tag:     TAG_TENANTS,
+		Summary: "Create a tenant, zero settings fall back to the config file",
+		Body: jsonBody(&Schema{Type: "object", Required: []string{"name"}, Properties: map[string]*Schema{
+			"name":        {Type: "string", Pattern: tenantNamePattern.String()},
+			"defaultyear": {Type: "integer", Minimum: bound(0), Maximum: bound(MAX_QUERY_YEAR)},
+			"filesizekb":  {Type: "integer", Format: "int64", Minimum: bound(0)},
+		}}),
+		BodyError: errorCode(ERR_TENANT_INVALID),
+		Status:    http.StatusCreated,
+		Response:  Tenant{},
+		Errors:    []ErrorCode{ERR_TENANT_INVALID, ERR_TENANT_EXISTS, ERR_INTERNAL_SERVER},
+	},
+	"DeleteTenant": {
+		Tag:     TAG_TENANTS,
+		Summary: "Delete a tenant and all of its movies",
+		Params:  []Parameter{pathParam("tenant", "Name of the tenant", &Schema{Type: "string"})},
+		Status:  http.StatusNoContent,
+		Errors:  []ErrorCode{ERR_TENANT_NOT_FOUND, ERR_INTERNAL_SERVER},
+	},
+	"GetEndpoints": {
+		Tag:         TAG_SERVICE,
+		Summary:     "Get the OpenAPI document as YAML",
+		Response:    &Schema{Type: "string"},
+		ContentType: CONTENT_TYPE_YAML,
+		Errors:      []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"GetOpenAPI": {
+		Tag:      TAG_SERVICE,
+		Summary:  "Get the OpenAPI document as JSON",
+		Response: &Schema{Type: "object"},
+		Errors:   []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"GetOpenAPIYAML": {
+		Tag:         TAG_SERVICE,
+		Summary:     "Get the OpenAPI document as YAML",
+		Response:    &Schema{Type: "string"},
+		ContentType: CONTENT_TYPE_YAML,
+		Errors:      []ErrorCode{ERR_INTERNAL_SERVER},
+	},
+	"GetDocs": {
+		Tag:         TAG_SERVICE,
+		Summary:     "Interactive API documentation",
+		Response:    &Schema{Type: "string"},
+		ContentType: "text/html",
+	},
+	"GetDocsAsset": {
+		Tag:         TAG_SERVICE,
+		Summary:     "Script, style sheet or image of the interactive API documentation",
+		Params:      []Parameter{pathParam("file", "File name", &Schema{Type: "string"})},
+		Response:    &Schema{Type: "string", Format: "binary"},
+		ContentType: "application/octet-stream",
+		Responses:   map[int]interface{}{http.StatusNotFound: nil},
+	},
+	"GetLogLevels": {
+		Tag:      TAG_SERVICE,
+		Summary:  "Get the log level of every module",
+		Response: LogLevels{},
+	},
+	"PutLogLevel": {
+		Tag:     TAG_SERVICE,
+		Summary: "Change the log level of a module, or of all modules without a module, until the next restart",
+		Body: jsonBody(&Schema{Type: "object", Required: []string{"level"}, Properties: map[string]*Schema{
+			"level":  {Type: "string", Enum: logLevelNames()},
+			"module": {Type: "string"},
+		}}),
+		BodyError: errorCode(ERR_LOG_LEVEL_INVALID),
+		Response:  LogLevels{},
+		Errors:    []ErrorCode{ERR_LOG_LEVEL_INVALID},
+	},
+	"GetHealth": {
+		Tag:      TAG_SERVICE,
+		Summary:  "Liveness, the process is up and serving requests",
+		Response: Health{},
+	},
+	"GetReady": {
+		Tag:       TAG_SERVICE,
+		Summary:   "Readiness, the database answers, the movie indexes exist and the config file was loaded",
+		Response:  Health{},
+		Responses: map[int]interface{}{http.StatusServiceUnavailable: Health{}},
+	},
+	"GetMetrics": {
+		Tag:         TAG_SERVICE,
+		Summary:     "Metrics in the Prometheus exposition format",
+		Response:    &Schema{Type: "string"},
+		ContentType: "text/plain",
+	},
+}
+
+// reviewBodySchema of a review written by a user, the other fields are set by the service
+func reviewBodySchema() *Schema {
+	return &Schema{Type: "object", Required: []string{"text"}, Properties: map[string]*Schema{
+		"title": {Type: "string"},
+		"text":  {Type: "string"},
+	}}
+}
@@ -0,0 +1,403 @@
+/******************************************************************************
+ * \file        auth.go
+ *
+ * \brief       GO File that authenticates API clients and authorizes them by role
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Role of an API client, each role includes the permissions of the roles before it
+type Role int
+
+const (
+	ROLE_PUBLIC Role = iota
+	ROLE_READER
+	ROLE_UPLOADER
+	ROLE_ADMIN
+)
+
+var roleNames = map[Role]string{
+	ROLE_PUBLIC:   "public",
+	ROLE_READER:   "reader",
+	ROLE_UPLOADER: "uploader",
+	ROLE_ADMIN:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+/******************************************************************************************
+ *
+ * Parse a role name, public is not a role that can be given to a client
+ *
+*******************************************************************************************/
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if role != ROLE_PUBLIC && strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return ROLE_PUBLIC, fmt.Errorf("unknown role %q, expected reader, uploader or admin", name)
+}
+
+// AuthOptions struct for authentication settings in config file
+type AuthOptions struct {
+	Enabled bool        `toml:"enabled"`
+	Methods []string    `toml:"methods"`
+	JWT     JWTOptions  `toml:"jwt"`
+	Cert    CertOptions `toml:"cert"`
+}
+
+// Authentication methods
+const (
+	AUTH_METHOD_API_KEY = "apikey"
+	AUTH_METHOD_JWT     = "jwt"
+	AUTH_METHOD_CERT    = "cert"
+)
+
+// API Key request headers, "Authorization: ApiKey <key>" is accepted as well
+const (
+	HEADER_API_KEY      = "X-API-Key"
+	AUTH_SCHEME_API_KEY = "ApiKey"
+	APIKEYS_COLLECTION  = "apikeys"
+	API_KEY_PREFIX      = "imdb_"
+	API_KEY_USAGE       = "usage: apikey create <name> <role> [tenant] | apikey list | apikey revoke <name>"
+)
+
+// errInvalidCredentials is returned when credentials were given but are not valid
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// APIKey struct for an API key, only the SHA-256 hash of the key is stored
+type APIKey struct {
+	ID      bson.ObjectId `bson:"_id" json:"id"`
+	Name    string        `bson:"name" json:"name"`
+	Hash    string        `bson:"hash" json:"-"`
+	Role    string        `bson:"role" json:"role"`
+	Tenant  string        `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Created time.Time     `bson:"created" json:"created"`
+}
+
+// Principal is the authenticated client of a request, a Tenant binds it to
+// the catalog of that tenant
+type Principal struct {
+	Subject string
+	Role    Role
+	Method  string
+	Tenant  string
+}
+
+type principalKey struct{}
+
+// Authenticator finds the client of a request, nil without an error if the
+// request carries no credentials it knows. Scheme is the WWW-Authenticate challenge.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	Scheme() string
+}
+
+// APIKeyStore finds API keys by the hash of the key
+type APIKeyStore interface {
+	FindAPIKey(hash string) (*APIKey, error)
+}
+
+/******************************************************************************************
+ *
+ * Auth - authenticators asked in order, nil when authentication is disabled
+ *
+*******************************************************************************************/
+type Auth struct {
+	Authenticators []Authenticator
+}
+
+var auth *Auth
+
+/******************************************************************************************
+ *
+ * Create Auth from config, API keys are the default method
+ *
+*******************************************************************************************/
+func NewAuth(options AuthOptions, store APIKeyStore) (*Auth, error) {
+	methods := options.Methods
+	if len(methods) == 0 {
+		methods = []string{AUTH_METHOD_API_KEY}
+	}
+
+	a := new(Auth)
+	for _, method := range methods {
+		switch method {
+		case AUTH_METHOD_API_KEY:
+			a.Authenticators = append(a.Authenticators, &APIKeyAuthenticator{Store: store})
+		case AUTH_METHOD_JWT:
+			authenticator, err := NewJWTAuthenticator(options.JWT)
+			if err != nil {
+				return nil, err
+			}
+			a.Authenticators = append(a.Authenticators, authenticator)
+		case AUTH_METHOD_CERT:
+			authenticator, err := NewCertAuthenticator(options.Cert)
+			if err != nil {
+				return nil, err
+			}
+			a.Authenticators = append(a.Authenticators, authenticator)
+		default:
+			return nil, fmt.Errorf("auth: unknown method %q, expected apikey, jwt or cert", method)
+		}
+	}
+	return a, nil
+}
+
+/******************************************************************************************
+ *
+ * Authenticate a request with the first authenticator that knows its credentials
+ *
+*******************************************************************************************/
+func (a *Auth) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a.Authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil || principal != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+/******************************************************************************************
+ *
+ * Authentication Middleware - roles maps route names to the least role allowed
+ *
+*******************************************************************************************/
+func AuthMiddleware(roles map[string]Role) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			required, ok := roles[RouteName(r)]
+			if !ok {
+				required = ROLE_ADMIN
+			}
+			if auth == nil || required == ROLE_PUBLIC {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := auth.Authenticate(r)
+			if err != nil && err != errInvalidCredentials {
+				RequestLog(r).WithFields(log.Fields{"Path": r.URL.Path, "err": err}).Error("Authentication error")
+				respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+				return
+			}
+			if principal == nil {
+				RequestLog(r).WithFields(log.Fields{"Path": r.URL.Path, "err": err}).Info("Authentication failed")
+				for _, authenticator := range auth.Authenticators {
+					if scheme := authenticator.Scheme(); len(scheme) > 0 {
+						w.Header().Add("WWW-Authenticate", scheme)
+					}
+				}
+				respondWithErrorCode(w, ERR_UNAUTHORIZED)
+				return
+			}
+			if principal.Role < required {
+				RequestLog(r).WithFields(log.Fields{"Path": r.URL.Path, "Subject": principal.Subject,
+					"Role": principal.Role.String()}).Info("Permission denied")
+				respondWithErrorCode(w, ERR_FORBIDDEN)
+				return
+			}
+			setRequestSubject(r, principal.Subject)
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
+
+/******************************************************************************************
+ *
+ * Authenticated client of a request, nil for public endpoints or without auth
+ *
+*******************************************************************************************/
+func RequestPrincipal(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+/******************************************************************************************
+ *
+ * Logger with the request ID and authenticated subject of a request
+ *
+*******************************************************************************************/
+func RequestLog(r *http.Request) *log.Entry {
+	fields := log.Fields{}
+	if principal := RequestPrincipal(r); principal != nil {
+		fields["Subject"] = principal.Subject
+		fields["AuthMethod"] = principal.Method
+	}
+	return ContextLog(r.Context()).WithFields(fields)
+}
+
+/******************************************************************************************
+ *
+ * API Key Authenticator
+ *
+*******************************************************************************************/
+type APIKeyAuthenticator struct {
+	Store APIKeyStore
+}
+
+func (a *APIKeyAuthenticator) Scheme() string {
+	return AUTH_SCHEME_API_KEY
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(HEADER_API_KEY)
+	if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, AUTH_SCHEME_API_KEY) {
+		key = strings.TrimSpace(value)
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	apiKey, err := a.Store.FindAPIKey(HashAPIKey(key))
+	if err == mgo.ErrNotFound {
+		return nil, errInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	role, err := ParseRole(apiKey.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Subject: apiKey.Name, Role: role, Method: AUTH_METHOD_API_KEY, Tenant: apiKey.Tenant}, nil
+}
+
+// HashAPIKey returns the hex SHA-256 of a key as it is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey generates a random API key
+func newAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return API_KEY_PREFIX + hex.EncodeToString(b)
+}
+
+/******************************************************************************************
+ *
+ * API Key Database Access
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindAPIKey(hash string) (*APIKey, error) {
+	var key APIKey
+	done := m.operation("FindAPIKey", APIKEYS_COLLECTION)
+	err := done(db().C(APIKEYS_COLLECTION).Find(bson.M{"hash": hash}).One(&key))
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (m *MoviesDAO) FindAPIKeys() ([]APIKey, error) {
+	keys := []APIKey{}
+	done := m.operation("FindAPIKeys", APIKEYS_COLLECTION)
+	err := db().C(APIKEYS_COLLECTION).Find(nil).Sort("name").All(&keys)
+	return keys, done(err)
+}
+
+func (m *MoviesDAO) InsertAPIKey(key APIKey) error {
+	done := m.operation("InsertAPIKey", APIKEYS_COLLECTION)
+	return done(db().C(APIKEYS_COLLECTION).Insert(&key))
+}
+
+func (m *MoviesDAO) DeleteAPIKey(name string) error {
+	done := m.operation("DeleteAPIKey", APIKEYS_COLLECTION)
+	return done(db().C(APIKEYS_COLLECTION).Remove(bson.M{"name": name}))
+}
+
+/******************************************************************************************
+ *
+ * Admin command to manage API keys:
+ *   apikey create <name> <role> [tenant]   prints the new key, it is not shown again
+ *   apikey list
+ *   apikey revoke <name>
+ *
+*******************************************************************************************/
+func RunAPIKeyCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(API_KEY_USAGE)
+	}
+
+	switch {
+	case args[0] == "create" && (len(args) == 3 || len(args) == 4):
+		role, err := ParseRole(args[2])
+		if err != nil {
+			return err
+		}
+		// a key for a tenant can only be used on the catalog of that tenant
+		tenant := ""
+		if len(args) == 4 {
+			tenant = args[3]
+			if _, err := dao.FindTenant(tenant); err != nil {
+				return fmt.Errorf("tenant %q: %v", tenant, err)
+			}
+		}
+		key := newAPIKey()
+		err = dao.InsertAPIKey(APIKey{
+			ID:      bson.NewObjectId(),
+			Name:    args[1],
+			Hash:    HashAPIKey(key),
+			Role:    role.String(),
+			Tenant:  tenant,
+			Created: time.Now().UTC(),
+		})
+		if mgo.IsDup(err) {
+			return fmt.Errorf("an API key named %q already exists", args[1])
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, key)
+
+	case args[0] == "list" && len(args) == 1:
+		keys, err := dao.FindAPIKeys()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tROLE\tTENANT\tCREATED")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.Name, key.Role, key.Tenant, key.Created.Format(time.RFC3339))
+		}
+		return tw.Flush()
+
+	case args[0] == "revoke" && len(args) == 2:
+		if err := dao.DeleteAPIKey(args[1]); err == mgo.ErrNotFound {
+			return fmt.Errorf("no API key named %q", args[1])
+		} else if err != nil {
+			return err
+		}
+
+	default:
+		return errors.New(API_KEY_USAGE)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/******************************************************************************
+ * \file        auth_test.go
+ *
+ * \brief       GO File that tests API key authentication and roles
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+// memoryAPIKeyStore keeps API keys by hash for testing without a database
+type memoryAPIKeyStore map[string]*APIKey
+
+func (s memoryAPIKeyStore) FindAPIKey(hash string) (*APIKey, error) {
+	if key, ok := s[hash]; ok {
+		return key, nil
+	}
+	return nil, mgo.ErrNotFound
+}
+
+/******************************************************************************************
+ *
+ * Enable API key authentication with a key per role for a test
+ *
+*******************************************************************************************/
+func withAPIKeys(t *testing.T) {
+	store := memoryAPIKeyStore{}
+	for _, role := range []string{"reader", "uploader", "admin"} {
+		store[HashAPIKey(role+"-key")] = &APIKey{Name: role + "-client", Role: role}
+	}
+	auth = &Auth{Authenticators: []Authenticator{&APIKeyAuthenticator{Store: store}}}
+	t.Cleanup(func() { auth = nil })
+}
+
+func serveWithKey(method string, path string, key string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if len(key) > 0 {
+		req.Header.Set(HEADER_API_KEY, key)
+	}
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+func checkResponseCode(t *testing.T, expected int, actual int) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected response code %d, got %d", expected, actual)
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for name, expected := range map[string]Role{"reader": ROLE_READER, "Uploader": ROLE_UPLOADER, "ADMIN": ROLE_ADMIN} {
+		if role, err := ParseRole(name); err != nil || role != expected {
+			t.Errorf("ParseRole(%q) = %v, %v", name, role, err)
+		}
+	}
+	for _, name := range []string{"public", "", "root"} {
+		if _, err := ParseRole(name); err == nil {
+			t.Errorf("ParseRole(%q) expected an error", name)
+		}
+	}
+}
+
+func TestAuthMissingKey(t *testing.T) {
+	withAPIKeys(t)
+
+	response := serveWithKey("GET", "/imdb/uploads", "")
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+	if response.Header().Get("WWW-Authenticate") != AUTH_SCHEME_API_KEY {
+		t.Errorf("Expected WWW-Authenticate header, got %q", response.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAuthInvalidKey(t *testing.T) {
+	withAPIKeys(t)
+
+	response := serveWithKey("GET", "/imdb/uploads", "not-a-key")
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
+
+func TestAuthPublicEndpoint(t *testing.T) {
+	withAPIKeys(t)
+
+	response := serveWithKey("GET", "/imdb/version", "")
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestAuthRoles(t *testing.T) {
+	withAPIKeys(t)
+
+	// the uploads list needs the uploader role and no database
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/uploads", "reader-key").Code)
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/uploads", "uploader-key").Code)
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/uploads", "admin-key").Code)
+
+	// wiping or deleting movies needs an admin credential
+	checkResponseCode(t, http.StatusUnauthorized, serveWithKey("DELETE", "/imdb/movies", "").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("DELETE", "/imdb/movies", "uploader-key").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("DELETE", "/imdb/movies/5b1e4b0a9d1f2a3b4c5d6e7f", "uploader-key").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("POST", "/imdb/webhooks", "uploader-key").Code)
+}
+
+func TestAuthAuthorizationHeader(t *testing.T) {
+	withAPIKeys(t)
+
+	req, _ := http.NewRequest("GET", "/imdb/uploads", nil)
+	req.Header.Set("Authorization", "ApiKey uploader-key")
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestHashAPIKey(t *testing.T) {
+	key := newAPIKey()
+	if HashAPIKey(key) == key || len(HashAPIKey(key)) != 64 {
+		t.Errorf("Expected a hex SHA-256 hash of the key")
+	}
+	if HashAPIKey(key) != HashAPIKey(key) {
+		t.Errorf("Expected the hash of a key to be stable")
+	}
+}
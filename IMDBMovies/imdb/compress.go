@@ -0,0 +1,210 @@
+/******************************************************************************
+ * \file        compress.go
+ *
+ * \brief       GO File that handles compressed (gzip/zip) movie uploads
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// Magic bytes used to detect the upload format
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte{0x50, 0x4b, 0x03, 0x04}
+	zipEmpty  = []byte{0x50, 0x4b, 0x05, 0x06}
+)
+
+// Upload formats detected from magic bytes
+const (
+	FORMAT_PLAIN = "csv"
+	FORMAT_GZIP  = "gzip"
+	FORMAT_ZIP   = "zip"
+)
+
+// errTooLarge is returned once the decompressed upload exceeds the size limit
+var errTooLarge = errors.New("decompressed upload exceeds maximum upload size")
+
+/******************************************************************************************
+ *
+ * Size Limiter - counts decompressed bytes shared across all files of one upload
+ * and fails the read once the limit is crossed. This blocks zip/gzip bombs.
+ *
+*******************************************************************************************/
+type sizeLimiter struct {
+	Remaining int64
+	Exceeded  bool
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *sizeLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.limiter.Remaining < 0 {
+		l.limiter.Exceeded = true
+		return 0, errTooLarge
+	}
+	// read one byte past the limit so an exact fit is not reported as too large
+	if int64(len(p)) > l.limiter.Remaining+1 {
+		p = p[:l.limiter.Remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.limiter.Remaining -= int64(n)
+	if l.limiter.Remaining < 0 {
+		l.limiter.Exceeded = true
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+func (s *sizeLimiter) Wrap(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: s}
+}
+
+/******************************************************************************************
+ *
+ * Detect upload format using magic bytes, returns the format and a reader that
+ * still yields the peeked bytes
+ *
+*******************************************************************************************/
+func DetectFormat(r io.Reader) (string, io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", br, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return FORMAT_GZIP, br, nil
+	case bytes.HasPrefix(magic, zipMagic), bytes.HasPrefix(magic, zipEmpty):
+		return FORMAT_ZIP, br, nil
+	}
+	return FORMAT_PLAIN, br, nil
+}
+
+/******************************************************************************************
+ *
+ * Open a gzip stream with its decompressed size limited
+ *
+*******************************************************************************************/
+func OpenGzip(r io.Reader, limiter *sizeLimiter) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{limiter.Wrap(gz), gz}, nil
+}
+
+/******************************************************************************************
+ *
+ * Return true if a zip entry should be imported as a CSV file
+ *
+*******************************************************************************************/
+func IsCSVEntry(f *zip.File) bool {
+	if f.FileInfo().IsDir() {
+		return false
+	}
+	name := path.Base(f.Name)
+	// skip resource forks and hidden files added by archivers
+	if strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+		return false
+	}
+	lname := strings.ToLower(name)
+	return strings.HasSuffix(lname, ".csv") || strings.HasSuffix(lname, ".csv.gz")
+}
+
+/******************************************************************************************
+ *
+ * Import every CSV file in a zip archive. Each file gets its own result counts,
+ * the totals are accumulated into results.
+ *
+*******************************************************************************************/
+func ImportZip(ra io.ReaderAt, size int64, limiter *sizeLimiter, profile *CSVProfile, results *UploadResults) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return errInvalidFormat
+	}
+
+	// the declared sizes are checked before any entry is stored, the limiter
+	// still stops an entry that decompresses to more than it declares
+	var declared uint64
+	for _, f := range zr.File {
+		if IsCSVEntry(f) {
+			declared += f.UncompressedSize64
+		}
+	}
+	if limiter.Remaining < 0 || declared > uint64(limiter.Remaining) {
+		limiter.Exceeded = true
+		return errTooLarge
+	}
+
+	for _, f := range zr.File {
+		if !IsCSVEntry(f) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			results.AddFile(FileUploadResults{FileName: f.Name, Error: ErrorMsg(ERR_FILE_INVALID)})
+			continue
+		}
+
+		fileResults := FileUploadResults{FileName: f.Name}
+		fileResults.progress = results.progress
+		fileResults.catalog = results.catalog
+		err = importZipEntry(rc, limiter, profile, &fileResults.UploadResults)
+		rc.Close()
+
+		if limiter.Exceeded {
+			return errTooLarge
+		}
+		var missingErr *MissingColumnsError
+		if errors.As(err, &missingErr) {
+			fileResults.Error = ErrorMsg(ERR_FILE_INVALID_FORMAT) + ". " + missingErr.Error()
+		} else if err != nil {
+			fileResults.Error = ErrorMsg(ERR_FILE_INVALID_FORMAT)
+		}
+		results.AddFile(fileResults)
+	}
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Import a single zip entry, which may itself be gzip compressed
+ *
+*******************************************************************************************/
+func importZipEntry(rc io.Reader, limiter *sizeLimiter, profile *CSVProfile, results *UploadResults) error {
+	format, reader, err := DetectFormat(rc)
+	if err != nil {
+		return err
+	}
+	if format != FORMAT_GZIP {
+		return ImportCSV(limiter.Wrap(reader), profile, results)
+	}
+
+	gz, err := OpenGzip(reader, limiter)
+	if err != nil {
+		return errInvalidFormat
+	}
+	defer gz.Close()
+	return ImportCSV(gz, profile, results)
+}
@@ -0,0 +1,229 @@
+/******************************************************************************
+ * \file        compress_test.go
+ *
+ * \brief       GO File that has tests for compressed upload handling
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+		"archive/zip"
+		"bytes"
+		"compress/gzip"
+		"fmt"
+		"io/ioutil"
+		"mime/multipart"
+		"net/http"
+		"net/http/httptest"
+		"encoding/json"
+		"strings"
+		"time"
+
+		log "github.com/sirupsen/logrus"
+)
+
+/******************************************************************************************
+ *
+ * Test format detection by magic bytes
+ *
+*******************************************************************************************/
+func TestDetectFormat(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("Rank,Title\n"))
+	w.Close()
+
+	var zb bytes.Buffer
+	zw := zip.NewWriter(&zb)
+	zw.Create("movies.csv")
+	zw.Close()
+
+	cases := map[string][]byte{
+		FORMAT_PLAIN: []byte("Rank,Title\n"),
+		FORMAT_GZIP:  gz.Bytes(),
+		FORMAT_ZIP:   zb.Bytes(),
+	}
+
+	for expected, content := range cases {
+		format, reader, err := DetectFormat(bytes.NewReader(content))
+		if err != nil || format != expected {
+			t.Errorf("TestDetectFormat Failed for %s", expected)
+		}
+		// peeked bytes must still be readable
+		data, _ := ioutil.ReadAll(reader)
+		if !bytes.Equal(data, content) {
+			t.Errorf("TestDetectFormat Failed to preserve content for %s", expected)
+		}
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that the decompressed size limit stops a gzip bomb
+ *
+*******************************************************************************************/
+func TestGzipSizeLimit(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bytes.Repeat([]byte("a"), 1024*1024))
+	w.Close()
+
+	limiter := &sizeLimiter{Remaining: 1024}
+	reader, err := OpenGzip(&gz, limiter)
+	if err != nil {
+		t.Fatalf("TestGzipSizeLimit Failed to open gzip")
+	}
+	_, err = ioutil.ReadAll(reader)
+	if err != errTooLarge || !limiter.Exceeded {
+		t.Errorf("TestGzipSizeLimit Failed")
+	}
+
+	// content of exactly the limit is accepted
+	limiter = &sizeLimiter{Remaining: 4}
+	data, err := ioutil.ReadAll(limiter.Wrap(strings.NewReader("abcd")))
+	if err != nil || string(data) != "abcd" || limiter.Exceeded {
+		t.Errorf("TestGzipSizeLimit Failed for exact size")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a zip bomb entry aborts the zip import
+ *
+*******************************************************************************************/
+func TestZipSizeLimit(t *testing.T) {
+	var zb bytes.Buffer
+	zw := zip.NewWriter(&zb)
+	f, _ := zw.Create("bomb.csv")
+	f.Write(bytes.Repeat([]byte("a"), 1024*1024))
+	zw.Close()
+
+	limiter := &sizeLimiter{Remaining: 1024}
+	results := new(UploadResults)
+	err := ImportZip(bytes.NewReader(zb.Bytes()), int64(zb.Len()), limiter, &CSVProfile{}, results)
+	if err != errTooLarge {
+		t.Errorf("TestZipSizeLimit Failed")
+	}
+
+	// the declared sizes are checked before the first entry is imported
+	zb.Reset()
+	zw = zip.NewWriter(&zb)
+	f, _ = zw.Create("movies.csv")
+	f.Write([]byte("Rank,Title,Year,Runtime,Rating,Votes\n"))
+	f, _ = zw.Create("bomb.csv")
+	f.Write(bytes.Repeat([]byte("a"), 1024*1024))
+	zw.Close()
+
+	limiter = &sizeLimiter{Remaining: 1024}
+	results = new(UploadResults)
+	err = ImportZip(bytes.NewReader(zb.Bytes()), int64(zb.Len()), limiter, &CSVProfile{}, results)
+	if err != errTooLarge || len(results.Files) != 0 {
+		t.Errorf("TestZipSizeLimit Failed to check the declared sizes first, got %v %+v", err, results.Files)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test zip entry filtering
+ *
+*******************************************************************************************/
+func TestIsCSVEntry(t *testing.T) {
+	cases := map[string]bool{
+		"movies.csv":             true,
+		"2018/MOVIES.CSV":        true,
+		"movies.csv.gz":          true,
+		"readme.txt":             false,
+		"__MACOSX/._movies.csv":  false,
+		"dir/":                   false,
+	}
+	for name, expected := range cases {
+		f := &zip.File{FileHeader: zip.FileHeader{Name: name}}
+		if IsCSVEntry(f) != expected {
+			t.Errorf("TestIsCSVEntry Failed for %s", name)
+		}
+	}
+}
+
+// postUpload posts content as the file of a multipart upload
+func postUpload(t *testing.T, name string, content []byte) *httptest.ResponseRecorder {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/imdb/uploadmovies", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+/******************************************************************************************
+ *
+ * Test that a truncated gzip upload is rejected instead of read forever
+ *
+*******************************************************************************************/
+func TestPostCSVTruncatedGzip(t *testing.T) {
+	withAccessLog(t)
+
+	// rows without a rank are skipped, so nothing is stored
+	var csv bytes.Buffer
+	csv.WriteString("Rank,Title,Year,Runtime (Minutes),Rating,Votes\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&csv, ",Movie %d,2016,%d,7.5,%d\n", i, 90+i%60, i*7)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(csv.Bytes())
+	w.Close()
+	truncated := gz.Bytes()[:gz.Len()/2]
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postUpload(t, "movies.csv.gz", truncated) }()
+	select {
+	case response := <-done:
+		checkResponseCode(t, HTTPCode(ERR_FILE_INVALID_FORMAT), response.Code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("TestPostCSVTruncatedGzip did not finish")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a gzip upload over the size limit reports the records read before
+ *
+*******************************************************************************************/
+func TestPostCSVGzipTooLargeResults(t *testing.T) {
+	withAccessLog(t)
+	withDefaultLogging(t)
+	ingestLog.SetLevel(log.ErrorLevel)
+
+	// rows with an invalid year are counted without being stored
+	var csv bytes.Buffer
+	csv.WriteString("Rank,Title,Year,Runtime (Minutes),Rating,Votes\n")
+	for csv.Len() <= int(defaultMaxUploadSize()) {
+		csv.WriteString("1,Movie,year,90,7.5,100\n")
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(csv.Bytes())
+	w.Close()
+
+	response := postUpload(t, "movies.csv.gz", gz.Bytes())
+	checkResponseCode(t, HTTPCode(ERR_FILE_TOO_BIG), response.Code)
+	var errjson struct {
+		Error   string
+		Results *UploadResults
+	}
+	json.Unmarshal(response.Body.Bytes(), &errjson)
+	if errjson.Error != ErrorMsg(ERR_FILE_TOO_BIG) || errjson.Results == nil || errjson.Results.RecordsRead == 0 {
+		t.Errorf("Expected the records read before the limit, got %s", response.Body.String())
+	}
+}
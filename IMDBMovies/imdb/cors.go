@@ -0,0 +1,275 @@
+/******************************************************************************
+ * \file        cors.go
+ *
+ * \brief       GO File that answers CORS preflight requests and adds CORS headers
+ *              for browser clients on other origins
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions struct for the CORS policy in config file
+type CORSOptions struct {
+	Enabled       bool     `toml:"enabled"`
+	Origins       []string `toml:"origins"`
+	Methods       []string `toml:"methods"`
+	Headers       []string `toml:"headers"`
+	ExposeHeaders []string `toml:"exposeheaders"`
+	Credentials   bool     `toml:"credentials"`
+	MaxAge        string   `toml:"maxage"`
+}
+
+// CORS request and response headers
+const (
+	HEADER_ORIGIN            = "Origin"
+	HEADER_REQUEST_METHOD    = "Access-Control-Request-Method"
+	HEADER_REQUEST_HEADERS   = "Access-Control-Request-Headers"
+	HEADER_ALLOW_ORIGIN      = "Access-Control-Allow-Origin"
+	HEADER_ALLOW_METHODS     = "Access-Control-Allow-Methods"
+	HEADER_ALLOW_HEADERS     = "Access-Control-Allow-Headers"
+	HEADER_ALLOW_CREDENTIALS = "Access-Control-Allow-Credentials"
+	HEADER_EXPOSE_HEADERS    = "Access-Control-Expose-Headers"
+	HEADER_MAX_AGE           = "Access-Control-Max-Age"
+)
+
+// Any origin or header, and the leading label of wildcard subdomain origins
+const (
+	CORS_ANY      = "*"
+	CORS_WILDCARD = "*."
+)
+
+// Name of the routes answering OPTIONS requests
+const PREFLIGHT_ROUTE = "Preflight"
+
+// Request headers browsers may send by default: the credentials, request ID and tenant
+var defaultCORSHeaders = []string{"Content-Type", "Authorization", HEADER_API_KEY, HEADER_REQUEST_ID, HEADER_TENANT, HEADER_UPLOAD_ID}
+
+// Response headers scripts may read by default
+var defaultCORSExposeHeaders = []string{HEADER_REQUEST_ID, HEADER_UPLOAD_ID, HEADER_RATELIMIT_LIMIT,
+	HEADER_RATELIMIT_REMAINING, HEADER_RATELIMIT_RESET, HEADER_RETRY_AFTER}
+
+/******************************************************************************************
+ *
+ * CORS - the origins, methods and headers allowed, nil when CORS is disabled
+ *
+*******************************************************************************************/
+type CORS struct {
+	AnyOrigin     bool
+	Origins       map[string]bool
+	Wildcards     []originPattern
+	Methods       []string
+	Headers       map[string]bool
+	AnyHeader     bool
+	ExposeHeaders []string
+	Credentials   bool
+	MaxAge        time.Duration
+}
+
+// originPattern matches the subdomains of a host, e.g. https://*.example.com
+type originPattern struct {
+	prefix string
+	suffix string
+}
+
+var cors *CORS
+
+/******************************************************************************************
+ *
+ * Create the CORS policy from config. Origins are exact (https://admin.example.com),
+ * wildcard subdomains (https://*.example.com) or "*" for any origin without
+ * credentials.
+ *
+*******************************************************************************************/
+func NewCORS(options CORSOptions) (*CORS, error) {
+	if len(options.Origins) == 0 {
+		return nil, errors.New("cors: origins are required")
+	}
+	maxAge, err := parseDurationOption(options.MaxAge, 10*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("cors: invalid maxage: %v", err)
+	}
+
+	c := &CORS{
+		Origins:     make(map[string]bool),
+		Headers:     make(map[string]bool),
+		Credentials: options.Credentials,
+		MaxAge:      maxAge,
+	}
+	for _, origin := range options.Origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		scheme, host, ok := strings.Cut(origin, "://")
+		switch {
+		case origin == CORS_ANY:
+			if c.Credentials {
+				return nil, errors.New("cors: origin \"*\" cannot be used with credentials, list the origins")
+			}
+			c.AnyOrigin = true
+		case !ok || len(scheme) == 0 || len(host) == 0 || strings.Contains(host, "/"):
+			return nil, fmt.Errorf("cors: invalid origin %q, expected scheme://host", origin)
+		case strings.HasPrefix(host, CORS_WILDCARD):
+			suffix := strings.TrimPrefix(host, CORS_WILDCARD)
+			if len(suffix) == 0 || strings.Contains(suffix, CORS_ANY) {
+				return nil, fmt.Errorf("cors: invalid origin %q", origin)
+			}
+			c.Wildcards = append(c.Wildcards, originPattern{prefix: scheme + "://", suffix: "." + suffix})
+		case strings.Contains(host, CORS_ANY):
+			return nil, fmt.Errorf("cors: invalid origin %q, only a leading *. is allowed", origin)
+		default:
+			c.Origins[origin] = true
+		}
+	}
+
+	methods := options.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	for _, method := range methods {
+		c.Methods = append(c.Methods, strings.ToUpper(method))
+	}
+
+	headers := options.Headers
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	for _, header := range headers {
+		if header == CORS_ANY {
+			c.AnyHeader = true
+			continue
+		}
+		c.Headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	c.ExposeHeaders = options.ExposeHeaders
+	if c.ExposeHeaders == nil {
+		c.ExposeHeaders = defaultCORSExposeHeaders
+	}
+	return c, nil
+}
+
+/******************************************************************************************
+ *
+ * Whether scripts of an origin may call the service
+ *
+*******************************************************************************************/
+func (c *CORS) AllowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	if c.AnyOrigin || c.Origins[origin] {
+		return true
+	}
+	for _, pattern := range c.Wildcards {
+		if !strings.HasPrefix(origin, pattern.prefix) || !strings.HasSuffix(origin, pattern.suffix) {
+			continue
+		}
+		subdomain := origin[len(pattern.prefix) : len(origin)-len(pattern.suffix)]
+		if len(subdomain) > 0 && !strings.ContainsAny(subdomain, "/:@") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowMethod(method string) bool {
+	for _, allowed := range c.Methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders checks the comma separated headers of a preflight request
+func (c *CORS) allowHeaders(requested string) bool {
+	if c.AnyHeader {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		if header = strings.TrimSpace(header); len(header) > 0 && !c.Headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOriginHeaders sets the headers of a response to an allowed origin
+func (c *CORS) allowOriginHeaders(w http.ResponseWriter, origin string) {
+	if c.AnyOrigin && !c.Credentials {
+		w.Header().Set(HEADER_ALLOW_ORIGIN, CORS_ANY)
+	} else {
+		w.Header().Set(HEADER_ALLOW_ORIGIN, origin)
+	}
+	if c.Credentials {
+		w.Header().Set(HEADER_ALLOW_CREDENTIALS, "true")
+	}
+}
+
+/******************************************************************************************
+ *
+ * CORS Middleware - adds the CORS headers for allowed origins, so scripts of those
+ * origins can read responses and their preflight requests, answered by the OPTIONS
+ * route of the path, succeed. Requests of other origins are served without CORS
+ * headers, so browsers block them.
+ *
+*******************************************************************************************/
+func CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get(HEADER_ORIGIN)
+		if cors != nil && len(origin) > 0 {
+			w.Header().Add("Vary", HEADER_ORIGIN)
+			if cors.AllowOrigin(origin) {
+				cors.setHeaders(w, r, origin)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setHeaders of the response to an allowed origin, a preflight request gets them only
+// if the policy allows its method and headers
+func (c *CORS) setHeaders(w http.ResponseWriter, r *http.Request, origin string) {
+	method := r.Header.Get(HEADER_REQUEST_METHOD)
+	if r.Method != http.MethodOptions || len(method) == 0 {
+		c.allowOriginHeaders(w, origin)
+		if len(c.ExposeHeaders) > 0 {
+			w.Header().Set(HEADER_EXPOSE_HEADERS, strings.Join(c.ExposeHeaders, ", "))
+		}
+		return
+	}
+
+	w.Header().Add("Vary", HEADER_REQUEST_METHOD)
+	w.Header().Add("Vary", HEADER_REQUEST_HEADERS)
+	requested := r.Header.Get(HEADER_REQUEST_HEADERS)
+	if !c.allowMethod(method) || !c.allowHeaders(requested) {
+		return
+	}
+	c.allowOriginHeaders(w, origin)
+	w.Header().Set(HEADER_ALLOW_METHODS, strings.Join(c.Methods, ", "))
+	if len(requested) > 0 {
+		w.Header().Set(HEADER_ALLOW_HEADERS, requested)
+	}
+	w.Header().Set(HEADER_MAX_AGE, strconv.Itoa(int(c.MaxAge.Seconds())))
+}
+
+/******************************************************************************************
+ *
+ * Answer OPTIONS requests of a path with the methods it allows, the CORS Middleware
+ * adds the headers of preflight requests
+ *
+*******************************************************************************************/
+func optionsHandler(methods []string) http.HandlerFunc {
+	allow := strings.Join(append(append([]string{}, methods...), http.MethodOptions), ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
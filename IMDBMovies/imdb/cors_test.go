@@ -0,0 +1,165 @@
+/******************************************************************************
+ * \file        cors_test.go
+ *
+ * \brief       GO File that tests the CORS policy and preflight requests
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// withCORS enables a CORS policy for a test
+func withCORS(t *testing.T, options CORSOptions) {
+	policy, err := NewCORS(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cors = policy
+	t.Cleanup(func() { cors = nil })
+}
+
+func serveCORS(method string, path string, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if len(origin) > 0 {
+		req.Header.Set(HEADER_ORIGIN, origin)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+func TestNewCORS(t *testing.T) {
+	for _, options := range []CORSOptions{
+		{},
+		{Origins: []string{"*"}, Credentials: true},
+		{Origins: []string{"admin.example.com"}},
+		{Origins: []string{"https://admin.*.example.com"}},
+		{Origins: []string{"https://*."}},
+		{Origins: []string{"https://admin.example.com"}, MaxAge: "soon"},
+	} {
+		if _, err := NewCORS(options); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
+
+func TestCORSAllowOrigin(t *testing.T) {
+	policy, err := NewCORS(CORSOptions{Origins: []string{"https://admin.example.com/", "https://*.studio.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := map[string]bool{
+		"https://admin.example.com":         true,
+		"https://Admin.Example.com":         true,
+		"https://tools.studio.example":      true,
+		"https://eu.tools.studio.example":   true,
+		"http://admin.example.com":          false,
+		"https://example.com":               false,
+		"https://studio.example":            false,
+		"https://evilstudio.example":        false,
+		"https://tools.studio.example.evil": false,
+		"http://tools.studio.example":       false,
+		"https://a@b.studio.example":        false,
+		"null":                              false,
+	}
+	for origin, expected := range allowed {
+		if policy.AllowOrigin(origin) != expected {
+			t.Errorf("Expected origin %s allowed %v", origin, expected)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+	withCORS(t, CORSOptions{Origins: []string{"https://admin.example.com"}, Credentials: true, MaxAge: "1h"})
+
+	preflight := map[string]string{HEADER_REQUEST_METHOD: "POST", HEADER_REQUEST_HEADERS: "x-api-key, content-type"}
+	for _, path := range []string{"/imdb/uploadmovies", "/imdb/tenants/team-a/uploadmovies"} {
+		response := serveCORS("OPTIONS", path, "https://admin.example.com", preflight)
+		checkResponseCode(t, http.StatusNoContent, response.Code)
+		expected := map[string]string{
+			HEADER_ALLOW_ORIGIN:      "https://admin.example.com",
+			HEADER_ALLOW_CREDENTIALS: "true",
+			HEADER_ALLOW_METHODS:     "GET, POST, PUT, DELETE",
+			HEADER_ALLOW_HEADERS:     "x-api-key, content-type",
+			HEADER_MAX_AGE:           "3600",
+		}
+		for header, value := range expected {
+			if got := response.Header().Get(header); got != value {
+				t.Errorf("Expected %s %q for %s, got %q", header, value, path, got)
+			}
+		}
+	}
+
+	// no CORS headers for other origins and headers that are not allowed
+	response := serveCORS("OPTIONS", "/imdb/uploadmovies", "https://evil.example", preflight)
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "" || response.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("Expected no CORS headers for another origin, got %v", response.Header())
+	}
+	response = serveCORS("OPTIONS", "/imdb/movies", "https://admin.example.com",
+		map[string]string{HEADER_REQUEST_METHOD: "GET", HEADER_REQUEST_HEADERS: "X-Debug"})
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "" {
+		t.Errorf("Expected no CORS headers for a header that is not allowed, got %v", response.Header())
+	}
+	response = serveCORS("OPTIONS", "/imdb/movies", "https://admin.example.com", map[string]string{HEADER_REQUEST_METHOD: "PATCH"})
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "" {
+		t.Errorf("Expected no CORS headers for a method that is not allowed, got %v", response.Header())
+	}
+}
+
+func TestCORSResponses(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+	withCORS(t, CORSOptions{Origins: []string{"*"}})
+
+	response := serveCORS("GET", "/imdb/version", "https://anywhere.example", nil)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "*" || !strings.Contains(response.Header().Get(HEADER_EXPOSE_HEADERS), HEADER_REQUEST_ID) {
+		t.Errorf("Expected CORS headers, got %v", response.Header())
+	}
+
+	// scripts can read errors too
+	response = serveCORS("GET", "/imdb/uploads", "https://anywhere.example", nil)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "*" {
+		t.Errorf("Expected CORS headers on an error, got %v", response.Header())
+	}
+
+	// same origin requests have no Origin header
+	response = serveCORS("GET", "/imdb/version", "", nil)
+	if response.Header().Get(HEADER_ALLOW_ORIGIN) != "" {
+		t.Errorf("Expected no CORS headers without an origin")
+	}
+}
+
+func TestOptionsEveryRoute(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+	withCORS(t, CORSOptions{Origins: []string{"https://admin.example.com"}})
+
+	vars := regexp.MustCompile(`\{[a-z]+\}`)
+	for _, route := range Routes() {
+		path := vars.ReplaceAllString(route.Path, "x1")
+		response := serveCORS("OPTIONS", path, "https://admin.example.com", map[string]string{HEADER_REQUEST_METHOD: route.Method})
+		checkResponseCode(t, http.StatusNoContent, response.Code)
+		if !strings.Contains(response.Header().Get("Allow"), route.Method) {
+			t.Errorf("Expected %s in Allow of %s, got %q", route.Method, path, response.Header().Get("Allow"))
+		}
+		if response.Header().Get(HEADER_ALLOW_ORIGIN) == "" {
+			t.Errorf("Expected a preflight response for %s %s", route.Method, path)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/******************************************************************************
+ * \file        csvmap.go
+ *
+ * \brief       GO File that maps CSV columns to movie fields by header name
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVProfile struct for a named column mapping profile in config file
+type CSVProfile struct {
+	Delimiter string              `toml:"delimiter"`
+	Aliases   map[string][]string `toml:"aliases"`
+	Optional  []string            `toml:"optional"`
+}
+
+// Movie fields in the order expected by ValidateMovie
+var movieColumns = []string{
+	"rank", "title", "genre", "description", "director", "actors",
+	"year", "runtime_min", "rating", "votes", "revenue_mil", "metascore",
+}
+
+// Columns that must be present in the header. A profile may declare
+// runtime_min, rating and votes optional, they are stored as 0 when absent.
+var requiredColumns = []string{"rank", "title", "year", "runtime_min", "rating", "votes"}
+
+// Columns that can never be made optional
+var keyColumns = []string{"rank", "title", "year"}
+
+// Header names of the IMDB CSV export, always recognized
+var defaultAliases = map[string][]string{
+	"runtime_min": {"Runtime (Minutes)", "Runtime"},
+	"revenue_mil": {"Revenue (Millions)", "Revenue"},
+	"genre":       {"Genres"},
+}
+
+// Name of the built-in profile used when none is configured
+const DEFAULT_CSV_PROFILE = "default"
+
+// MissingColumnsError is returned when the header lacks required columns
+type MissingColumnsError struct {
+	Columns []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	return "Missing required columns: " + strings.Join(e.Columns, ", ")
+}
+
+// ColumnMap holds the position of each movie field in a CSV record, -1 if absent
+type ColumnMap struct {
+	index    map[string]int
+	optional map[string]bool
+}
+
+/******************************************************************************************
+ *
+ * Find a mapping profile by name, an empty name selects the configured default
+ *
+*******************************************************************************************/
+func LookupCSVProfile(name string) (*CSVProfile, bool) {
+	config := Config()
+	if len(name) == 0 {
+		name = config.CSV.Profile
+	}
+	if len(name) == 0 {
+		name = DEFAULT_CSV_PROFILE
+	}
+
+	if profile, ok := config.CSV.Profiles[name]; ok {
+		return &profile, true
+	}
+	if name == DEFAULT_CSV_PROFILE {
+		return &CSVProfile{Delimiter: ","}, true
+	}
+	return nil, false
+}
+
+/******************************************************************************************
+ *
+ * Validate a profile from config: the delimiter, the movie fields aliases are given for
+ * and the fields declared optional
+ *
+*******************************************************************************************/
+func (p *CSVProfile) Validate() error {
+	var errs []error
+	if _, err := ParseDelimiter(p.Delimiter); err != nil {
+		errs = append(errs, err)
+	}
+	for field := range p.Aliases {
+		if !isColumn(movieColumns, field) {
+			errs = append(errs, fmt.Errorf("aliases of unknown movie field %q, expected one of %s", field, strings.Join(movieColumns, ", ")))
+		}
+	}
+	for _, field := range p.Optional {
+		if !isColumn(requiredColumns, field) || isColumn(keyColumns, field) {
+			errs = append(errs, fmt.Errorf("field %q cannot be optional", field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/******************************************************************************************
+ *
+ * Parse a delimiter option, either the character itself or its name
+ *
+*******************************************************************************************/
+func ParseDelimiter(delim string) (rune, error) {
+	switch strings.ToLower(delim) {
+	case "", "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	case "tab", "\\t":
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(delim)
+	if size != len(delim) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q", delim)
+	}
+	return r, nil
+}
+
+/******************************************************************************************
+ *
+ * Normalize a header name for matching: case, surrounding spaces and BOM are ignored
+ *
+*******************************************************************************************/
+func normalizeColumn(name string) string {
+	name = strings.TrimPrefix(name, "\ufeff")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+/******************************************************************************************
+ *
+ * Build the column map from a header record using the profile aliases
+ *
+*******************************************************************************************/
+func NewColumnMap(header []string, profile *CSVProfile) (*ColumnMap, error) {
+	// every name a movie field is known by
+	names := make(map[string]string)
+	addAliases := func(aliases map[string][]string) {
+		for field, list := range aliases {
+			for _, alias := range list {
+				names[normalizeColumn(alias)] = field
+			}
+		}
+	}
+	for _, field := range movieColumns {
+		names[field] = field
+		names[strings.Replace(field, "_", " ", -1)] = field
+	}
+	addAliases(defaultAliases)
+	if profile != nil {
+		addAliases(profile.Aliases)
+	}
+
+	columns := &ColumnMap{index: make(map[string]int), optional: make(map[string]bool)}
+	for _, field := range movieColumns {
+		columns.index[field] = -1
+	}
+	for i, name := range header {
+		field, ok := names[normalizeColumn(name)]
+		// the first column wins if a field is mapped twice, extra columns are ignored
+		if ok && columns.index[field] < 0 {
+			columns.index[field] = i
+		}
+	}
+
+	if profile != nil {
+		for _, field := range profile.Optional {
+			if isColumn(requiredColumns, field) && !isColumn(keyColumns, field) {
+				columns.optional[field] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, field := range requiredColumns {
+		if columns.index[field] < 0 && !columns.optional[field] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingColumnsError{Columns: missing}
+	}
+	return columns, nil
+}
+
+/******************************************************************************************
+ *
+ * Arrange a CSV record in the fixed field order used by ValidateMovie
+ *
+*******************************************************************************************/
+func (c *ColumnMap) Canonical(line []string) []string {
+	record := make([]string, len(movieColumns))
+	for i, field := range movieColumns {
+		idx := c.index[field]
+		if idx >= 0 && idx < len(line) {
+			record[i] = line[idx]
+		} else if c.optional[field] {
+			// absent optional numeric columns are stored as 0
+			record[i] = "0"
+		}
+	}
+	return record
+}
+
+// isColumn returns true if field is in the list of columns
+func isColumn(columns []string, field string) bool {
+	for _, column := range columns {
+		if column == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+/******************************************************************************
+ * \file        csvmap_test.go
+ *
+ * \brief       GO File that has tests for header driven CSV column mapping
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+		"errors"
+		"strings"
+		"github.com/BurntSushi/toml"
+)
+
+/******************************************************************************************
+ *
+ * Test mapping of reordered columns with aliases and an extra column
+ *
+*******************************************************************************************/
+func TestColumnMapReordered(t *testing.T) {
+	header := []string{"\ufeffTitle", "Year", "Extra", "Rank", "Runtime (Minutes)", "Votes", "Rating", "Genre"}
+	columns, err := NewColumnMap(header, &CSVProfile{})
+	if err != nil {
+		t.Fatalf("TestColumnMapReordered Failed %v", err)
+	}
+
+	line := columns.Canonical([]string{"Split", "2016", "x", "3", "117", "157606", "7.3", "Horror,Thriller"})
+	movie, err := ValidateMovie(line)
+	if err != nil || movie.Rank != 3 || movie.Title != "Split" || movie.Year != 2016 ||
+		movie.RuntimeMin != 117 || movie.Votes != 157606 || movie.Genre[1] != "thriller" {
+		t.Errorf("TestColumnMapReordered Failed %+v", movie)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test profile aliases and optional columns
+ *
+*******************************************************************************************/
+func TestColumnMapProfile(t *testing.T) {
+	profile := &CSVProfile{
+		Aliases:  map[string][]string{"runtime_min": {"Length"}, "year": {"Released"}},
+		Optional: []string{"votes", "title"},
+	}
+	header := []string{"Rank", "Title", "Released", "Length", "Rating"}
+	columns, err := NewColumnMap(header, profile)
+	if err != nil {
+		t.Fatalf("TestColumnMapProfile Failed %v", err)
+	}
+
+	movie, err := ValidateMovie(columns.Canonical([]string{"1", "Sing", "2016", "108", "7.1"}))
+	if err != nil || movie.Year != 2016 || movie.RuntimeMin != 108 || movie.Votes != 0 {
+		t.Errorf("TestColumnMapProfile Failed %+v", movie)
+	}
+
+	// key columns can not be made optional
+	_, err = NewColumnMap([]string{"Rank", "Released", "Length", "Rating"}, profile)
+	var missingErr *MissingColumnsError
+	if !errors.As(err, &missingErr) || strings.Join(missingErr.Columns, ",") != "title" {
+		t.Errorf("TestColumnMapProfile Failed for key column")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a header with missing required columns fails fast
+ *
+*******************************************************************************************/
+func TestImportCSVMissingColumns(t *testing.T) {
+	body := "Rank;Title;Genre\n1;Split;Horror\n"
+	err := ImportCSV(strings.NewReader(body), &CSVProfile{Delimiter: "semicolon"}, new(UploadResults))
+
+	var missingErr *MissingColumnsError
+	if !errors.As(err, &missingErr) ||
+		missingErr.Error() != "Missing required columns: year, runtime_min, rating, votes" {
+		t.Errorf("TestImportCSVMissingColumns Failed %v", err)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test delimiter option parsing
+ *
+*******************************************************************************************/
+func TestParseDelimiter(t *testing.T) {
+	cases := map[string]rune{"": ',', ";": ';', "semicolon": ';', "tab": '\t', "\t": '\t', "|": '|'}
+	for delim, expected := range cases {
+		r, err := ParseDelimiter(delim)
+		if err != nil || r != expected {
+			t.Errorf("TestParseDelimiter Failed for %q", delim)
+		}
+	}
+	for _, delim := range []string{";;", "\"", "\n"} {
+		if _, err := ParseDelimiter(delim); err == nil {
+			t.Errorf("TestParseDelimiter Failed for %q", delim)
+		}
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test the mapping profiles in the shipped config file
+ *
+*******************************************************************************************/
+func TestConfigCSVProfiles(t *testing.T) {
+	var config TomlConfig
+	if _, err := toml.DecodeFile("data/config.toml", &config); err != nil {
+		t.Fatalf("TestConfigCSVProfiles Failed %v", err)
+	}
+
+	profile, ok := config.CSV.Profiles["tsv"]
+	if !ok || profile.Delimiter != "tab" || len(profile.Optional) != 1 {
+		t.Errorf("TestConfigCSVProfiles Failed for tsv")
+	}
+	if len(config.CSV.Profiles[config.CSV.Profile].Aliases["runtime_min"]) != 2 {
+		t.Errorf("TestConfigCSVProfiles Failed for default")
+	}
+}
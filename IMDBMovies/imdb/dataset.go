@@ -0,0 +1,311 @@
+/******************************************************************************
+ * \file        dataset.go
+ *
+ * \brief       GO File that imports the public IMDb TSV datasets
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IMDb dataset files, read as .tsv.gz or uncompressed .tsv
+const (
+	DATASET_TITLE_BASICS     = "title.basics"
+	DATASET_TITLE_RATINGS    = "title.ratings"
+	DATASET_TITLE_PRINCIPALS = "title.principals"
+	DATASET_NAME_BASICS      = "name.basics"
+)
+
+// Null value marker in the IMDb datasets
+const DATASET_NULL = "\\N"
+
+// DatasetOptions struct for dataset import settings in config file
+type DatasetOptions struct {
+	TitleTypes []string `toml:"titletypes"`
+	MaxActors  int      `toml:"maxactors"`
+}
+
+// ImportResults Struct for dataset import results
+type ImportResults struct {
+	RecordsRead    int `json:"RecordsRead"`
+	RecordsCreated int `json:"RecordsCreated"`
+	RecordsUpdated int `json:"RecordsUpdated"`
+	RecordsErrored int `json:"RecordsErrored"`
+}
+
+// principal credits of a title, names are resolved after name.basics is read
+type datasetCredits struct {
+	directors []string
+	actors    []datasetActor
+}
+
+type datasetActor struct {
+	ordering int
+	nconst   string
+}
+
+/******************************************************************************************
+ *
+ * Open a dataset file in dir, the gzip file is preferred over the plain TSV
+ *
+*******************************************************************************************/
+func openDatasetFile(dir string, name string) (io.ReadCloser, error) {
+	path := filepath.Join(dir, name+".tsv.gz")
+	f, err := os.Open(path)
+	if err == nil {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gz, f}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.Open(filepath.Join(dir, name+".tsv"))
+}
+
+/******************************************************************************************
+ *
+ * Read every row of a dataset file. The header row names the columns, each row is
+ * passed to fn as a map of column name to value.
+ *
+*******************************************************************************************/
+func readDataset(dir string, name string, fn func(row map[string]string)) error {
+	file, err := openDatasetFile(dir, name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// IMDb TSV files are not quoted, so they are split on tabs instead of using encoding/csv
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var header []string
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if header == nil {
+			header = fields
+			continue
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(fields) && fields[i] != DATASET_NULL {
+				row[column] = fields[i]
+			}
+		}
+		fn(row)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Load and join the IMDb dataset files in dir into Movie records
+ *
+*******************************************************************************************/
+func LoadIMDbDataset(dir string, options DatasetOptions) ([]*Movie, error) {
+	titleTypes := make(map[string]bool)
+	for _, titleType := range options.TitleTypes {
+		titleTypes[titleType] = true
+	}
+	if len(titleTypes) == 0 {
+		titleTypes["movie"] = true
+	}
+	maxActors := options.MaxActors
+	if maxActors <= 0 {
+		maxActors = 4
+	}
+
+	// title.basics decides which titles are imported
+	movies := make(map[string]*Movie)
+	err := readDataset(dir, DATASET_TITLE_BASICS, func(row map[string]string) {
+		if !titleTypes[row["titleType"]] || len(row["primaryTitle"]) == 0 {
+			return
+		}
+		year, err := strconv.Atoi(row["startYear"])
+		if err != nil {
+			return
+		}
+		movie := &Movie{IMDbID: row["tconst"], Title: row["primaryTitle"], Year: year}
+		movie.RuntimeMin, _ = strconv.Atoi(row["runtimeMinutes"])
+		if genres, ok := row["genres"]; ok {
+			movie.Genre = NormalizeGenres(strings.Split(genres, ","))
+		}
+		movies[movie.IMDbID] = movie
+	})
+	if err != nil {
+		return nil, err
+	}
+	ingestLog.WithFields(log.Fields{"Dataset Titles": len(movies)}).Info()
+
+	err = readDataset(dir, DATASET_TITLE_RATINGS, func(row map[string]string) {
+		movie, ok := movies[row["tconst"]]
+		if !ok {
+			return
+		}
+		movie.Rating, _ = strconv.ParseFloat(row["averageRating"], 64)
+		movie.Votes, _ = strconv.Atoi(row["numVotes"])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// principals of the selected titles are streamed, only the first billed actors are kept
+	credits := make(map[string]*datasetCredits)
+	err = readDataset(dir, DATASET_TITLE_PRINCIPALS, func(row map[string]string) {
+		tconst := row["tconst"]
+		if _, ok := movies[tconst]; !ok {
+			return
+		}
+		credit, ok := credits[tconst]
+		if !ok {
+			credit = new(datasetCredits)
+			credits[tconst] = credit
+		}
+		nconst := row["nconst"]
+		switch row["category"] {
+		case "director":
+			credit.directors = append(credit.directors, nconst)
+		case "actor", "actress":
+			ordering, _ := strconv.Atoi(row["ordering"])
+			credit.actors = append(credit.actors, datasetActor{ordering, nconst})
+			sort.SliceStable(credit.actors, func(i, j int) bool {
+				return credit.actors[i].ordering < credit.actors[j].ordering
+			})
+			if len(credit.actors) > maxActors {
+				credit.actors = credit.actors[:maxActors]
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// names are joined in a second pass so only the credited names are kept
+	names := make(map[string]string)
+	for _, credit := range credits {
+		for _, nconst := range credit.directors {
+			names[nconst] = ""
+		}
+		for _, actor := range credit.actors {
+			names[actor.nconst] = ""
+		}
+	}
+
+	err = readDataset(dir, DATASET_NAME_BASICS, func(row map[string]string) {
+		if _, ok := names[row["nconst"]]; ok {
+			names[row["nconst"]] = row["primaryName"]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Movie, 0, len(movies))
+	for tconst, movie := range movies {
+		if credit, ok := credits[tconst]; ok {
+			movie.Director = joinNames(credit.directors, names)
+
+			var actors []string
+			for _, actor := range credit.actors {
+				actors = append(actors, actor.nconst)
+			}
+			movie.Actors = joinNames(actors, names)
+		}
+		result = append(result, movie)
+	}
+
+	// stable order keeps repeated runs comparable in the logs
+	sort.Slice(result, func(i, j int) bool { return result[i].IMDbID < result[j].IMDbID })
+	return result, nil
+}
+
+// joinNames resolves name ids and joins them like the CSV Director/Actors columns
+func joinNames(nconsts []string, names map[string]string) string {
+	var list []string
+	for _, nconst := range nconsts {
+		if name := names[nconst]; len(name) > 0 {
+			list = append(list, name)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// sharedTitles are the title and year keys of more than one IMDb title, an uploaded
+// movie with such a title and year cannot be told apart and is not adopted
+func sharedTitles(movies []*Movie) map[string]bool {
+	counts := make(map[string]int)
+	for _, movie := range movies {
+		counts[titleYear(movie)]++
+	}
+	shared := make(map[string]bool)
+	for key, count := range counts {
+		if count > 1 {
+			shared[key] = true
+		}
+	}
+	return shared
+}
+
+func titleYear(movie *Movie) string {
+	return fmt.Sprintf("%s (%d)", movie.Title, movie.Year)
+}
+
+/******************************************************************************************
+ *
+ * Import the IMDb dataset files in dir into a catalog. Movies are matched by IMDb ID,
+ * or by title and year for a movie uploaded before when it is the only candidate, so
+ * the import can be run again to update them.
+ *
+*******************************************************************************************/
+func ImportIMDbDataset(catalog *MoviesDAO, dir string, options DatasetOptions) (*ImportResults, error) {
+	ingestLog.WithFields(log.Fields{"Importing IMDb dataset": dir, "Tenant": catalog.Tenant}).Info()
+
+	movies, err := LoadIMDbDataset(dir, options)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := sharedTitles(movies)
+	results := new(ImportResults)
+	for _, movie := range movies {
+		results.RecordsRead += 1
+		created, err := catalog.UpsertByIMDbID(*movie, !shared[titleYear(movie)])
+		if err != nil {
+			ingestLog.WithFields(log.Fields{"IMDb ID": movie.IMDbID, "Upsert Error": err}).Info()
+			results.RecordsErrored += 1
+		} else if created {
+			results.RecordsCreated += 1
+		} else {
+			results.RecordsUpdated += 1
+		}
+	}
+
+	ingestLog.WithFields(log.Fields{"RecordsRead": results.RecordsRead, "RecordsCreated": results.RecordsCreated,
+		"RecordsUpdated": results.RecordsUpdated, "RecordsErrored": results.RecordsErrored}).Info("IMDb dataset imported")
+	return results, nil
+}
@@ -0,0 +1,78 @@
+/******************************************************************************
+ * \file        dataset_test.go
+ *
+ * \brief       GO File that has tests for the IMDb dataset importer
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+)
+
+/******************************************************************************************
+ *
+ * Test joining the dataset files into Movie records
+ *
+*******************************************************************************************/
+func TestLoadIMDbDataset(t *testing.T) {
+	movies, err := LoadIMDbDataset("./test/imdb", DatasetOptions{MaxActors: 1})
+	if err != nil {
+		t.Fatalf("TestLoadIMDbDataset Failed %v", err)
+	}
+
+	// the series and the title without a year are not imported
+	if len(movies) != 3 {
+		t.Fatalf("TestLoadIMDbDataset Failed, got %d movies", len(movies))
+	}
+
+	// movies are ordered by IMDb ID
+	squad, guardians, split := movies[0], movies[1], movies[2]
+
+	if guardians.IMDbID != "tt2015381" || guardians.Title != "Guardians of the Galaxy" ||
+		guardians.Year != 2014 || guardians.RuntimeMin != 121 || guardians.Rating != 8.0 ||
+		guardians.Votes != 1200000 || guardians.Genre[2] != "comedy" ||
+		guardians.Director != "James Gunn" || guardians.Actors != "Chris Pratt" {
+		t.Errorf("TestLoadIMDbDataset Failed %+v", guardians)
+	}
+
+	// actors are ordered by billing, not by file order
+	if squad.Actors != "Margot Robbie" || squad.Director != "David Ayer" {
+		t.Errorf("TestLoadIMDbDataset Failed %+v", squad)
+	}
+
+	// missing runtime and rating stay empty
+	if split.RuntimeMin != 0 || split.Votes != 0 || split.Director != "" {
+		t.Errorf("TestLoadIMDbDataset Failed %+v", split)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a missing dataset file is reported
+ *
+*******************************************************************************************/
+func TestLoadIMDbDatasetMissing(t *testing.T) {
+	if _, err := LoadIMDbDataset("./test", DatasetOptions{}); err == nil {
+		t.Errorf("TestLoadIMDbDatasetMissing Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a title and year of several IMDb titles is not used to adopt a movie
+ *
+*******************************************************************************************/
+func TestSharedTitles(t *testing.T) {
+	movies := []*Movie{
+		{IMDbID: "tt0000001", Title: "Crash", Year: 2004},
+		{IMDbID: "tt0000002", Title: "Crash", Year: 2004},
+		{IMDbID: "tt0000003", Title: "Crash", Year: 1996},
+	}
+	shared := sharedTitles(movies)
+	if len(shared) != 1 || !shared[titleYear(movies[0])] || shared[titleYear(movies[2])] {
+		t.Errorf("TestSharedTitles Failed %v", shared)
+	}
+}
@@ -0,0 +1,548 @@
+/******************************************************************************
+ * \file        enrich.go
+ *
+ * \brief       GO File that fills missing movie metadata from external providers
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// EnrichmentOptions struct for enrichment settings in config file
+type EnrichmentOptions struct {
+	Enabled    bool              `toml:"enabled"`
+	Interval   string            `toml:"interval"`
+	BatchSize  int               `toml:"batchsize"`
+	RetryAfter string            `toml:"retryafter"`
+	Providers  []ProviderOptions `toml:"providers"`
+}
+
+// ProviderOptions struct for an HTTP metadata provider in config file
+type ProviderOptions struct {
+	Name          string  `toml:"name"`
+	BaseURL       string  `toml:"baseurl"`
+	APIKey        string  `toml:"apikey" secret:"true"`
+	RatePerSecond float64 `toml:"ratepersecond"`
+	CacheTTL      string  `toml:"cachettl"`
+	CacheSize     int     `toml:"cachesize"`
+	Timeout       string  `toml:"timeout"`
+}
+
+// Enrichment holds the values a provider found for a movie, nil if unknown
+type Enrichment struct {
+	RevenueMil *float64
+	Metascore  *int
+}
+
+// EnrichmentProvider looks up missing metadata for a movie, until ctx is done
+type EnrichmentProvider interface {
+	Name() string
+	Lookup(ctx context.Context, movie *Movie) (*Enrichment, error)
+}
+
+// errProviderNotFound is returned when the provider does not know the movie
+var errProviderNotFound = errors.New("movie not found by provider")
+
+// Movie fields filled by enrichment, as stored in the database
+const (
+	FIELD_REVENUE   = "revenuemil"
+	FIELD_METASCORE = "metascore"
+)
+
+// Default number of provider responses cached
+const DEFAULT_CACHE_SIZE = 10000
+
+/******************************************************************************************
+ *
+ * Response Cache - provider responses by lookup key, expired after a TTL. At most size
+ * responses are kept, the least recently used one is evicted first.
+ *
+*******************************************************************************************/
+type responseCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	// most recently used first
+	recent *list.List
+}
+
+type cacheEntry struct {
+	key     string
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	return &responseCache{ttl: ttl, size: size, entries: make(map[string]*list.Element), recent: list.New()}
+}
+
+func (c *responseCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.body, true
+}
+
+func (c *responseCache) Put(key string, body []byte) {
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.body, entry.expires = body, expires
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.recent.PushFront(&cacheEntry{key: key, body: body, expires: expires})
+	for c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+	}
+}
+
+func (c *responseCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.recent.Len()
+}
+
+func (c *responseCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+/******************************************************************************************
+ *
+ * HTTP Provider for an OMDb style JSON API
+ *
+*******************************************************************************************/
+type HTTPProvider struct {
+	name    string
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+	limiter *rate.Limiter
+	cache   *responseCache
+}
+
+// omdbResponse struct for the fields used from an OMDb style response
+type omdbResponse struct {
+	Response  string `json:"Response"`
+	Error     string `json:"Error"`
+	Metascore string `json:"Metascore"`
+	BoxOffice string `json:"BoxOffice"`
+}
+
+/******************************************************************************************
+ *
+ * Create an HTTP Provider from its config
+ *
+*******************************************************************************************/
+func NewHTTPProvider(options ProviderOptions) (*HTTPProvider, error) {
+	if _, err := url.ParseRequestURI(options.BaseURL); err != nil {
+		return nil, fmt.Errorf("provider %s: invalid baseurl: %v", options.Name, err)
+	}
+	timeout, err := parseDurationOption(options.Timeout, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: invalid timeout: %v", options.Name, err)
+	}
+	ttl, err := parseDurationOption(options.CacheTTL, 24*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: invalid cachettl: %v", options.Name, err)
+	}
+
+	cacheSize := options.CacheSize
+	if cacheSize == 0 {
+		cacheSize = DEFAULT_CACHE_SIZE
+	}
+
+	limit := rate.Inf
+	if options.RatePerSecond > 0 {
+		limit = rate.Limit(options.RatePerSecond)
+	}
+
+	name := options.Name
+	if len(name) == 0 {
+		name = "omdb"
+	}
+
+	return &HTTPProvider{
+		name:    name,
+		BaseURL: options.BaseURL,
+		APIKey:  options.APIKey,
+		Client:  &http.Client{Timeout: timeout},
+		limiter: rate.NewLimiter(limit, 1),
+		cache:   newResponseCache(ttl, cacheSize),
+	}, nil
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.name
+}
+
+/******************************************************************************************
+ *
+ * Lookup a movie by IMDb ID, or by title and year
+ *
+*******************************************************************************************/
+func (p *HTTPProvider) Lookup(ctx context.Context, movie *Movie) (*Enrichment, error) {
+	params := url.Values{}
+	if len(p.APIKey) > 0 {
+		params.Set("apikey", p.APIKey)
+	}
+	if len(movie.IMDbID) > 0 {
+		params.Set("i", movie.IMDbID)
+	} else {
+		params.Set("t", movie.Title)
+		params.Set("y", strconv.Itoa(movie.Year))
+	}
+	params.Set("type", "movie")
+
+	lookupURL := p.BaseURL + "?" + params.Encode()
+
+	body, ok := p.cache.Get(lookupURL)
+	if !ok {
+		var err error
+		body, err = p.fetch(ctx, lookupURL)
+		if err != nil {
+			return nil, err
+		}
+		// not found responses are cached too, they cost the same quota
+		p.cache.Put(lookupURL, body)
+	}
+
+	var response omdbResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("provider %s: %v", p.name, err)
+	}
+	if !strings.EqualFold(response.Response, "True") {
+		return nil, errProviderNotFound
+	}
+	return response.Enrichment(), nil
+}
+
+// fetch waits for the rate limiter and gets the provider response
+func (p *HTTPProvider) fetch(ctx context.Context, lookupURL string) ([]byte, error) {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", lookupURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("provider %s: unexpected status %d", p.name, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+/******************************************************************************************
+ *
+ * Convert an OMDb style response, "N/A" values are unknown
+ *
+*******************************************************************************************/
+func (o *omdbResponse) Enrichment() *Enrichment {
+	enrichment := new(Enrichment)
+
+	if metascore, err := strconv.Atoi(o.Metascore); err == nil && metascore > 0 {
+		enrichment.Metascore = &metascore
+	}
+
+	// box office is in dollars, e.g. "$333,176,600"
+	boxOffice := strings.NewReplacer("$", "", ",", "").Replace(o.BoxOffice)
+	if dollars, err := strconv.ParseFloat(boxOffice, 64); err == nil && dollars > 0 {
+		revenue := dollars / 1000000
+		enrichment.RevenueMil = &revenue
+	}
+	return enrichment
+}
+
+/******************************************************************************************
+ *
+ * Fill the missing fields of a movie from the providers in order. Returns the fields
+ * to set and the provider that supplied each of them.
+ *
+*******************************************************************************************/
+func EnrichMovie(ctx context.Context, movie *Movie, providers []EnrichmentProvider) (bson.M, map[string]string) {
+	fields := bson.M{}
+	sources := map[string]string{}
+
+	needRevenue := movie.RevenueMil == 0
+	needMetascore := movie.Metascore == 0
+
+	for _, provider := range providers {
+		if !needRevenue && !needMetascore {
+			break
+		}
+		enrichment, err := provider.Lookup(ctx, movie)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			if err != errProviderNotFound {
+				enrichmentLog.WithFields(log.Fields{"Provider": provider.Name(), "Title": movie.Title, "err": err}).Warning("Enrichment lookup failed")
+			}
+			continue
+		}
+
+		if needRevenue && enrichment.RevenueMil != nil {
+			fields[FIELD_REVENUE] = *enrichment.RevenueMil
+			sources[FIELD_REVENUE] = provider.Name()
+			needRevenue = false
+		}
+		if needMetascore && enrichment.Metascore != nil {
+			fields[FIELD_METASCORE] = *enrichment.Metascore
+			sources[FIELD_METASCORE] = provider.Name()
+			needMetascore = false
+		}
+	}
+	return fields, sources
+}
+
+/******************************************************************************************
+ *
+ * Enricher - background pass filling missing fields of stored movies
+ *
+*******************************************************************************************/
+type Enricher struct {
+	Providers  []EnrichmentProvider
+	Interval   time.Duration
+	BatchSize  int
+	RetryAfter time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	// lookups of the running batch, cancelled when the enricher stops
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+/******************************************************************************************
+ *
+ * Create the Enricher from config
+ *
+*******************************************************************************************/
+func NewEnricher(options EnrichmentOptions) (*Enricher, error) {
+	interval, err := parseDurationOption(options.Interval, time.Hour)
+	if err == nil && interval <= 0 {
+		err = errNotPositive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("enrichment: invalid interval: %v", err)
+	}
+	retryAfter, err := parseDurationOption(options.RetryAfter, 7*24*time.Hour)
+	if err == nil && retryAfter < 0 {
+		err = errNegative
+	}
+	if err != nil {
+		return nil, fmt.Errorf("enrichment: invalid retryafter: %v", err)
+	}
+
+	enricher := &Enricher{
+		Interval:   interval,
+		BatchSize:  options.BatchSize,
+		RetryAfter: retryAfter,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	enricher.ctx, enricher.cancel = context.WithCancel(context.Background())
+	if enricher.BatchSize <= 0 {
+		enricher.BatchSize = 100
+	}
+	for _, providerOptions := range options.Providers {
+		provider, err := NewHTTPProvider(providerOptions)
+		if err != nil {
+			return nil, err
+		}
+		enricher.Providers = append(enricher.Providers, provider)
+	}
+	return enricher, nil
+}
+
+/******************************************************************************************
+ *
+ * Enrich one batch of movies of every tenant, returns the largest number of movies
+ * looked up in a tenant
+ *
+*******************************************************************************************/
+func (e *Enricher) RunOnce() (int, error) {
+	if !database.Connected() {
+		return 0, errDatabaseDown
+	}
+	catalogs, err := dao.AllTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	most := 0
+	for _, catalog := range catalogs {
+		if e.ctx.Err() != nil {
+			break
+		}
+		processed, err := e.enrichBatch(catalog)
+		if err != nil {
+			enrichmentLog.WithFields(log.Fields{"Tenant": catalog.Tenant, "Enrichment Error": err}).Warning()
+			continue
+		}
+		if processed > most {
+			most = processed
+		}
+	}
+	return most, nil
+}
+
+// enrichBatch enriches one batch of movies of a tenant
+func (e *Enricher) enrichBatch(catalog *MoviesDAO) (int, error) {
+	ctx, span := tracer.Start(e.ctx, "EnrichBatch",
+		trace.WithAttributes(attribute.String("imdb.tenant", catalog.Tenant)))
+	defer span.End()
+	catalog = catalog.WithContext(ctx)
+
+	movies, err := catalog.FindUnenriched(e.BatchSize, time.Now().Add(-e.RetryAfter))
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for i := range movies {
+		fields, sources := EnrichMovie(ctx, &movies[i], e.Providers)
+		// an interrupted lookup is not recorded, the movie is looked up on the next run
+		if ctx.Err() != nil {
+			break
+		}
+		// the attempt is recorded even without results so the movie is retried later
+		if err := catalog.UpdateEnrichment(movies[i].ID, fields, sources); err != nil {
+			enrichmentLog.WithFields(log.Fields{"Title": movies[i].Title, "Update Error": err}).Warning()
+			continue
+		}
+		if len(fields) > 0 {
+			updated += 1
+		}
+	}
+
+	enrichmentLog.WithFields(log.Fields{"Tenant": catalog.Tenant, "Movies Looked Up": len(movies), "Movies Enriched": updated}).Info()
+	span.SetAttributes(attribute.Int("imdb.enrichment.looked_up", len(movies)), attribute.Int("imdb.enrichment.enriched", updated))
+	return len(movies), nil
+}
+
+/******************************************************************************************
+ *
+ * Run enrichment passes until Stop is called
+ *
+*******************************************************************************************/
+func (e *Enricher) Run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		// a full batch means more movies are waiting, continue without delay
+		for {
+			processed, err := e.RunOnce()
+			if err != nil {
+				enrichmentLog.WithFields(log.Fields{"Enrichment Error": err}).Warning()
+				break
+			}
+			if processed < e.BatchSize || e.stopped() {
+				break
+			}
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Enricher) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+/******************************************************************************************
+ *
+ * Stop the background pass, the lookups of the current batch are cancelled
+ *
+*******************************************************************************************/
+func (e *Enricher) Stop() {
+	close(e.stop)
+	e.cancel()
+	<-e.done
+}
+
+/******************************************************************************************
+ *
+ * Stop the background pass, the lookups of the current batch are cancelled and the
+ * batch is waited for until the context is done
+ *
+*******************************************************************************************/
+func (e *Enricher) Drain(ctx context.Context) error {
+	close(e.stop)
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("enrichment: batch running: %v", ctx.Err())
+	}
+}
+
+// Errors of durations from config out of range
+var (
+	errNotPositive = errors.New("must be greater than zero")
+	errNegative    = errors.New("must not be negative")
+)
+
+// parseDurationOption parses a duration from config, empty selects the default
+func parseDurationOption(value string, def time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,215 @@
+/******************************************************************************
+ * \file        enrich_test.go
+ *
+ * \brief       GO File that has tests for metadata enrichment providers
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"context"
+		"testing"
+		"net/http"
+		"net/http/httptest"
+		"sync/atomic"
+		"time"
+)
+
+/******************************************************************************************
+ *
+ * Start a stub OMDb style server, returns the server and its request counter
+ *
+*******************************************************************************************/
+func StubProviderServer() (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+		switch {
+		case q.Get("i") == "tt2015381" || (q.Get("t") == "Guardians of the Galaxy" && q.Get("y") == "2014"):
+			w.Write([]byte(`{"Response":"True","Metascore":"76","BoxOffice":"$333,176,600"}`))
+		case q.Get("t") == "Split":
+			w.Write([]byte(`{"Response":"True","Metascore":"N/A","BoxOffice":"N/A"}`))
+		default:
+			w.Write([]byte(`{"Response":"False","Error":"Movie not found!"}`))
+		}
+	}))
+	return server, &requests
+}
+
+/******************************************************************************************
+ *
+ * Test HTTP provider lookup and response caching
+ *
+*******************************************************************************************/
+func TestHTTPProviderLookup(t *testing.T) {
+	server, requests := StubProviderServer()
+	defer server.Close()
+
+	provider, err := NewHTTPProvider(ProviderOptions{Name: "stub", BaseURL: server.URL + "/", CacheTTL: "1h"})
+	if err != nil {
+		t.Fatalf("TestHTTPProviderLookup Failed %v", err)
+	}
+
+	movie := &Movie{Title: "Guardians of the Galaxy", Year: 2014}
+	for i := 0; i < 2; i++ {
+		enrichment, err := provider.Lookup(context.Background(), movie)
+		if err != nil || *enrichment.Metascore != 76 || *enrichment.RevenueMil != 333.1766 {
+			t.Errorf("TestHTTPProviderLookup Failed %v", err)
+		}
+	}
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("TestHTTPProviderLookup Failed, response was not cached")
+	}
+
+	// N/A values are unknown
+	enrichment, err := provider.Lookup(context.Background(), &Movie{Title: "Split", Year: 2016})
+	if err != nil || enrichment.Metascore != nil || enrichment.RevenueMil != nil {
+		t.Errorf("TestHTTPProviderLookup Failed for N/A values")
+	}
+
+	if _, err := provider.Lookup(context.Background(), &Movie{Title: "Unknown", Year: 2016}); err != errProviderNotFound {
+		t.Errorf("TestHTTPProviderLookup Failed for unknown movie")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that provider requests are rate limited
+ *
+*******************************************************************************************/
+func TestHTTPProviderRateLimit(t *testing.T) {
+	server, _ := StubProviderServer()
+	defer server.Close()
+
+	provider, _ := NewHTTPProvider(ProviderOptions{BaseURL: server.URL + "/", RatePerSecond: 20})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		provider.Lookup(context.Background(), &Movie{Title: "Unknown", Year: 2000 + i})
+	}
+	// the first request is immediate, the next two wait 50ms each
+	if time.Since(start) < 90*time.Millisecond {
+		t.Errorf("TestHTTPProviderRateLimit Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that a stopped enricher does not wait for the rate limiter
+ *
+*******************************************************************************************/
+func TestHTTPProviderCancelled(t *testing.T) {
+	server, requests := StubProviderServer()
+	defer server.Close()
+
+	provider, _ := NewHTTPProvider(ProviderOptions{BaseURL: server.URL + "/", RatePerSecond: 0.01})
+	provider.Lookup(context.Background(), &Movie{Title: "Unknown", Year: 2000})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := provider.Lookup(ctx, &Movie{Title: "Unknown", Year: 2001}); err == nil {
+		t.Errorf("TestHTTPProviderCancelled Failed, expected the lookup to be cancelled")
+	}
+	if time.Since(start) > time.Second || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("TestHTTPProviderCancelled Failed, lookup waited %v", time.Since(start))
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that the response cache keeps the most recently used responses
+ *
+*******************************************************************************************/
+func TestResponseCacheSize(t *testing.T) {
+	cache := newResponseCache(time.Hour, 2)
+	cache.Put("a", []byte("a"))
+	cache.Put("b", []byte("b"))
+	cache.Get("a")
+	cache.Put("c", []byte("c"))
+
+	if _, ok := cache.Get("b"); ok || cache.Len() != 2 {
+		t.Errorf("TestResponseCacheSize Failed, least recently used response was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if body, ok := cache.Get(key); !ok || string(body) != key {
+			t.Errorf("TestResponseCacheSize Failed, %s was evicted", key)
+		}
+	}
+
+	// expired responses are dropped on lookup
+	expired := newResponseCache(time.Nanosecond, 2)
+	expired.Put("a", []byte("a"))
+	time.Sleep(time.Millisecond)
+	if _, ok := expired.Get("a"); ok || expired.Len() != 0 {
+		t.Errorf("TestResponseCacheSize Failed, expired response was kept")
+	}
+}
+
+// staticProvider returns the same enrichment for every movie
+type staticProvider struct {
+	name       string
+	enrichment *Enrichment
+}
+
+func (s *staticProvider) Name() string { return s.name }
+
+func (s *staticProvider) Lookup(ctx context.Context, movie *Movie) (*Enrichment, error) {
+	if s.enrichment == nil {
+		return nil, errProviderNotFound
+	}
+	return s.enrichment, nil
+}
+
+/******************************************************************************************
+ *
+ * Test that missing fields are filled in provider order with their source recorded
+ *
+*******************************************************************************************/
+func TestEnrichMovie(t *testing.T) {
+	metascore := 62
+	revenue := 103.14
+	providers := []EnrichmentProvider{
+		&staticProvider{name: "none"},
+		&staticProvider{name: "first", enrichment: &Enrichment{Metascore: &metascore}},
+		&staticProvider{name: "second", enrichment: &Enrichment{Metascore: new(int), RevenueMil: &revenue}},
+	}
+
+	fields, sources := EnrichMovie(context.Background(), &Movie{Title: "Sing", Year: 2016}, providers)
+	if fields[FIELD_METASCORE] != 62 || fields[FIELD_REVENUE] != 103.14 ||
+		sources[FIELD_METASCORE] != "first" || sources[FIELD_REVENUE] != "second" {
+		t.Errorf("TestEnrichMovie Failed %v %v", fields, sources)
+	}
+
+	// stored values are never replaced
+	fields, _ = EnrichMovie(context.Background(), &Movie{Title: "Sing", RevenueMil: 270.32, Metascore: 59}, providers)
+	if len(fields) != 0 {
+		t.Errorf("TestEnrichMovie Failed for complete movie")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that an interval the ticker cannot run with is rejected
+ *
+*******************************************************************************************/
+func TestNewEnricherInterval(t *testing.T) {
+	for _, options := range []EnrichmentOptions{
+		{Interval: "0s"},
+		{Interval: "-1m"},
+		{RetryAfter: "-1h"},
+	} {
+		if _, err := NewEnricher(options); err == nil {
+			t.Errorf("TestNewEnricherInterval Failed for %+v", options)
+		}
+	}
+
+	enricher, err := NewEnricher(EnrichmentOptions{Interval: "30m"})
+	if err != nil || enricher.Interval != 30*time.Minute {
+		t.Errorf("TestNewEnricherInterval Failed %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+/******************************************************************************
+ * \file        health.go
+ *
+ * \brief       GO File that keeps the database connected and reports health
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MonitorOptions struct for database reconnection settings in config file
+type MonitorOptions struct {
+	PingInterval string `toml:"pinginterval"`
+	PingTimeout  string `toml:"pingtimeout"`
+	Backoff      string `toml:"backoff"`
+	MaxBackoff   string `toml:"maxbackoff"`
+}
+
+// Health check states
+const (
+	CHECK_OK   = "ok"
+	CHECK_FAIL = "fail"
+)
+
+var errDatabaseDown = errors.New("database is not connected")
+
+// Set once the config file is loaded
+var configLoaded bool
+
+// Process start for the uptime
+var started = time.Now()
+
+/******************************************************************************************
+ *
+ * Database Monitor - connects to the database in the background, retrying with
+ * exponential backoff, and pings it to notice when it drops
+ *
+*******************************************************************************************/
+type DatabaseMonitor struct {
+	DAO        *MoviesDAO
+	Interval   time.Duration
+	Timeout    time.Duration
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	mu        sync.RWMutex
+	connected bool
+	err       error
+	failures  int
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Database Monitor, nil when the database was connected before serving
+var database *DatabaseMonitor
+
+/******************************************************************************************
+ *
+ * Create the Database Monitor from config
+ *
+*******************************************************************************************/
+func NewDatabaseMonitor(dao *MoviesDAO, options MonitorOptions) (*DatabaseMonitor, error) {
+	m := &DatabaseMonitor{DAO: dao, err: errDatabaseDown, stop: make(chan struct{}), done: make(chan struct{})}
+	durations := []struct {
+		name   string
+		value  string
+		def    time.Duration
+		target *time.Duration
+	}{
+		{"pinginterval", options.PingInterval, 10 * time.Second, &m.Interval},
+		{"pingtimeout", options.PingTimeout, 2 * time.Second, &m.Timeout},
+		{"backoff", options.Backoff, time.Second, &m.Backoff},
+		{"maxbackoff", options.MaxBackoff, 30 * time.Second, &m.MaxBackoff},
+	}
+	for _, d := range durations {
+		value, err := parseDurationOption(d.value, d.def)
+		if err != nil {
+			return nil, fmt.Errorf("database: invalid %s: %v", d.name, err)
+		}
+		*d.target = value
+	}
+	return m, nil
+}
+
+/******************************************************************************************
+ *
+ * Connected is true while the last connect or ping succeeded
+ *
+*******************************************************************************************/
+func (m *DatabaseMonitor) Connected() bool {
+	if m == nil {
+		return db() != nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.connected
+}
+
+// Err of the last connect or ping, nil while connected
+func (m *DatabaseMonitor) Err() error {
+	if m == nil {
+		if db() == nil {
+			return errDatabaseDown
+		}
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+/******************************************************************************************
+ *
+ * Keep the database connected until Drain is called
+ *
+*******************************************************************************************/
+func (m *DatabaseMonitor) Run() {
+	defer close(m.done)
+	for {
+		wait := m.check()
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// check connects or pings once and returns the time until the next check
+func (m *DatabaseMonitor) check() time.Duration {
+	var err error
+	if current := db(); current == nil {
+		err = m.DAO.Connect()
+	} else {
+		err = ping(m.Timeout)
+		if err != nil {
+			// drop the broken sockets so the next operations reconnect
+			current.Session.Refresh()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		if m.connected || m.failures == 0 {
+			databaseLog.WithFields(log.Fields{"Database Error": err}).Error("Database is not reachable")
+		}
+		m.connected, m.err = false, err
+		m.failures += 1
+		return m.backoff()
+	}
+	if !m.connected {
+		databaseLog.WithFields(log.Fields{"Database": m.DAO.Database}).Info("Database is connected")
+	}
+	m.connected, m.err, m.failures = true, nil, 0
+	return m.Interval
+}
+
+// backoff doubles with each failure up to the maximum
+func (m *DatabaseMonitor) backoff() time.Duration {
+	wait := m.Backoff
+	for i := 1; i < m.failures && wait < m.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > m.MaxBackoff {
+		wait = m.MaxBackoff
+	}
+	return wait
+}
+
+/******************************************************************************************
+ *
+ * Stop the monitor, waiting for a connect attempt until the context is done
+ *
+*******************************************************************************************/
+func (m *DatabaseMonitor) Drain(ctx context.Context) error {
+	close(m.stop)
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("database: connecting: %v", ctx.Err())
+	}
+}
+
+// ping the database on a fresh socket within the timeout
+func ping(timeout time.Duration) error {
+	current := db()
+	if current == nil {
+		return errDatabaseDown
+	}
+	session := current.Session.Copy()
+	defer session.Close()
+	session.SetSyncTimeout(timeout)
+	session.SetSocketTimeout(timeout)
+	return session.Ping()
+}
+
+/******************************************************************************************
+ *
+ * Database Middleware - endpoints other than the public ones need the database and
+ * are answered with 503 while the monitor sees it disconnected
+ *
+*******************************************************************************************/
+func DatabaseMiddleware(public map[string]bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if database != nil && !public[RouteName(r)] && !database.Connected() {
+				w.Header().Set(HEADER_RETRY_AFTER, "5")
+				respondWithErrorCode(w, ERR_SERVICE_UNAVAILABLE)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HealthCheck struct for the result of a readiness check
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+}
+
+// Health struct for the health endpoint responses
+type Health struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+/******************************************************************************************
+ *
+ * Liveness - the process is up and serving requests
+ *
+*******************************************************************************************/
+func GetHealth(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, Health{Status: CHECK_OK, Uptime: time.Since(started).Round(time.Second).String()})
+}
+
+/******************************************************************************************
+ *
+ * Readiness - the database answers a ping, the movie indexes exist and the config
+ * file was loaded. Responds with 503 if a check fails.
+ *
+*******************************************************************************************/
+func GetReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]HealthCheck{
+		"database": checkDatabase(),
+		"indexes":  checkIndexes(),
+		"config":   checkConfig(),
+	}
+
+	health := Health{Status: CHECK_OK, Uptime: time.Since(started).Round(time.Second).String(), Checks: checks}
+	code := http.StatusOK
+	for name, check := range checks {
+		if check.Status != CHECK_OK {
+			health.Status = CHECK_FAIL
+			code = http.StatusServiceUnavailable
+			log.WithFields(log.Fields{"Check": name, "Error": check.Error}).Debug("Not ready")
+		}
+	}
+	respondWithJSON(w, code, health)
+}
+
+func checkResult(err error, start time.Time) HealthCheck {
+	if err != nil {
+		return HealthCheck{Status: CHECK_FAIL, Error: err.Error()}
+	}
+	return HealthCheck{Status: CHECK_OK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+}
+
+func checkDatabase() HealthCheck {
+	if !database.Connected() {
+		return checkResult(database.Err(), time.Now())
+	}
+	timeout := 2 * time.Second
+	if database != nil {
+		timeout = database.Timeout
+	}
+	start := time.Now()
+	return checkResult(ping(timeout), start)
+}
+
+func checkIndexes() HealthCheck {
+	if !database.Connected() {
+		return checkResult(errDatabaseDown, time.Now())
+	}
+	start := time.Now()
+	catalogs, err := dao.AllTenants()
+	if err != nil {
+		return checkResult(err, start)
+	}
+	for _, catalog := range catalogs {
+		if err := catalog.checkMovieIndexes(); err != nil {
+			return checkResult(err, start)
+		}
+	}
+	return checkResult(nil, start)
+}
+
+// checkMovieIndexes reports a missing unique index of the movies of a tenant
+func (m *MoviesDAO) checkMovieIndexes() error {
+	indexes, err := m.movies().Indexes()
+	if err != nil {
+		return fmt.Errorf("indexes of %s: %v", m.Collection(), err)
+	}
+	unique := make(map[string]bool)
+	for _, index := range indexes {
+		unique[strings.Join(index.Key, ",")] = index.Unique
+	}
+	if !unique["title,year"] {
+		return fmt.Errorf("unique index on title and year of %s is missing", m.Collection())
+	}
+	if !unique["imdb_id"] {
+		return fmt.Errorf("unique index on imdb_id of %s is missing", m.Collection())
+	}
+	return nil
+}
+
+func checkConfig() HealthCheck {
+	if !configLoaded {
+		return checkResult(errors.New("config file is not loaded"), time.Now())
+	}
+	return checkResult(nil, time.Now())
+}
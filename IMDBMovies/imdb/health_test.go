@@ -0,0 +1,85 @@
+/******************************************************************************
+ * \file        health_test.go
+ *
+ * \brief       GO File that tests the health endpoints and database backoff
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func withDisconnectedDatabase(t *testing.T) {
+	monitor, err := NewDatabaseMonitor(&MoviesDAO{}, MonitorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database = monitor
+	t.Cleanup(func() { database = nil })
+}
+
+func TestGetHealth(t *testing.T) {
+	withDisconnectedDatabase(t)
+	response := serveWithKey("GET", "/imdb/healthz", "")
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var health Health
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil || health.Status != CHECK_OK {
+		t.Errorf("Expected the process to be alive, got %s", response.Body.String())
+	}
+}
+
+func TestGetReadyWithoutDatabase(t *testing.T) {
+	withDisconnectedDatabase(t)
+	configLoaded = true
+	defer func() { configLoaded = false }()
+
+	response := serveWithKey("GET", "/imdb/readyz", "")
+	checkResponseCode(t, http.StatusServiceUnavailable, response.Code)
+
+	var health Health
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != CHECK_FAIL || health.Checks["database"].Status != CHECK_FAIL ||
+		health.Checks["indexes"].Status != CHECK_FAIL || health.Checks["config"].Status != CHECK_OK {
+		t.Errorf("Expected the database checks to fail, got %+v", health)
+	}
+	if health.Checks["database"].Error != errDatabaseDown.Error() {
+		t.Errorf("Expected the database error, got %q", health.Checks["database"].Error)
+	}
+}
+
+func TestDatabaseMiddleware(t *testing.T) {
+	withDisconnectedDatabase(t)
+
+	response := serveWithKey("GET", "/imdb/movies", "")
+	checkResponseCode(t, http.StatusServiceUnavailable, response.Code)
+	if response.Header().Get(HEADER_RETRY_AFTER) == "" {
+		t.Errorf("Expected a Retry-After header")
+	}
+	// public endpoints do not need the database
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/version", "").Code)
+}
+
+func TestDatabaseMonitorBackoff(t *testing.T) {
+	monitor, _ := NewDatabaseMonitor(&MoviesDAO{}, MonitorOptions{Backoff: "1s", MaxBackoff: "5s"})
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, wait := range expected {
+		monitor.failures = i + 1
+		if backoff := monitor.backoff(); backoff != wait {
+			t.Errorf("Failure %d: expected backoff %v, got %v", i+1, wait, backoff)
+		}
+	}
+
+	if _, err := NewDatabaseMonitor(&MoviesDAO{}, MonitorOptions{PingInterval: "often"}); err == nil {
+		t.Errorf("Expected an error for an invalid interval")
+	}
+}
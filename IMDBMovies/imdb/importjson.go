@@ -0,0 +1,209 @@
+/******************************************************************************
+ * \file        importjson.go
+ *
+ * \brief       GO File that handles JSON and NDJSON movie uploads
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MovieJSON Struct for a Movie Record in a JSON upload. Pointers are used for
+// the fields that are required in the CSV upload so a missing value can be told
+// apart from zero.
+type MovieJSON struct {
+	Rank        *int     `json:"rank"`
+	Title       string   `json:"title"`
+	Genre       []string `json:"genre"`
+	Description string   `json:"description"`
+	Director    string   `json:"director"`
+	Actors      string   `json:"actors"`
+	Year        *int     `json:"year"`
+	RuntimeMin  *int     `json:"runtime_min"`
+	Rating      *float64 `json:"rating"`
+	Votes       *int     `json:"votes"`
+	RevenueMil  *float64 `json:"revenue_mil"`
+	Metascore   *int     `json:"metascore"`
+}
+
+/******************************************************************************************
+ *
+ * Import movie records from a JSON array, counts are added to results
+ *
+*******************************************************************************************/
+func ImportJSON(body io.Reader, results *UploadResults) error {
+	dec := json.NewDecoder(body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return uploadReadError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errInvalidFormat
+	}
+
+	for dec.More() {
+		var record MovieJSON
+		err := dec.Decode(&record)
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			// the decoder has consumed the record, count it and move on
+			results.Log().WithFields(log.Fields{"Movie Record Validation Failed": err}).Info()
+			StoreMovie(nil, err, results)
+			continue
+		} else if err != nil {
+			// a broken array cannot be resynchronized, the records stored so far
+			// are reported with the error
+			results.Log().WithFields(log.Fields{"Invalid File Content. Error": err}).Info()
+			return uploadReadError(err)
+		}
+
+		importMovieJSON(&record, results)
+	}
+
+	// a truncated array ends without its closing bracket
+	tok, err = dec.Token()
+	if err != nil {
+		results.Log().WithFields(log.Fields{"Invalid File Content. Error": err}).Info()
+		return uploadReadError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != ']' {
+		return errInvalidFormat
+	}
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Import movie records from newline delimited JSON, counts are added to results
+ *
+*******************************************************************************************/
+func ImportNDJSON(body io.Reader, results *UploadResults) error {
+	reader := bufio.NewReader(body)
+
+	first := true
+	i := 0
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		i += 1
+		if readErr != nil && readErr != io.EOF {
+			return uploadReadError(readErr)
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var record MovieJSON
+			err := json.Unmarshal(line, &record)
+
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				results.Log().WithFields(log.Fields{"Line Number": i, "Movie Record Validation Failed": err}).Info()
+				StoreMovie(nil, err, results)
+			} else if err != nil {
+				results.Log().WithFields(log.Fields{"Line Number": i, "Invalid File Content in line. Error": err}).Info()
+				// if we encounter this error in the first line - consider it as invalid file
+				if first {
+					return errInvalidFormat
+				}
+			} else {
+				importMovieJSON(&record, results)
+			}
+			first = false
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Apply the CSV upload rules to a JSON record and store it
+ *
+*******************************************************************************************/
+func importMovieJSON(record *MovieJSON, results *UploadResults) {
+	// ignore record if there is no rank
+	if record.Rank == nil {
+		return
+	}
+
+	// if title and/or year are missing - skip the record
+	if len(record.Title) == 0 || record.Year == nil {
+		results.Log().WithFields(log.Fields{"Title and/or year are missing": record.Title}).Info()
+		return
+	}
+
+	movie, err := ValidateMovieJSON(record)
+	if err != nil {
+		results.Log().WithFields(log.Fields{"Movie Record Validation Failed": record.Title, "err": err}).Info()
+	}
+	StoreMovie(movie, err, results)
+}
+
+/******************************************************************************************
+ *
+ * Validate Movie Record in JSON
+ *
+*******************************************************************************************/
+func ValidateMovieJSON(record *MovieJSON) (*Movie, error) {
+	var movie = new(Movie)
+
+	if record.RuntimeMin == nil {
+		return movie, errors.New("runtime_min is missing")
+	}
+	if record.Rating == nil {
+		return movie, errors.New("rating is missing")
+	}
+	if record.Votes == nil {
+		return movie, errors.New("votes is missing")
+	}
+
+	// revenue and metascore could be empty
+	if record.RevenueMil != nil {
+		movie.RevenueMil = *record.RevenueMil
+	}
+	if record.Metascore != nil {
+		movie.Metascore = *record.Metascore
+	}
+
+	movie.Rank = *record.Rank
+	movie.Title = record.Title
+	movie.Genre = NormalizeGenres(record.Genre)
+	movie.Description = record.Description
+	movie.Director = record.Director
+	movie.Actors = record.Actors
+	movie.Year = *record.Year
+	movie.RuntimeMin = *record.RuntimeMin
+	movie.Rating = *record.Rating
+	movie.Votes = *record.Votes
+
+	return movie, nil
+}
+
+/******************************************************************************************
+ *
+ * Map an error reading the upload body, size limit errors are passed through
+ *
+*******************************************************************************************/
+func uploadReadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errTooLarge) || errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return errInvalidFormat
+}
@@ -0,0 +1,118 @@
+/******************************************************************************
+ * \file        importjson_test.go
+ *
+ * \brief       GO File that has tests for JSON and NDJSON uploads
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+		"strings"
+)
+
+/******************************************************************************************
+ *
+ * Test that a body which is not a JSON array is an invalid file
+ *
+*******************************************************************************************/
+func TestImportJSONFormat(t *testing.T) {
+	for _, body := range []string{"", "{\"rank\":1}", "Rank,Title", "[{\"rank\":"} {
+		err := ImportJSON(strings.NewReader(body), new(UploadResults))
+		if err != errInvalidFormat {
+			t.Errorf("TestImportJSONFormat Failed for %q", body)
+		}
+	}
+
+	// an array that breaks after the first record is invalid as well
+	for _, body := range []string{
+		"[{\"title\":\"No Rank\"}, {\"rank\":",
+		"[{\"title\":\"No Rank\"} {\"rank\":1}]",
+		"[{\"title\":\"No Rank\"}",
+		"[{\"title\":\"No Rank\"}}",
+	} {
+		err := ImportJSON(strings.NewReader(body), new(UploadResults))
+		if err != errInvalidFormat {
+			t.Errorf("TestImportJSONFormat Failed for %q, got %v", body, err)
+		}
+	}
+
+	err := ImportNDJSON(strings.NewReader("Rank,Title\n{}\n"), new(UploadResults))
+	if err != errInvalidFormat {
+		t.Errorf("TestImportJSONFormat Failed for NDJSON")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that JSON records follow the CSV rules for skipped and errored records
+ *
+*******************************************************************************************/
+func TestImportJSONValidation(t *testing.T) {
+	body := `[
+		{"title":"No Rank","year":2016,"runtime_min":100,"rating":7,"votes":10},
+		{"rank":2,"year":2016,"runtime_min":100,"rating":7,"votes":10},
+		{"rank":3,"title":"No Runtime","year":2016,"rating":7,"votes":10},
+		{"rank":"4","title":"Bad Rank","year":2016,"runtime_min":100,"rating":7,"votes":10}
+	]`
+	results := new(UploadResults)
+	err := ImportJSON(strings.NewReader(body), results)
+
+	// first two records are skipped, the others fail validation
+	if err != nil || results.RecordsRead != 2 || results.RecordsErrored != 2 || results.RecordsCreated != 0 {
+		t.Errorf("TestImportJSONValidation Failed %+v", results)
+	}
+
+	ndjson := strings.Replace(strings.Trim(body, "[]\n\t "), "},", "}", -1)
+	results = new(UploadResults)
+	err = ImportNDJSON(strings.NewReader(ndjson), results)
+	if err != nil || results.RecordsRead != 2 || results.RecordsErrored != 2 {
+		t.Errorf("TestImportJSONValidation Failed for NDJSON %+v", results)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test conversion of a JSON record to a Movie
+ *
+*******************************************************************************************/
+func TestValidateMovieJSON(t *testing.T) {
+	rank, year, runtime, votes := 1, 2014, 121, 757074
+	rating := 8.1
+	record := MovieJSON{Rank: &rank, Title: "Guardians of the Galaxy", Genre: []string{"Action", " Sci-Fi"},
+		Year: &year, RuntimeMin: &runtime, Rating: &rating, Votes: &votes}
+
+	movie, err := ValidateMovieJSON(&record)
+	if err != nil || movie.Genre[0] != "action" || movie.Genre[1] != "sci-fi" ||
+		movie.Year != 2014 || movie.RevenueMil != 0 || movie.Metascore != 0 {
+		t.Errorf("TestValidateMovieJSON Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test the same movie as CSV and JSON is stored with the same genres
+ *
+*******************************************************************************************/
+func TestGenresSameForCSVAndJSON(t *testing.T) {
+	line := []string{"1", "Guardians of the Galaxy", "Action, Sci-Fi,", "", "James Gunn", "", "2014", "121", "8.1", "757074", "", ""}
+	fromCSV, err := ValidateMovie(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rank, year, runtime, votes := 1, 2014, 121, 757074
+	rating := 8.1
+	record := MovieJSON{Rank: &rank, Title: "Guardians of the Galaxy", Genre: []string{"Action", " Sci-Fi", ""},
+		Year: &year, RuntimeMin: &runtime, Rating: &rating, Votes: &votes}
+	fromJSON, err := ValidateMovieJSON(&record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(fromCSV.Genre, "|") != "action|sci-fi" || strings.Join(fromJSON.Genre, "|") != "action|sci-fi" {
+		t.Errorf("Expected the same genres, got %q and %q", fromCSV.Genre, fromJSON.Genre)
+	}
+}
@@ -0,0 +1,367 @@
+/******************************************************************************
+ * \file        jwt.go
+ *
+ * \brief       GO File that validates JWT bearer tokens against a JWKS
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// JWTOptions struct for bearer token settings in config file
+type JWTOptions struct {
+	JWKSURL         string            `toml:"jwksurl"`
+	JWKSFile        string            `toml:"jwksfile"`
+	Issuer          string            `toml:"issuer"`
+	Audience        string            `toml:"audience"`
+	RoleClaim       string            `toml:"roleclaim"`
+	TenantClaim     string            `toml:"tenantclaim"`
+	Roles           map[string]string `toml:"roles"`
+	RefreshInterval string            `toml:"refreshinterval"`
+	Leeway          string            `toml:"leeway"`
+}
+
+const AUTH_SCHEME_BEARER = "Bearer"
+
+// Signing algorithms accepted for bearer tokens
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// errKeyNotFound is returned when no JWKS key matches the key id of a token
+var errKeyNotFound = errors.New("signing key not found in JWKS")
+
+/******************************************************************************************
+ *
+ * JWKS - public keys by key id, loaded from a URL or a local file
+ *
+*******************************************************************************************/
+type JWKS struct {
+	sync.Mutex
+	URL             string
+	File            string
+	RefreshInterval time.Duration
+	Client          *http.Client
+	keys            map[string]interface{}
+	loaded          time.Time
+}
+
+// jsonWebKey struct for the fields used from a JSON Web Key
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+/******************************************************************************************
+ *
+ * Find the key for a key id. The keys are reloaded after the refresh interval, or
+ * early for an unknown key id when the provider rotated its keys.
+ *
+*******************************************************************************************/
+func (j *JWKS) Key(kid string) (interface{}, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.keys == nil || time.Since(j.loaded) > j.RefreshInterval {
+		if err := j.load(); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	// a token without key id is accepted when the set has a single key
+	if len(kid) == 0 && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	// a rotated key is looked up again, at most once a minute
+	if time.Since(j.loaded) > time.Minute {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+		if key, ok := j.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, errKeyNotFound
+}
+
+// load reads the key set, the previous keys are kept if it fails
+func (j *JWKS) load() error {
+	var data []byte
+	var err error
+	if len(j.File) > 0 {
+		data, err = ioutil.ReadFile(j.File)
+	} else {
+		data, err = j.fetch()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"JWKS Load Error": err}).Warning()
+		return err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		log.WithFields(log.Fields{"JWKS Parse Error": err}).Warning()
+		return err
+	}
+	j.keys = keys
+	j.loaded = time.Now()
+	return nil
+}
+
+func (j *JWKS) fetch() ([]byte, error) {
+	resp, err := j.Client.Get(j.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: unexpected status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+/******************************************************************************************
+ *
+ * Parse a JSON Web Key Set into RSA and EC public keys by key id. Keys of other
+ * types or for encryption are skipped.
+ *
+*******************************************************************************************/
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %v", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// PublicKey of a JSON Web Key, nil for unsupported key types
+func (k *jsonWebKey) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+/******************************************************************************************
+ *
+ * JWT Authenticator - validates "Authorization: Bearer <token>"
+ *
+*******************************************************************************************/
+type JWTAuthenticator struct {
+	JWKS        *JWKS
+	RoleClaim   string
+	TenantClaim string
+	Roles       map[string]Role
+	parser      *jwt.Parser
+}
+
+/******************************************************************************************
+ *
+ * Create the JWT Authenticator from config
+ *
+*******************************************************************************************/
+func NewJWTAuthenticator(options JWTOptions) (*JWTAuthenticator, error) {
+	if len(options.JWKSURL) == 0 && len(options.JWKSFile) == 0 {
+		return nil, errors.New("auth.jwt: jwksurl or jwksfile is required")
+	}
+	if len(options.Issuer) == 0 || len(options.Audience) == 0 {
+		return nil, errors.New("auth.jwt: issuer and audience are required")
+	}
+	refresh, err := parseDurationOption(options.RefreshInterval, time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("auth.jwt: invalid refreshinterval: %v", err)
+	}
+	leeway, err := parseDurationOption(options.Leeway, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("auth.jwt: invalid leeway: %v", err)
+	}
+
+	authenticator := &JWTAuthenticator{
+		JWKS: &JWKS{
+			URL:             options.JWKSURL,
+			File:            options.JWKSFile,
+			RefreshInterval: refresh,
+			Client:          &http.Client{Timeout: 10 * time.Second},
+		},
+		RoleClaim:   options.RoleClaim,
+		TenantClaim: options.TenantClaim,
+		Roles:       make(map[string]Role),
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtMethods),
+			jwt.WithIssuer(options.Issuer),
+			jwt.WithAudience(options.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(leeway),
+		),
+	}
+	if len(authenticator.RoleClaim) == 0 {
+		authenticator.RoleClaim = "roles"
+	}
+	for value, name := range options.Roles {
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("auth.jwt: roles.%s: %v", value, err)
+		}
+		authenticator.Roles[value] = role
+	}
+	return authenticator, nil
+}
+
+func (a *JWTAuthenticator) Scheme() string {
+	return AUTH_SCHEME_BEARER
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, AUTH_SCHEME_BEARER) {
+		return nil, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(strings.TrimSpace(token), claims, a.keyFunc)
+	if err != nil {
+		log.WithFields(log.Fields{"Token Validation Failed": err}).Info()
+		return nil, errInvalidCredentials
+	}
+
+	subject, _ := claims.GetSubject()
+	if len(subject) == 0 {
+		log.Info("Token Validation Failed: no subject")
+		return nil, errInvalidCredentials
+	}
+	principal := &Principal{Subject: subject, Role: a.ClaimRole(claims), Method: AUTH_METHOD_JWT}
+	if len(a.TenantClaim) > 0 {
+		principal.Tenant, _ = claims[a.TenantClaim].(string)
+	}
+	return principal, nil
+}
+
+func (a *JWTAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return a.JWKS.Key(kid)
+}
+
+/******************************************************************************************
+ *
+ * Highest role mapped from the role claim. The claim is a string or a list of
+ * strings, nested claims are named with dots (e.g. realm_access.roles). Without a
+ * role mapping the claim values are the role names.
+ *
+*******************************************************************************************/
+func (a *JWTAuthenticator) ClaimRole(claims jwt.MapClaims) Role {
+	var value interface{} = map[string]interface{}(claims)
+	for _, name := range strings.Split(a.RoleClaim, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ROLE_PUBLIC
+		}
+		value = object[name]
+	}
+
+	var values []string
+	switch v := value.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	highest := ROLE_PUBLIC
+	for _, v := range values {
+		role, ok := a.Roles[v]
+		if len(a.Roles) == 0 {
+			parsed, err := ParseRole(v)
+			role, ok = parsed, err == nil
+		}
+		if ok && role > highest {
+			highest = role
+		}
+	}
+	return highest
+}
@@ -0,0 +1,207 @@
+/******************************************************************************
+ * \file        jwt_test.go
+ *
+ * \brief       GO File that tests JWT bearer token validation
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "imdb-restapi"
+)
+
+/******************************************************************************************
+ *
+ * Generate a signing key and write its JWKS to a file
+ *
+*******************************************************************************************/
+func newTestJWKS(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test-key",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "jdoe",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"movies-uploader"},
+	}
+}
+
+func newTestJWTAuthenticator(t *testing.T, jwksFile string) *JWTAuthenticator {
+	authenticator, err := NewJWTAuthenticator(JWTOptions{
+		JWKSFile:  jwksFile,
+		Issuer:    testIssuer,
+		Audience:  testAudience,
+		RoleClaim: "roles",
+		Roles:     map[string]string{"movies-reader": "reader", "movies-uploader": "uploader", "movies-admin": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func authenticateBearer(a Authenticator, token string) (*Principal, error) {
+	req, _ := http.NewRequest("GET", "/imdb/movies", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(req)
+}
+
+func TestJWTValidToken(t *testing.T) {
+	key, jwksFile := newTestJWKS(t)
+	authenticator := newTestJWTAuthenticator(t, jwksFile)
+
+	principal, err := authenticateBearer(authenticator, signTestToken(t, key, validClaims()))
+	if err != nil || principal == nil {
+		t.Fatalf("TestJWTValidToken Failed %v", err)
+	}
+	if principal.Subject != "jdoe" || principal.Role != ROLE_UPLOADER || principal.Method != AUTH_METHOD_JWT {
+		t.Errorf("TestJWTValidToken Failed %+v", principal)
+	}
+}
+
+func TestJWTInvalidTokens(t *testing.T) {
+	key, jwksFile := newTestJWKS(t)
+	authenticator := newTestJWTAuthenticator(t, jwksFile)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	cases := map[string]func(claims jwt.MapClaims) *rsa.PrivateKey{
+		"expired":        func(c jwt.MapClaims) *rsa.PrivateKey { c["exp"] = time.Now().Add(-time.Hour).Unix(); return key },
+		"no expiry":      func(c jwt.MapClaims) *rsa.PrivateKey { delete(c, "exp"); return key },
+		"wrong issuer":   func(c jwt.MapClaims) *rsa.PrivateKey { c["iss"] = "https://evil.example.com"; return key },
+		"wrong audience": func(c jwt.MapClaims) *rsa.PrivateKey { c["aud"] = "other-service"; return key },
+		"wrong key":      func(c jwt.MapClaims) *rsa.PrivateKey { return otherKey },
+		"no subject":     func(c jwt.MapClaims) *rsa.PrivateKey { delete(c, "sub"); return key },
+	}
+	for name, modify := range cases {
+		claims := validClaims()
+		signingKey := modify(claims)
+		if principal, err := authenticateBearer(authenticator, signTestToken(t, signingKey, claims)); err != errInvalidCredentials || principal != nil {
+			t.Errorf("%s: expected invalid credentials, got %+v %v", name, principal, err)
+		}
+	}
+
+	if _, err := authenticateBearer(authenticator, "not.a.token"); err != errInvalidCredentials {
+		t.Errorf("malformed: expected invalid credentials, got %v", err)
+	}
+}
+
+func TestJWTNoBearer(t *testing.T) {
+	_, jwksFile := newTestJWKS(t)
+	authenticator := newTestJWTAuthenticator(t, jwksFile)
+
+	req, _ := http.NewRequest("GET", "/imdb/movies", nil)
+	req.Header.Set("Authorization", "ApiKey something")
+	if principal, err := authenticator.Authenticate(req); principal != nil || err != nil {
+		t.Errorf("Expected other schemes to be left to other authenticators, got %+v %v", principal, err)
+	}
+}
+
+func TestJWTClaimRole(t *testing.T) {
+	authenticator := &JWTAuthenticator{RoleClaim: "realm_access.roles", Roles: map[string]Role{}}
+
+	claims := jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"reader", "admin"}}}
+	if role := authenticator.ClaimRole(claims); role != ROLE_ADMIN {
+		t.Errorf("Expected the highest role of a nested claim, got %v", role)
+	}
+
+	authenticator.RoleClaim = "scope"
+	if role := authenticator.ClaimRole(jwt.MapClaims{"scope": "openid reader"}); role != ROLE_READER {
+		t.Errorf("Expected a role from a space separated claim, got %v", role)
+	}
+	if role := authenticator.ClaimRole(jwt.MapClaims{"scope": "openid"}); role != ROLE_PUBLIC {
+		t.Errorf("Expected no role, got %v", role)
+	}
+}
+
+func TestJWTFromJWKSURL(t *testing.T) {
+	key, jwksFile := newTestJWKS(t)
+	data, _ := ioutil.ReadFile(jwksFile)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	authenticator, err := NewJWTAuthenticator(JWTOptions{JWKSURL: server.URL, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := validClaims()
+	claims["roles"] = "admin"
+	principal, err := authenticateBearer(authenticator, signTestToken(t, key, claims))
+	if err != nil || principal == nil || principal.Role != ROLE_ADMIN {
+		t.Errorf("TestJWTFromJWKSURL Failed %+v %v", principal, err)
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	key, jwksFile := newTestJWKS(t)
+	auth = &Auth{Authenticators: []Authenticator{newTestJWTAuthenticator(t, jwksFile)}}
+	defer func() { auth = nil }()
+
+	claims := validClaims()
+	claims["roles"] = []string{"movies-reader"}
+	req, _ := http.NewRequest("GET", "/imdb/uploads", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestToken(t, key, claims))
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/imdb/uploads", nil)
+	response = httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+	if response.Header().Get("WWW-Authenticate") != AUTH_SCHEME_BEARER {
+		t.Errorf("Expected a Bearer challenge, got %q", response.Header().Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,303 @@
+/******************************************************************************
+ * \file        logging.go
+ *
+ * \brief       GO File that configures log levels, format, outputs and rotation,
+ *              with an endpoint to change levels at runtime
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LoggingOptions struct for logging settings in config file
+type LoggingOptions struct {
+	Level      string            `toml:"level"`
+	Format     string            `toml:"format"`
+	Outputs    []string          `toml:"outputs"`
+	File       string            `toml:"file"`
+	AccessFile string            `toml:"accessfile"`
+	MaxSizeMB  int               `toml:"maxsizemb"`
+	MaxAgeDays int               `toml:"maxagedays"`
+	MaxBackups int               `toml:"maxbackups"`
+	Compress   bool              `toml:"compress"`
+	Modules    map[string]string `toml:"modules"`
+}
+
+// Log formats and outputs
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	LOG_OUTPUT_STDOUT = "stdout"
+	LOG_OUTPUT_STDERR = "stderr"
+	LOG_OUTPUT_FILE   = "file"
+)
+
+// Log modules, each has its own level
+const (
+	MODULE_APP        = "app"
+	MODULE_ACCESS     = "access"
+	MODULE_INGEST     = "ingest"
+	MODULE_DATABASE   = "database"
+	MODULE_WEBHOOKS   = "webhooks"
+	MODULE_ENRICHMENT = "enrichment"
+)
+
+// Loggers of the modules, the app module is the standard logger
+var (
+	ingestLog     = log.New()
+	databaseLog   = log.New()
+	webhooksLog   = log.New()
+	enrichmentLog = log.New()
+
+	loggers = map[string]*log.Logger{
+		MODULE_APP:        log.StandardLogger(),
+		MODULE_ACCESS:     accessLog,
+		MODULE_INGEST:     ingestLog,
+		MODULE_DATABASE:   databaseLog,
+		MODULE_WEBHOOKS:   webhooksLog,
+		MODULE_ENRICHMENT: enrichmentLog,
+	}
+)
+
+// Rotators of the log files by path, a file keeps its rotator over reconfigurations
+// and the rotators of files no longer logged to are closed
+var (
+	logFilesMu sync.Mutex
+	logFiles   = make(map[string]*lumberjack.Logger)
+)
+
+// Levels changed with the log level endpoint, they override the config until a restart
+var (
+	levelOverridesMu sync.Mutex
+	levelOverrides   = make(map[string]log.Level)
+)
+
+/******************************************************************************************
+ *
+ * Configure the loggers of all modules from config, levels changed at runtime stay. Log files are created in logdir
+ * and rotated by size and age, the access log is always JSON in its own file.
+ *
+*******************************************************************************************/
+func ConfigureLogging(options LoggingOptions, logdir string) error {
+	level, err := parseLogLevel(options.Level, log.InfoLevel)
+	if err != nil {
+		return fmt.Errorf("logging: %v", err)
+	}
+	levels := make(map[string]log.Level, len(loggers))
+	for module := range loggers {
+		levels[module] = level
+	}
+	for module, value := range options.Modules {
+		if _, ok := loggers[module]; !ok {
+			return fmt.Errorf("logging: unknown module %q", module)
+		}
+		if levels[module], err = parseLogLevel(value, level); err != nil {
+			return fmt.Errorf("logging: module %s: %v", module, err)
+		}
+	}
+	levelOverridesMu.Lock()
+	for module, override := range levelOverrides {
+		levels[module] = override
+	}
+	levelOverridesMu.Unlock()
+
+	var formatter log.Formatter
+	switch strings.ToLower(options.Format) {
+	case LOG_FORMAT_TEXT, "":
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	case LOG_FORMAT_JSON:
+		formatter = &log.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		return fmt.Errorf("logging: unknown format %q", options.Format)
+	}
+
+	outputs := options.Outputs
+	if outputs == nil {
+		outputs = []string{LOG_OUTPUT_STDOUT, LOG_OUTPUT_FILE}
+	}
+	logFilesMu.Lock()
+	defer logFilesMu.Unlock()
+	files := make(map[string]*lumberjack.Logger)
+	out, err := logOutput(outputs, options, logdir, options.File, "imdb-restapi.log", files)
+	if err != nil {
+		return err
+	}
+	accessOut, err := logOutput(outputs, options, logdir, options.AccessFile, "imdb-access.log", files)
+	if err != nil {
+		return err
+	}
+
+	for module, logger := range loggers {
+		logger.SetLevel(levels[module])
+		if module == MODULE_ACCESS {
+			logger.SetOutput(accessOut)
+			continue
+		}
+		logger.SetFormatter(formatter)
+		logger.SetOutput(out)
+	}
+
+	// the loggers no longer write to the replaced rotators
+	for path, rotator := range logFiles {
+		if files[path] != rotator {
+			rotator.Close()
+		}
+	}
+	logFiles = files
+	return nil
+}
+
+func parseLogLevel(value string, def log.Level) (log.Level, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	return log.ParseLevel(value)
+}
+
+// logOutput writes to the chosen outputs, file is rotated in logdir by the rotator
+// of the file if its settings are unchanged, the rotators used are added to files
+func logOutput(outputs []string, options LoggingOptions, logdir string, file string, def string, files map[string]*lumberjack.Logger) (io.Writer, error) {
+	var writers []io.Writer
+	for _, output := range outputs {
+		switch strings.ToLower(output) {
+		case LOG_OUTPUT_STDOUT:
+			writers = append(writers, os.Stdout)
+		case LOG_OUTPUT_STDERR:
+			writers = append(writers, os.Stderr)
+		case LOG_OUTPUT_FILE:
+			if len(file) == 0 {
+				file = def
+			}
+			filename := logdir + file
+			rotator := files[filename]
+			if rotator == nil {
+				rotator = logFiles[filename]
+			}
+			if rotator == nil || rotator.MaxSize != options.MaxSizeMB || rotator.MaxAge != options.MaxAgeDays ||
+				rotator.MaxBackups != options.MaxBackups || rotator.Compress != options.Compress {
+				rotator = &lumberjack.Logger{
+					Filename:   filename,
+					MaxSize:    options.MaxSizeMB,
+					MaxAge:     options.MaxAgeDays,
+					MaxBackups: options.MaxBackups,
+					Compress:   options.Compress,
+					LocalTime:  true,
+				}
+			}
+			files[filename] = rotator
+			writers = append(writers, rotator)
+		default:
+			return nil, fmt.Errorf("logging: unknown output %q", output)
+		}
+	}
+	if len(writers) == 0 {
+		return io.Discard, nil
+	}
+	return io.MultiWriter(writers...), nil
+}
+
+/******************************************************************************************
+ *
+ * Logger of a module with the request ID of the request of a context
+ *
+*******************************************************************************************/
+func ModuleLog(ctx context.Context, module string) *log.Entry {
+	logger, ok := loggers[module]
+	if !ok {
+		logger = log.StandardLogger()
+	}
+	entry := log.NewEntry(logger)
+	if ctx != nil {
+		if info := requestInfoOf(ctx); info != nil {
+			entry = entry.WithField("RequestID", info.ID)
+		}
+	}
+	return entry
+}
+
+// LogLevels struct for the log level endpoint, levels of modules by name
+type LogLevels struct {
+	Level   string            `json:"level,omitempty"`
+	Module  string            `json:"module,omitempty"`
+	Modules map[string]string `json:"modules,omitempty"`
+}
+
+func currentLogLevels() LogLevels {
+	levels := LogLevels{Level: log.GetLevel().String(), Modules: make(map[string]string, len(loggers))}
+	for module, logger := range loggers {
+		levels.Modules[module] = logger.GetLevel().String()
+	}
+	return levels
+}
+
+/******************************************************************************************
+ *
+ * Get the log level of every module
+ *
+*******************************************************************************************/
+func GetLogLevels(w http.ResponseWriter, r *http.Request) {
+	if !tenantAdmin(w, r) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, currentLogLevels())
+}
+
+/******************************************************************************************
+ *
+ * Change the log level of a module, or of all modules without a module, until the
+ * next restart, e.g. {"module": "ingest", "level": "debug"}. Config reloads keep it.
+ *
+*******************************************************************************************/
+func PutLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !tenantAdmin(w, r) {
+		return
+	}
+	var body LogLevels
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithErrorCode(w, ERR_LOG_LEVEL_INVALID)
+		return
+	}
+	level, err := log.ParseLevel(body.Level)
+	if err != nil {
+		respondWithErrorDetail(w, ERR_LOG_LEVEL_INVALID, err.Error())
+		return
+	}
+
+	modules := []string{body.Module}
+	if len(body.Module) == 0 {
+		modules = modules[:0]
+		for module := range loggers {
+			modules = append(modules, module)
+		}
+		sort.Strings(modules)
+	} else if _, ok := loggers[body.Module]; !ok {
+		respondWithErrorDetail(w, ERR_LOG_LEVEL_INVALID, fmt.Sprintf("unknown module %q", body.Module))
+		return
+	}
+	levelOverridesMu.Lock()
+	for _, module := range modules {
+		levelOverrides[module] = level
+		loggers[module].SetLevel(level)
+	}
+	levelOverridesMu.Unlock()
+	RequestLog(r).WithFields(log.Fields{"Modules": strings.Join(modules, ","), "Level": level.String()}).Warning("Log level changed")
+	respondWithJSON(w, http.StatusOK, currentLogLevels())
+}
@@ -0,0 +1,192 @@
+/******************************************************************************
+ * \file        logging_test.go
+ *
+ * \brief       GO File that tests the logging config and the log level endpoint
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// withDefaultLogging restores the loggers of all modules after a test
+func withDefaultLogging(t *testing.T) {
+	t.Cleanup(func() {
+		levelOverridesMu.Lock()
+		levelOverrides = make(map[string]log.Level)
+		levelOverridesMu.Unlock()
+		logFilesMu.Lock()
+		for _, rotator := range logFiles {
+			rotator.Close()
+		}
+		logFiles = make(map[string]*lumberjack.Logger)
+		logFilesMu.Unlock()
+		for module, logger := range loggers {
+			logger.SetLevel(log.InfoLevel)
+			logger.SetOutput(os.Stderr)
+			if module != MODULE_ACCESS {
+				logger.SetFormatter(&log.TextFormatter{})
+			}
+		}
+	})
+}
+
+func TestConfigureLogging(t *testing.T) {
+	withDefaultLogging(t)
+	dir := t.TempDir() + "/"
+
+	err := ConfigureLogging(LoggingOptions{
+		Level:   "warn",
+		Format:  LOG_FORMAT_JSON,
+		Outputs: []string{LOG_OUTPUT_FILE},
+		Modules: map[string]string{MODULE_INGEST: "debug", MODULE_ACCESS: "error"},
+	}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.GetLevel() != log.WarnLevel || ingestLog.GetLevel() != log.DebugLevel || accessLog.GetLevel() != log.ErrorLevel {
+		t.Errorf("Expected the levels of the modules, got %+v", currentLogLevels())
+	}
+
+	log.Info("not logged")
+	databaseLog.WithFields(log.Fields{"Operation": "FindMovies"}).Warning("logged")
+	data, _ := os.ReadFile(filepath.Join(dir, "imdb-restapi.log"))
+	var entry map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+		t.Fatalf("Expected one JSON line, got %s", data)
+	}
+	if entry["msg"] != "logged" || entry["Operation"] != "FindMovies" {
+		t.Errorf("Expected the warning of the database module, got %v", entry)
+	}
+
+	for _, options := range []LoggingOptions{
+		{Level: "loud"},
+		{Format: "xml"},
+		{Outputs: []string{"syslog"}},
+		{Modules: map[string]string{"parser": "debug"}},
+		{Modules: map[string]string{MODULE_INGEST: "quiet"}},
+	} {
+		if err := ConfigureLogging(options, dir); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
+
+func TestLoggingConfigFile(t *testing.T) {
+	var config TomlConfig
+	if _, err := toml.DecodeFile("data/config.toml", &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Logging.Level != "info" || config.Logging.MaxSizeMB == 0 || config.Logging.Modules[MODULE_INGEST] == "" {
+		t.Errorf("Expected the [logging] section of config.toml, got %+v", config.Logging)
+	}
+}
+
+func putLogLevel(body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PUT", "/imdb/logging", strings.NewReader(body))
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+func TestPutLogLevel(t *testing.T) {
+	withDefaultLogging(t)
+	withAccessLog(t)
+
+	response := putLogLevel(`{"module": "ingest", "level": "debug"}`)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if ingestLog.GetLevel() != log.DebugLevel || log.GetLevel() != log.InfoLevel {
+		t.Errorf("Expected only the ingest level to change, got %s", response.Body.String())
+	}
+
+	checkResponseCode(t, http.StatusOK, putLogLevel(`{"level": "error"}`).Code)
+	for module, logger := range loggers {
+		if logger.GetLevel() != log.ErrorLevel {
+			t.Errorf("Expected module %s at error, got %s", module, logger.GetLevel())
+		}
+	}
+
+	checkResponseCode(t, http.StatusBadRequest, putLogLevel(`{"level": "loud"}`).Code)
+	checkResponseCode(t, http.StatusBadRequest, putLogLevel(`{"module": "parser", "level": "debug"}`).Code)
+
+	response = serveWithKey("GET", "/imdb/logging", "")
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var levels LogLevels
+	if err := json.Unmarshal(response.Body.Bytes(), &levels); err != nil || levels.Modules[MODULE_WEBHOOKS] != "error" {
+		t.Errorf("Expected the levels of the modules, got %s", response.Body.String())
+	}
+}
+
+func TestLogLevelRequiresAdmin(t *testing.T) {
+	withAPIKeys(t)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/logging", "uploader-key").Code)
+}
+
+func TestConfigureLoggingFiles(t *testing.T) {
+	withDefaultLogging(t)
+	dir := t.TempDir() + "/"
+	options := LoggingOptions{Outputs: []string{LOG_OUTPUT_FILE}, MaxSizeMB: 10}
+
+	if err := ConfigureLogging(options, dir); err != nil {
+		t.Fatal(err)
+	}
+	log.Warning("first")
+	first := logFiles[dir+"imdb-restapi.log"]
+	if first == nil || len(logFiles) != 2 {
+		t.Fatalf("Expected a rotator per log file, got %v", logFiles)
+	}
+
+	// a reload with the same settings keeps the rotators
+	if err := ConfigureLogging(options, dir); err != nil {
+		t.Fatal(err)
+	}
+	if logFiles[dir+"imdb-restapi.log"] != first {
+		t.Errorf("Expected the rotator of the file to be reused")
+	}
+
+	// new settings replace the rotator, files no longer written are dropped
+	options.MaxSizeMB = 20
+	options.AccessFile = "access.log"
+	if err := ConfigureLogging(options, dir); err != nil {
+		t.Fatal(err)
+	}
+	log.Warning("second")
+	if logFiles[dir+"imdb-restapi.log"] == first || logFiles[dir+"imdb-access.log"] != nil || logFiles[dir+"access.log"] == nil {
+		t.Errorf("Expected the rotators of the new settings, got %v", logFiles)
+	}
+	data, _ := os.ReadFile(dir + "imdb-restapi.log")
+	if strings.Count(string(data), "\n") != 2 {
+		t.Errorf("Expected both entries in the log file, got %q", data)
+	}
+
+	if err := ConfigureLogging(LoggingOptions{Outputs: []string{}}, dir); err != nil || len(logFiles) != 0 {
+		t.Errorf("Expected no rotators without file output, got %v %v", err, logFiles)
+	}
+}
+
+func TestModuleLog(t *testing.T) {
+	ctx := context.WithValue(context.Background(), requestKey{}, &requestInfo{ID: "req-7"})
+	entry := ModuleLog(ctx, MODULE_INGEST)
+	if entry.Logger != ingestLog || entry.Data["RequestID"] != "req-7" {
+		t.Errorf("Expected the ingest logger with the request ID, got %+v", entry.Data)
+	}
+	if ModuleLog(context.Background(), "unknown").Logger != log.StandardLogger() {
+		t.Errorf("Expected the standard logger for an unknown module")
+	}
+}
@@ -10,13 +10,12 @@
 package main
 
 import (
+    "context"
     log "github.com/sirupsen/logrus"
-    "net/http"
-    "github.com/gorilla/mux"
     "os"
-    "fmt"
-    "io"
-    "github.com/BurntSushi/toml"
+    "flag"
+    "os/signal"
+    "syscall"
 )
 
 // TomlConfig struct for config file
@@ -24,46 +23,52 @@ type TomlConfig struct {
     App struct {
         Port string `toml:"port"`
         Logdir string `toml:"logdir"`
+        ServerOptions
+        TLS TLSOptions `toml:"tls"`
     } `toml:"app"`
     Database struct {
-        Server string `toml:"server"`
+        Server string `toml:"server" secret:"userinfo"`
         Port string `toml:"port"`
         DBName string `toml:"dbname"`
+        MonitorOptions
     } `toml:"database"`
 	Settings struct{
 		DefaultYear int `toml:"defaultyear"`
 		FileSizeKB int64 `toml:"filesizekb"`
-	}
+	} `toml:"settings"`
+	Dataset DatasetOptions `toml:"dataset"`
+	Enrichment EnrichmentOptions `toml:"enrichment"`
+	Webhooks WebhookOptions `toml:"webhooks"`
+	Progress ProgressOptions `toml:"progress"`
+	Auth AuthOptions `toml:"auth"`
+	RateLimit RateLimitOptions `toml:"ratelimit"`
+	Quotas QuotaOptions `toml:"quotas"`
+	Reviews ReviewOptions `toml:"reviews"`
+	Tracing TracingOptions `toml:"tracing"`
+	Logging LoggingOptions `toml:"logging"`
+	Reload ReloadOptions `toml:"reload"`
+	CORS CORSOptions `toml:"cors"`
+	Validation ValidationOptions `toml:"validation"`
+	CSV struct {
+		Profile string `toml:"profile"`
+		Profiles map[string]CSVProfile `toml:"profiles"`
+	} `toml:"csv"`
 }
 
-// Config File
-var conf TomlConfig
-
 // Database Access Object
 var dao = MoviesDAO{}
 
 /******************************************************************************************
  *
- * Initialize Logger to log to File and Console
+ * Initialize Logger to log to File and Console, as configured in [logging]
  *
 *******************************************************************************************/
 func InitLogger() {
-    var filename string = "imdb-restapi.log"
-    filename = conf.App.Logdir + filename
-    log.Info(filename)
-    f, err := os.OpenFile(filename, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
-    Formatter := new(log.TextFormatter)
-    Formatter.FullTimestamp = true
-    log.SetFormatter(Formatter)
-
-    mw := io.MultiWriter(os.Stdout, f)
-    if err != nil {
-        // Cannot open log file. Logging to stderr
-        fmt.Println(err)
-    }else{
-        log.SetOutput(mw)
+    conf := Config()
+    if err := ConfigureLogging(conf.Logging, conf.App.Logdir); err != nil {
+        log.Fatal(err)
     }
-    log.Info("Initialized Logger")
+    log.WithFields(log.Fields{"Level":log.GetLevel().String(), "Format":conf.Logging.Format}).Info("Initialized Logger")
 }
 
 
@@ -74,11 +79,28 @@ func InitLogger() {
 *******************************************************************************************/
 func main() {
 
-    if _, err := toml.DecodeFile("data/config.toml", &conf); err != nil {
+    importDir := flag.String("import-imdb", "", "import the IMDb TSV datasets in `dir` and exit")
+    importTenant := flag.String("tenant", "", "`tenant` the IMDb datasets are imported into, the default catalog if empty")
+    printConfig := flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+    loader := RegisterConfigFlags(flag.CommandLine)
+    flag.Parse()
+
+    conf, err := loader.Load()
+    if err != nil {
         log.Fatal(err)
     }
+    SetConfig(conf)
+    configLoaded = true
+
+    if *printConfig {
+        if err := conf.WriteRedacted(os.Stdout); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
 
     InitLogger()
+    log.WithFields(log.Fields{"Config File":loader.Loaded}).Info("Loaded config")
 
     log.WithFields(log.Fields{"Application Port":conf.App.Port}).Info()
     log.WithFields(log.Fields{"Database Port":conf.Database.Port}).Info()
@@ -87,16 +109,151 @@ func main() {
 
 	dao.Server = conf.Database.Server
 	dao.Database =  conf.Database.DBName
-	dao.Connect()
 
-	log.WithFields(log.Fields{"Established connection to database":dao.Database}).Info()
+	// the IMDb dataset import and apikey command need the database right away
+	if len(*importDir) > 0 || flag.Arg(0) == "apikey" {
+		if err := dao.Connect(); err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{"Established connection to database":dao.Database}).Info()
+	}
+
+	// run the IMDb dataset import instead of the server
+	if len(*importDir) > 0 {
+		if len(*importTenant) > 0 {
+			if _, err := dao.FindTenant(*importTenant); err != nil {
+				log.Fatalf("tenant %s: %v", *importTenant, err)
+			}
+		}
+		if _, err := ImportIMDbDataset(dao.ForTenant(*importTenant), *importDir, conf.Dataset); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// manage API keys instead of running the server
+	if flag.Arg(0) == "apikey" {
+		if err := RunAPIKeyCommand(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// work drained on shutdown after in-flight requests
+	var drains []func(context.Context) error
+
+	// export spans of requests, handlers and database calls
+	var flushTraces func(context.Context) error
+	if conf.Tracing.Enabled {
+		var err error
+		if flushTraces, err = InitTracing(conf.Tracing); err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{"Trace Exporter":conf.Tracing.Exporter}).Info()
+	}
+
+	// the server starts without the database and becomes ready once it is connected
+	monitor, err := NewDatabaseMonitor(&dao, conf.Database.MonitorOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	database = monitor
+	go monitor.Run()
+
+	// fill missing revenue and metascore in the background
+	if conf.Enrichment.Enabled {
+		enricher, err := NewEnricher(conf.Enrichment)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go enricher.Run()
+		drains = append(drains, enricher.Drain)
+	}
+
+	// deliver catalog change events to webhook subscriptions
+	dispatcher, err := NewWebhookDispatcher(&dao, conf.Webhooks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks = dispatcher
+	drains = append(drains, dispatcher.Drain)
+
+	uploads = NewUploadTracker(conf.Progress)
+
+	// catalogs of tenants are resolved from the tenants collection
+	tenants = &dao
+
+	// require credentials on all endpoints but version and endpoints
+	if conf.Auth.Enabled {
+		auth, err = NewAuth(conf.Auth, &dao)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Warning("Authentication is turned off, every route including the admin routes is open")
+	}
+
+	// let scripts of other origins, like the admin tool, call the service
+	if conf.CORS.Enabled {
+		cors, err = NewCORS(conf.CORS)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// check responses against the API description during development
+	responseValidation = conf.Validation.Responses
+	if responseValidation {
+		log.Warning("Responses are validated against the API description, this is slower")
+	}
+
+	// throttle clients per route and limit their daily uploads
+	if conf.RateLimit.Enabled {
+		rateLimiter, err = NewRateLimiter(conf.RateLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Warning("Rate limiting is turned off, clients are not throttled")
+	}
+	quotas = NewQuotaTracker(conf.Quotas, &dao)
+
+	// flag reviews with banned words for moderation
+	moderation = NewReviewModeration(conf.Reviews)
+
+	// apply changes to [settings], [csv] and [logging] on SIGHUP or when the config file changes
+	reloader, err := NewConfigReloader(loader, conf.Reload)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go reloader.Run(hangups)
+
+    server, err := NewServer(conf.App.Port, NewRouter(), conf.App.ServerOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-    router := mux.NewRouter()
-    router.HandleFunc("/imdb/version", GetVersion).Methods("GET") // get version
-    router.HandleFunc("/imdb/uploadmovies", PostCSV).Methods("POST") // post movie uploads
-    router.HandleFunc("/imdb/movies", GetMovies).Methods("GET") // get movies
-    router.HandleFunc("/imdb/endpoints", GetEndpoints).Methods("GET") // get Rest Endpoint Info
+	// terminate TLS, verifying client certificates if configured
+	if conf.App.TLS.Enabled {
+		if server.TLSConfig, err = NewTLSConfig(conf.App.TLS); err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{"TLS Certificate":conf.App.TLS.CertFile, "TLS Client Auth":conf.App.TLS.ClientAuth}).Info()
+	}
 
 	log.Info("Server is up and ready")
-    log.Fatal(http.ListenAndServe(conf.App.Port, router))
+	// uploads in progress finish before the database is closed, their spans are exported last
+	drains = append(drains, monitor.Drain)
+	if flushTraces != nil {
+		drains = append(drains, flushTraces)
+	}
+	err = ServeGracefully(server, conf.App.ServerOptions, drains...)
+	signal.Stop(hangups)
+	reloader.Close()
+	dao.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,218 @@
+/******************************************************************************
+ * \file        metrics.go
+ *
+ * \brief       GO File that collects Prometheus metrics of requests, uploads and
+ *              database calls
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/mgo.v2"
+)
+
+const METRICS_NAMESPACE = "imdb"
+
+// HTTP metrics by route name, method and status code
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method", "status"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+)
+
+// Upload metrics
+var (
+	uploadRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "upload_rows_total",
+		Help:      "Uploaded movie rows by result: read, created or errored.",
+	}, []string{"result"})
+
+	uploadsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "uploads_total",
+		Help:      "Finished uploads by outcome: completed or failed.",
+	}, []string{"outcome"})
+)
+
+// Config reloads by result: applied or failed
+var configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "config_reloads_total",
+	Help:      "Config reloads by result: applied or failed.",
+}, []string{"result"})
+
+// Database metrics by DAO operation
+var (
+	daoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Database operation latency by DAO operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	daoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "db_operation_errors_total",
+		Help:      "Failed database operations by DAO operation, not found is not a failure.",
+	}, []string{"operation"})
+)
+
+func init() {
+	// socket and session counts of the mgo driver
+	mgo.SetStats(true)
+	prometheus.MustRegister(mgoCollector{})
+}
+
+/******************************************************************************************
+ *
+ * Metrics Middleware - counts requests and their latency by route name, so every
+ * route of the router is measured
+ *
+*******************************************************************************************/
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		labels := prometheus.Labels{"route": RouteName(r), "method": r.Method, "status": strconv.Itoa(recorder.status)}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder keeps the status code and size of a response, event streams can still flush
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := s.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijacking is not supported")
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+/******************************************************************************************
+ *
+ * Record the rows and outcome of a finished upload
+ *
+*******************************************************************************************/
+func observeUpload(results *UploadResults, err error) {
+	uploadRows.WithLabelValues("read").Add(float64(results.RecordsRead))
+	uploadRows.WithLabelValues("created").Add(float64(results.RecordsCreated))
+	uploadRows.WithLabelValues("errored").Add(float64(results.RecordsErrored))
+	if err != nil {
+		uploadsFinished.WithLabelValues("failed").Inc()
+	} else {
+		uploadsFinished.WithLabelValues("completed").Inc()
+	}
+}
+
+/******************************************************************************************
+ *
+ * Record the latency and error of a DAO operation started at start, returns err
+ *
+*******************************************************************************************/
+func observeDAO(operation string, start time.Time, err error) error {
+	daoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != mgo.ErrNotFound {
+		daoErrors.WithLabelValues(operation).Inc()
+	}
+	return err
+}
+
+/******************************************************************************************
+ *
+ * Collector of the mgo session pool statistics
+ *
+*******************************************************************************************/
+type mgoCollector struct{}
+
+var (
+	mgoClusters     = prometheus.NewDesc("imdb_mongo_clusters", "Clusters known to the driver.", nil, nil)
+	mgoConns        = prometheus.NewDesc("imdb_mongo_connections", "Connections to servers by role.", []string{"role"}, nil)
+	mgoSocketsAlive = prometheus.NewDesc("imdb_mongo_sockets_alive", "Open sockets in the session pool.", nil, nil)
+	mgoSocketsInUse = prometheus.NewDesc("imdb_mongo_sockets_in_use", "Sockets used by sessions.", nil, nil)
+	mgoSocketRefs   = prometheus.NewDesc("imdb_mongo_socket_refs", "Session references to sockets.", nil, nil)
+	mgoOps          = prometheus.NewDesc("imdb_mongo_ops_total", "Operations sent to and replies received from servers.", []string{"direction"}, nil)
+	mgoDocs         = prometheus.NewDesc("imdb_mongo_received_docs_total", "Documents received from servers.", nil, nil)
+)
+
+func (mgoCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{mgoClusters, mgoConns, mgoSocketsAlive, mgoSocketsInUse, mgoSocketRefs, mgoOps, mgoDocs} {
+		ch <- desc
+	}
+}
+
+func (mgoCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := mgo.GetStats()
+	ch <- prometheus.MustNewConstMetric(mgoClusters, prometheus.GaugeValue, float64(stats.Clusters))
+	ch <- prometheus.MustNewConstMetric(mgoConns, prometheus.GaugeValue, float64(stats.MasterConns), "master")
+	ch <- prometheus.MustNewConstMetric(mgoConns, prometheus.GaugeValue, float64(stats.SlaveConns), "slave")
+	ch <- prometheus.MustNewConstMetric(mgoSocketsAlive, prometheus.GaugeValue, float64(stats.SocketsAlive))
+	ch <- prometheus.MustNewConstMetric(mgoSocketsInUse, prometheus.GaugeValue, float64(stats.SocketsInUse))
+	ch <- prometheus.MustNewConstMetric(mgoSocketRefs, prometheus.GaugeValue, float64(stats.SocketRefs))
+	ch <- prometheus.MustNewConstMetric(mgoOps, prometheus.CounterValue, float64(stats.SentOps), "sent")
+	ch <- prometheus.MustNewConstMetric(mgoOps, prometheus.CounterValue, float64(stats.ReceivedOps), "received")
+	ch <- prometheus.MustNewConstMetric(mgoDocs, prometheus.CounterValue, float64(stats.ReceivedDocs))
+}
@@ -0,0 +1,75 @@
+/******************************************************************************
+ * \file        metrics_test.go
+ *
+ * \brief       GO File that tests the Prometheus metrics
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/mgo.v2"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/version", "").Code)
+	serveWithKey("GET", "/imdb/tenants/unknown/movies", "")
+
+	response := serveWithKey("GET", "/metrics", "")
+	checkResponseCode(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	for _, metric := range []string{
+		`imdb_http_requests_total{method="GET",route="GetVersion",status="200"}`,
+		`imdb_http_request_duration_seconds_bucket{method="GET",route="GetVersion",status="200",le="0.005"}`,
+		// tenant routes are counted by the name of the route they prefix
+		`imdb_http_requests_total{method="GET",route="GetMovies",status="404"}`,
+		`imdb_mongo_sockets_alive`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("Expected %s in the metrics", metric)
+		}
+	}
+}
+
+func TestObserveUpload(t *testing.T) {
+	created := testutil.ToFloat64(uploadRows.WithLabelValues("created"))
+	failed := testutil.ToFloat64(uploadsFinished.WithLabelValues("failed"))
+
+	observeUpload(&UploadResults{RecordsRead: 10, RecordsCreated: 7, RecordsErrored: 3}, nil)
+	observeUpload(&UploadResults{RecordsRead: 2, RecordsCreated: 1, RecordsErrored: 1}, errUploadFailed)
+
+	if value := testutil.ToFloat64(uploadRows.WithLabelValues("created")) - created; value != 8 {
+		t.Errorf("Expected 8 created rows, got %v", value)
+	}
+	if value := testutil.ToFloat64(uploadsFinished.WithLabelValues("failed")) - failed; value != 1 {
+		t.Errorf("Expected 1 failed upload, got %v", value)
+	}
+}
+
+func TestObserveDAO(t *testing.T) {
+	before := testutil.ToFloat64(daoErrors.WithLabelValues("TestOperation"))
+
+	err := errors.New("connection reset")
+	if observeDAO("TestOperation", time.Now(), err) != err {
+		t.Errorf("Expected the error to be returned")
+	}
+	observeDAO("TestOperation", time.Now(), mgo.ErrNotFound)
+	observeDAO("TestOperation", time.Now(), nil)
+
+	if value := testutil.ToFloat64(daoErrors.WithLabelValues("TestOperation")) - before; value != 1 {
+		t.Errorf("Expected only the failure to count as an error, got %v", value)
+	}
+	if count := testutil.CollectAndCount(daoDuration, "imdb_db_operation_duration_seconds"); count == 0 {
+		t.Errorf("Expected latency observations")
+	}
+}
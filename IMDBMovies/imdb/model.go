@@ -10,18 +10,32 @@
 package main
 
 import (
+    "context"
+    "fmt"
     log "github.com/sirupsen/logrus"
     "gopkg.in/mgo.v2"
     "gopkg.in/mgo.v2/bson"
+    "sync/atomic"
+    "time"
 )
 
 // Database Access Object
 type MoviesDAO struct {
 	Server   string
 	Database string
+	Tenant   string
+	// span context of the operations, see WithContext
+	ctx      context.Context
 }
 
-var db *mgo.Database
+// Database of the current session, replaced by the database monitor on connect while
+// handlers and background workers read it
+var connectedDB atomic.Pointer[mgo.Database]
+
+// db is the database of the current session, nil until connected
+func db() *mgo.Database {
+	return connectedDB.Load()
+}
 
 const (
 	COLLECTION = "movies"
@@ -32,13 +46,75 @@ const (
  * Establish a connection to database
  *
 *******************************************************************************************/
-func (m *MoviesDAO) Connect() {
-	session, err := mgo.Dial(m.Server)
+func (m *MoviesDAO) Connect() error {
+	session, err := mgo.DialWithTimeout(m.Server, 10 * time.Second)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// the session is set up before it is published, the one it replaces is closed
+	if old := connectedDB.Swap(session.DB(m.Database)); old != nil {
+		old.Session.Close()
+	}
+
+	// the readiness check reports a missing index
+	if err := m.EnsureMovieIndexes(); err != nil {
+		databaseLog.WithFields(log.Fields{"Index Error": err}).Error()
+	}
+
+	// API keys are looked up by hash and managed by name
+	db().C(APIKEYS_COLLECTION).EnsureIndex(mgo.Index{Key: []string{"hash"}, Unique: true})
+	db().C(APIKEYS_COLLECTION).EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+
+	// one watchlist entry and personal rating per user and movie
+	EnsureUserIndexes()
+	EnsureReviewIndexes()
+
+	// daily upload usage is only needed until the next day
+	db().C(QUOTAS_COLLECTION).EnsureIndex(mgo.Index{Key: []string{"created"}, ExpireAfter: 48 * time.Hour})
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Close the connection to database
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) Close() {
+	if old := connectedDB.Swap(nil); old != nil {
+		old.Session.Close()
+	}
+}
+
+/******************************************************************************************
+ *
+ * Movies DAO of a tenant, the default tenant has the empty name
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) ForTenant(tenant string) *MoviesDAO {
+	scoped := *m
+	scoped.Tenant = tenant
+	return &scoped
+}
+
+// Collection of the movies of the tenant, each tenant has its own collection
+func (m *MoviesDAO) Collection() string {
+	if len(m.Tenant) == 0 {
+		return COLLECTION
 	}
-	db = session.DB(m.Database)
+	return COLLECTION + "_" + m.Tenant
+}
 
+func (m *MoviesDAO) movies() *mgo.Collection {
+	return db().C(m.Collection())
+}
+
+/******************************************************************************************
+ *
+ * Ensure the unique indexes of the movies collection of the tenant
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) EnsureMovieIndexes() error {
+	done := m.operation("EnsureMovieIndexes", m.Collection())
 	// Add Unique Composite Index for Title and Year
 	index := mgo.Index{
 		Key: []string{"title", "year"},
@@ -47,64 +123,143 @@ func (m *MoviesDAO) Connect() {
 		Background: true,
 		Sparse: true,
 	}
-	db.C(COLLECTION).EnsureIndex(index)
+	if err := m.movies().EnsureIndex(index); err != nil {
+		return done(err)
+	}
+
+	// Add Unique Index for the IMDb ID of imported movies
+	return done(m.movies().EnsureIndex(mgo.Index{
+		Key: []string{"imdb_id"},
+		Unique: true,
+		Background: true,
+		Sparse: true,
+	}))
+}
+
+/******************************************************************************************
+ *
+ * Find list of movies by specific year and genre, ids optionally filters the movie ids
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindByYear(year int, genre string, ids bson.M) ([]MovieGet, error) {
+	return m.findTop(bson.M{"year":year}, genre, ids)
 }
 
 /******************************************************************************************
  *
- * Find list of movies by specific year and genre
+ * Find list of movies by an year range and genre, ids optionally filters the movie ids
  *
 *******************************************************************************************/
-func (m *MoviesDAO) FindByYear(year int, genre string) ([]MovieGet, error) {
+func (m *MoviesDAO) FindByYearRange(yearfrom int, yearto int, genre string, ids bson.M) ([]MovieGet, error) {
+	return m.findTop(bson.M{"year":bson.M{"$gte":yearfrom,"$lte":yearto}}, genre, ids)
+}
+
+// findTop finds the 10 best rated movies of a query
+func (m *MoviesDAO) findTop(query bson.M, genre string, ids bson.M) ([]MovieGet, error) {
 	var movies []MovieGet
-	if len(genre) == 0 {
-		err := db.C(COLLECTION).Find(bson.M{"year":year}).
-								Sort("-rating").
-								Limit(10).
-								All(&movies)
-		return movies, err
+	if len(genre) > 0 {
+		query["genre"] = bson.M{"$eq":genre}
 	}
-
-	err := db.C(COLLECTION).Find(bson.M{"year":year, "genre": bson.M{"$eq":genre}}).
+	if ids != nil {
+		query["_id"] = ids
+	}
+	done := m.operation("FindMovies", m.Collection())
+	err := m.movies().Find(query).
 							Sort("-rating").
 							Limit(10).
 							All(&movies)
-	return movies, err
+	return movies, done(err)
 }
 
 /******************************************************************************************
  *
- * Find list of movies by an year range and genre
+ * Find movies by ids, keyed by id
  *
 *******************************************************************************************/
-func (m *MoviesDAO) FindByYearRange(yearfrom int, yearto int, genre string) ([]MovieGet, error) {
-	var movies []MovieGet
-	if len(genre) == 0 {
-		err := db.C(COLLECTION).Find(bson.M{"year":bson.M{"$gte":yearfrom,"$lte":yearto}}).
-									Sort("-rating").
-									Limit(10).
-									All(&movies)
-		return movies, err
+func (m *MoviesDAO) FindByIDs(ids []bson.ObjectId) (map[bson.ObjectId]Movie, error) {
+	var movies []Movie
+	done := m.operation("FindMoviesByIDs", m.Collection())
+	err := done(m.movies().Find(bson.M{"_id": bson.M{"$in": ids}}).All(&movies))
+	found := make(map[bson.ObjectId]Movie, len(movies))
+	for _, movie := range movies {
+		found[movie.ID] = movie
 	}
+	return found, err
+}
 
-	err := db.C(COLLECTION).Find(bson.M{"year":bson.M{"$gte":yearfrom,"$lte":yearto},
-										"genre":bson.M{"$eq":genre}}).
-							Sort("-rating").
-							Limit(10).
-							All(&movies)
-	return movies, err
 
+/******************************************************************************************
+ *
+ * Insert a movie into database, rows of an upload are announced by upload.completed
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) Insert(movie Movie) error {
+	done := m.operation("InsertMovie", m.Collection())
+	err := m.movies().Insert(&movie)
+	return done(err)
+}
+
+/******************************************************************************************
+ *
+ * Create a single movie with a new id and announce it to the webhook subscriptions
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) Create(movie Movie) (Movie, error) {
+	movie.ID = bson.NewObjectId()
+	if err := m.Insert(movie); err != nil {
+		return movie, err
+	}
+	m.publish(EVENT_MOVIE_CREATED, movie)
+	return movie, nil
 }
 
+// publish a change of the catalog of the tenant to the webhook subscriptions
+func (m *MoviesDAO) publish(event string, data interface{}) {
+	webhooks.Publish(m.Tenant, event, data)
+}
 
 /******************************************************************************************
  *
- * Insert a movie into database
+ * Find a movie by id
  *
 *******************************************************************************************/
-func (m *MoviesDAO) Insert(movie Movie) error {
-	err := db.C(COLLECTION).Insert(&movie)
-	return err
+func (m *MoviesDAO) FindByID(id bson.ObjectId) (Movie, error) {
+	var movie Movie
+	done := m.operation("FindMovie", m.Collection())
+	err := m.movies().FindId(id).One(&movie)
+	return movie, done(err)
+}
+
+/******************************************************************************************
+ *
+ * Update the fields of a movie, imported IMDb ID and enrichment sources are kept
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) Update(movie Movie) error {
+	id := movie.ID
+	movie.ID = ""
+	done := m.operation("UpdateMovie", m.Collection())
+	if err := done(m.movies().UpdateId(id, bson.M{"$set": movie})); err != nil {
+		return err
+	}
+	movie.ID = id
+	m.publish(EVENT_MOVIE_UPDATED, movie)
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Delete a movie by id, returns the deleted movie
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) Delete(id bson.ObjectId) (Movie, error) {
+	var movie Movie
+	done := m.operation("DeleteMovie", m.Collection())
+	if _, err := m.movies().FindId(id).Apply(mgo.Change{Remove: true}, &movie); done(err) != nil {
+		return movie, err
+	}
+	m.publish(EVENT_MOVIE_DELETED, movie)
+	return movie, nil
 }
 
 /******************************************************************************************
@@ -113,7 +268,96 @@ func (m *MoviesDAO) Insert(movie Movie) error {
  *
 *******************************************************************************************/
 func (m *MoviesDAO) Clean() error {
-	log.Warning("Cleaning Database!!!!!!!!!!!!!")
-	_, err := db.C(COLLECTION).RemoveAll(bson.M{})
-	return err
+	ModuleLog(m.ctx, MODULE_DATABASE).Warning("Cleaning Database!!!!!!!!!!!!!")
+	done := m.operation("CleanMovies", m.Collection())
+	if _, err := m.movies().RemoveAll(bson.M{}); done(err) != nil {
+		return err
+	}
+	m.publish(EVENT_MOVIES_CLEANED, nil)
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Insert or update a movie by its IMDb ID. A movie uploaded before without an
+ * IMDb ID is matched by title and year when adopt is set and it is the only
+ * candidate, it is never merged otherwise. Returns true if the movie was created.
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) UpsertByIMDbID(movie Movie, adopt bool) (bool, error) {
+	// only fields present in the dataset are set, others keep their stored value
+	fields := bson.M{"imdb_id": movie.IMDbID, "title": movie.Title, "year": movie.Year}
+	if len(movie.Genre) > 0 {
+		fields["genre"] = movie.Genre
+	}
+	if movie.RuntimeMin > 0 {
+		fields["runtimemin"] = movie.RuntimeMin
+	}
+	if movie.Votes > 0 {
+		fields["rating"] = movie.Rating
+		fields["votes"] = movie.Votes
+	}
+	if len(movie.Director) > 0 {
+		fields["director"] = movie.Director
+	}
+	if len(movie.Actors) > 0 {
+		fields["actors"] = movie.Actors
+	}
+
+	done := m.operation("UpsertMovie", m.Collection())
+	info, err := m.movies().Upsert(bson.M{"imdb_id": movie.IMDbID}, bson.M{"$set": fields})
+	if mgo.IsDup(err) {
+		if !adopt {
+			return false, done(fmt.Errorf("%s (%d) is shared by several IMDb titles", movie.Title, movie.Year))
+		}
+		// title and year are taken, adopt the movie without an IMDb ID if it is the only one
+		candidates := bson.M{"title": movie.Title, "year": movie.Year, "imdb_id": bson.M{"$exists": false}}
+		count, err := m.movies().Find(candidates).Count()
+		if err == nil && count != 1 {
+			err = fmt.Errorf("%s (%d) is taken by another IMDb title", movie.Title, movie.Year)
+		}
+		if err == nil {
+			err = m.movies().Update(candidates, bson.M{"$set": fields})
+		}
+		return false, done(err)
+	}
+	if err := done(err); err != nil {
+		return false, err
+	}
+	return info.UpsertedId != nil, nil
+}
+
+/******************************************************************************************
+ *
+ * Find movies with missing revenue or metascore that were not looked up since before
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindUnenriched(limit int, before time.Time) ([]Movie, error) {
+	var movies []Movie
+	done := m.operation("FindUnenriched", m.Collection())
+	err := m.movies().Find(bson.M{"$and": []bson.M{
+									{"$or": []bson.M{{"revenuemil": 0}, {"metascore": 0}}},
+									{"$or": []bson.M{{"enriched_at": bson.M{"$exists": false}},
+													 {"enriched_at": bson.M{"$lt": before}}}},
+								}}).
+							Limit(limit).
+							All(&movies)
+	return movies, done(err)
+}
+
+/******************************************************************************************
+ *
+ * Set enriched fields of a movie along with the provider of each value
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) UpdateEnrichment(id bson.ObjectId, fields bson.M, sources map[string]string) error {
+	set := bson.M{"enriched_at": time.Now()}
+	for field, value := range fields {
+		set[field] = value
+	}
+	for field, provider := range sources {
+		set["sources." + field] = provider
+	}
+	done := m.operation("UpdateEnrichment", m.Collection())
+	return done(m.movies().UpdateId(id, bson.M{"$set": set}))
 }
@@ -0,0 +1,189 @@
+/******************************************************************************
+ * \file        movies.go
+ *
+ * \brief       GO File that has REST functions for single movie records
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/******************************************************************************************
+ *
+ * Parse the id path parameter, responds with an error if it is invalid
+ *
+*******************************************************************************************/
+func pathID(w http.ResponseWriter, r *http.Request) (bson.ObjectId, bool) {
+	return pathVarID(w, r, "id")
+}
+
+// pathVarID parses an id path parameter by name
+func pathVarID(w http.ResponseWriter, r *http.Request, name string) (bson.ObjectId, bool) {
+	id := mux.Vars(r)[name]
+	if !bson.IsObjectIdHex(id) {
+		respondWithErrorCode(w, ERR_ID_INVALID)
+		return "", false
+	}
+	return bson.ObjectIdHex(id), true
+}
+
+/******************************************************************************************
+ *
+ * Decode and validate a movie from the request body with the upload rules
+ *
+*******************************************************************************************/
+func decodeMovie(w http.ResponseWriter, r *http.Request) (*Movie, bool) {
+	var record MovieJSON
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		respondWithErrorCode(w, ERR_MOVIE_INVALID)
+		return nil, false
+	}
+	if record.Rank == nil || len(record.Title) == 0 || record.Year == nil {
+		respondWithErrorCode(w, ERR_MOVIE_INVALID)
+		return nil, false
+	}
+	movie, err := ValidateMovieJSON(&record)
+	if err != nil {
+		RequestLog(r).WithFields(log.Fields{"Movie Record Validation Failed": record.Title, "err": err}).Info()
+		respondWithErrorCode(w, ERR_MOVIE_INVALID)
+		return nil, false
+	}
+	return movie, true
+}
+
+/******************************************************************************************
+ *
+ * Get a movie by id
+ *
+*******************************************************************************************/
+func GetMovie(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	movie, err := TenantDAO(r).FindByID(id)
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, movie)
+}
+
+/******************************************************************************************
+ *
+ * Create a single movie
+ *
+*******************************************************************************************/
+func CreateMovie(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "CreateMovie"}).Info()
+
+	movie, ok := decodeMovie(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := TenantDAO(r).Create(*movie)
+	if mgo.IsDup(err) {
+		respondWithErrorCode(w, ERR_DUPLICATE)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+/******************************************************************************************
+ *
+ * Update a movie by id
+ *
+*******************************************************************************************/
+func UpdateMovie(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "UpdateMovie"}).Info()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	movie, ok := decodeMovie(w, r)
+	if !ok {
+		return
+	}
+
+	movie.ID = id
+	err := TenantDAO(r).Update(*movie)
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if mgo.IsDup(err) {
+		respondWithErrorCode(w, ERR_DUPLICATE)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, movie)
+}
+
+/******************************************************************************************
+ *
+ * Delete a movie by id
+ *
+*******************************************************************************************/
+func DeleteMovie(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "DeleteMovie"}).Info()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	catalog := TenantDAO(r)
+	_, err := catalog.Delete(id)
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+
+	if err := catalog.DeleteUserData(id); err != nil {
+		RequestLog(r).WithFields(log.Fields{"Movie": id.Hex(), "User Data Error": err}).Warning()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************************
+ *
+ * Delete all movies
+ *
+*******************************************************************************************/
+func CleanMovies(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "CleanMovies"}).Warning()
+
+	catalog := TenantDAO(r)
+	if err := catalog.Clean(); err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	if err := catalog.DeleteUserData(); err != nil {
+		RequestLog(r).WithFields(log.Fields{"User Data Error": err}).Warning()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,598 @@
+/******************************************************************************
+ * \file        openapi.go
+ *
+ * \brief       GO File that generates the OpenAPI document from the route table
+ *              and serves it with the Swagger UI
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the OpenAPI specification the document follows
+const OPENAPI_VERSION = "3.0.3"
+
+// Security schemes of the credentials accepted by the Auth Middleware
+const (
+	SECURITY_API_KEY = "ApiKey"
+	SECURITY_BEARER  = "Bearer"
+)
+
+// Content types of the OpenAPI document and the Swagger UI
+const (
+	CONTENT_TYPE_JSON = "application/json"
+	CONTENT_TYPE_YAML = "application/yaml"
+	CONTENT_TYPE_HTML = "text/html; charset=utf-8"
+)
+
+// OpenAPI struct for the OpenAPI 3 document of the service
+type OpenAPI struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       OpenAPIInfo           `json:"info"`
+	Tags       []OpenAPITag          `json:"tags,omitempty"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components OpenAPIComponents     `json:"components"`
+	Security   []SecurityRequirement `json:"security"`
+
+	operations map[string]*Operation
+}
+
+// OpenAPIInfo struct for the title and version of the API
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPITag struct for a group of operations
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIComponents struct for the schemas and security schemes operations refer to
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme struct for a kind of credential
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// SecurityRequirement names the security schemes an operation accepts
+type SecurityRequirement map[string][]string
+
+// PathItem has the operations of a path by lower case method
+type PathItem map[string]*Operation
+
+// Operation struct for a method on a path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []SecurityRequirement `json:"security"`
+	Role        string                `json:"x-role"`
+	Exclusive   [][]string            `json:"x-mutually-exclusive,omitempty"`
+	Requires    map[string][]string   `json:"x-dependent-required,omitempty"`
+
+	// error codes of invalid requests, ERR_REQUEST_INVALID when nil
+	BodyError      *ErrorCode `json:"-"`
+	ExclusiveError *ErrorCode `json:"-"`
+	RequiresError  *ErrorCode `json:"-"`
+	StreamBody     bool       `json:"-"`
+}
+
+// Parameter struct for a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+
+	// error code of invalid values, ERR_REQUEST_INVALID when nil
+	Error *ErrorCode `json:"-"`
+}
+
+// RequestBody struct for the bodies an operation accepts by content type
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response struct for a response status of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType struct for the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Schema struct for a JSON schema, the OpenAPI 3.0 subset the service needs
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Pattern of the hex ids of movies, reviews and webhooks
+const OBJECT_ID_PATTERN = "^[0-9a-fA-F]{24}$"
+
+var (
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+/******************************************************************************************
+ *
+ * Generate the OpenAPI document of the routes from their documentation in apidocs.go.
+ * Tenant routes are documented under /imdb/tenants/{tenant} as well. Returns an error
+ * naming the routes without documentation.
+ *
+*******************************************************************************************/
+func NewOpenAPI(routes []Route) (*OpenAPI, error) {
+	doc := &OpenAPI{
+		OpenAPI: OPENAPI_VERSION,
+		Info: OpenAPIInfo{
+			Title:       "IMDB Movies REST Service",
+			Description: "REST API server for an IMDB Movie Collection, generated from the routes of the service",
+			Version:     Version(),
+		},
+		Tags:       apiTags,
+		Paths:      make(map[string]PathItem),
+		operations: make(map[string]*Operation),
+		Components: OpenAPIComponents{
+			Schemas: map[string]*Schema{"Error": errorSchema()},
+			SecuritySchemes: map[string]SecurityScheme{
+				SECURITY_API_KEY: {Type: "apiKey", In: "header", Name: HEADER_API_KEY,
+					Description: "API key with the reader, uploader or admin role, also accepted as 'Authorization: ApiKey <key>'"},
+				SECURITY_BEARER: {Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+					Description: "OIDC token, the role is mapped from the configured role claim"},
+			},
+		},
+		Security: []SecurityRequirement{{SECURITY_API_KEY: {}}, {SECURITY_BEARER: {}}},
+	}
+
+	var undocumented []string
+	for _, route := range routes {
+		routeDoc, ok := routeDocs[route.Name]
+		if !ok {
+			undocumented = append(undocumented, route.Name)
+			continue
+		}
+		doc.addOperation(route.Path, route, routeDoc, false)
+		if route.Tenant {
+			doc.addOperation(TENANT_PATH_PREFIX+strings.TrimPrefix(route.Path, "/imdb"), route, routeDoc, true)
+		}
+	}
+	if len(undocumented) > 0 {
+		return doc, fmt.Errorf("openapi: routes without documentation: %s", strings.Join(undocumented, ", "))
+	}
+	return doc, nil
+}
+
+// addOperation documents a route on a path, tenantPath when the path names the tenant
+func (doc *OpenAPI) addOperation(path string, route Route, routeDoc RouteDoc, tenantPath bool) {
+	op := &Operation{
+		OperationID: route.Name,
+		Tags:        []string{routeDoc.Tag},
+		Summary:     routeDoc.Summary,
+		Description: routeDoc.Description,
+		Responses:   make(map[string]*Response),
+		Security:    []SecurityRequirement{},
+		Role:        route.Role.String(),
+		Exclusive:   routeDoc.Exclusive,
+		Requires:    routeDoc.Requires,
+
+		BodyError:      routeDoc.BodyError,
+		ExclusiveError: routeDoc.ExclusiveError,
+		RequiresError:  routeDoc.RequiresError,
+		StreamBody:     routeDoc.StreamBody,
+	}
+	if route.Role != ROLE_PUBLIC {
+		op.Security = doc.Security
+	}
+
+	if tenantPath {
+		op.OperationID += TENANT_ROUTE_SUFFIX
+		op.Parameters = append(op.Parameters, Parameter{Name: "tenant", In: "path", Required: true,
+			Description: "Tenant whose catalog is used", Schema: &Schema{Type: "string", Pattern: tenantNamePattern.String()}})
+	} else if route.Tenant {
+		op.Parameters = append(op.Parameters, Parameter{Name: HEADER_TENANT, In: "header", Error: errorCode(ERR_TENANT_NOT_FOUND),
+			Description: "Tenant whose catalog is used, the default catalog without it", Schema: &Schema{Type: "string", Pattern: tenantNamePattern.String()}})
+	}
+	op.Parameters = append(op.Parameters, routeDoc.Params...)
+
+	if len(routeDoc.Body) > 0 {
+		op.RequestBody = &RequestBody{Description: routeDoc.BodyDescription, Required: !routeDoc.BodyOptional, Content: make(map[string]MediaType)}
+		for contentType, body := range routeDoc.Body {
+			op.RequestBody.Content[contentType] = MediaType{Schema: doc.bodySchema(body)}
+		}
+	}
+
+	status := routeDoc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if routeDoc.Response != nil {
+		contentType := routeDoc.ContentType
+		if len(contentType) == 0 {
+			contentType = CONTENT_TYPE_JSON
+		}
+		success.Content = map[string]MediaType{contentType: {Schema: doc.bodySchema(routeDoc.Response)}}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	doc.addErrors(op, route, routeDoc)
+	for status, body := range routeDoc.Responses {
+		response := &Response{Description: http.StatusText(status)}
+		if body != nil {
+			response.Content = map[string]MediaType{CONTENT_TYPE_JSON: {Schema: doc.bodySchema(body)}}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+
+	if !tenantPath {
+		doc.operations[route.Name] = op
+	}
+	item, ok := doc.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		doc.Paths[path] = item
+	}
+	item[strings.ToLower(route.Method)] = op
+}
+
+// addErrors documents the errors of the handler and of the middlewares the route passes
+func (doc *OpenAPI) addErrors(op *Operation, route Route, routeDoc RouteDoc) {
+	codes := append([]ErrorCode{}, routeDoc.Errors...)
+	// the errors of requests the Validation Middleware rejects
+	if len(routeDoc.Params) > 0 || len(routeDoc.Body) > 0 {
+		codes = append(codes, ERR_REQUEST_INVALID)
+	}
+	for _, param := range routeDoc.Params {
+		if param.Error != nil {
+			codes = append(codes, *param.Error)
+		}
+	}
+	for _, code := range []*ErrorCode{routeDoc.BodyError, routeDoc.ExclusiveError, routeDoc.RequiresError} {
+		if code != nil {
+			codes = append(codes, *code)
+		}
+	}
+	if route.Role != ROLE_PUBLIC {
+		codes = append(codes, ERR_UNAUTHORIZED, ERR_FORBIDDEN, ERR_SERVICE_UNAVAILABLE)
+	}
+	if route.Tenant {
+		codes = append(codes, ERR_TENANT_NOT_FOUND)
+	}
+	codes = append(codes, ERR_TOO_MANY_REQUESTS)
+
+	messages := make(map[int][]string)
+	for _, code := range codes {
+		status := HTTPCode(code)
+		msg := ErrorMsg(code)
+		if code == ERR_NO_CONTENT {
+			msg = "No movies match the query"
+		}
+		duplicate := false
+		for _, known := range messages[status] {
+			duplicate = duplicate || known == msg
+		}
+		if !duplicate {
+			messages[status] = append(messages[status], msg)
+		}
+	}
+	for status, msgs := range messages {
+		response := &Response{Description: strings.Join(msgs, ". ")}
+		if status != http.StatusNoContent {
+			response.Content = map[string]MediaType{CONTENT_TYPE_JSON: {Schema: &Schema{Ref: schemaRef("Error")}}}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+}
+
+// bodySchema of a body given as a Schema or as a value of its Go type
+func (doc *OpenAPI) bodySchema(body interface{}) *Schema {
+	if schema, ok := body.(*Schema); ok {
+		return schema
+	}
+	return doc.typeSchema(reflect.TypeOf(body))
+}
+
+func schemaRef(name string) string {
+	return "#/components/schemas/" + name
+}
+
+/******************************************************************************************
+ *
+ * Schema of a Go type as encoding/json writes it. Named structs become components the
+ * schema refers to, nil slices, maps and pointers are written as null.
+ *
+*******************************************************************************************/
+func (doc *OpenAPI) typeSchema(t reflect.Type) *Schema {
+	switch {
+	case t == nil:
+		return &Schema{}
+	case t == objectIDType:
+		return &Schema{Type: "string", Pattern: OBJECT_ID_PATTERN}
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		schema := doc.typeSchema(t.Elem())
+		if len(schema.Ref) == 0 {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: doc.typeSchema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.typeSchema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return doc.structSchema(t)
+		}
+		if _, ok := doc.Components.Schemas[t.Name()]; !ok {
+			// registered before its fields so recursive types end
+			doc.Components.Schemas[t.Name()] = &Schema{}
+			*doc.Components.Schemas[t.Name()] = *doc.structSchema(t)
+		}
+		return &Schema{Ref: schemaRef(t.Name())}
+	}
+	// interfaces hold any value
+	return &Schema{}
+}
+
+// structSchema has the exported fields, fields of embedded structs are promoted
+func (doc *OpenAPI) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct {
+			embedded := doc.structSchema(field.Type)
+			for key, value := range embedded.Properties {
+				if _, ok := schema.Properties[key]; !ok {
+					schema.Properties[key] = value
+				}
+			}
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		schema.Properties[name] = doc.typeSchema(field.Type)
+	}
+	return schema
+}
+
+// errorSchema of the error bodies of respondWithErrorCode and respondWithErrorDetail
+func errorSchema() *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"error":      {Type: "string", Description: "Error message"},
+			"code":       {Type: "string", Description: "HTTP status code"},
+			"detail":     {Type: "string", Description: "Cause of the error"},
+			"request_id": {Type: "string", Description: "ID of the request in the logs"},
+			"errors": {Type: "array", Description: "Parameters and body fields that do not match the API description",
+				Items: &Schema{Type: "object", Properties: map[string]*Schema{
+					"in":     {Type: "string", Enum: []string{"path", "query", "header", "body"}},
+					"name":   {Type: "string"},
+					"reason": {Type: "string"},
+				}}},
+			"results": {Ref: schemaRef("UploadResults"), Description: "Records of an upload read before it was aborted"},
+		},
+		Required: []string{"error", "code"},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  *OpenAPI
+	openAPIJSON []byte
+	openAPIYAML []byte
+	openAPIErr  error
+)
+
+/******************************************************************************************
+ *
+ * OpenAPI document of the REST Endpoints as JSON and YAML, generated on first use
+ *
+*******************************************************************************************/
+func OpenAPIDocument() (jsonDoc []byte, yamlDoc []byte, err error) {
+	openAPIOnce.Do(func() {
+		if openAPIDoc, openAPIErr = NewOpenAPI(Routes()); openAPIErr != nil {
+			return
+		}
+		if openAPIJSON, openAPIErr = json.MarshalIndent(openAPIDoc, "", "  "); openAPIErr != nil {
+			return
+		}
+		// JSON is YAML, decoding into a node keeps the order of the keys
+		var node yaml.Node
+		if openAPIErr = yaml.Unmarshal(openAPIJSON, &node); openAPIErr != nil {
+			return
+		}
+		blockStyle(&node)
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if openAPIErr = encoder.Encode(&node); openAPIErr == nil {
+			openAPIYAML = buf.Bytes()
+		}
+	})
+	return openAPIJSON, openAPIYAML, openAPIErr
+}
+
+/******************************************************************************************
+ *
+ * Operation of a route by name, nil if the route is unknown or the document could not
+ * be generated
+ *
+*******************************************************************************************/
+func RouteOperation(name string) *Operation {
+	if _, _, err := OpenAPIDocument(); err != nil {
+		return nil
+	}
+	return openAPIDoc.operations[name]
+}
+
+// Schema a reference points to, the schema itself if it is not a reference
+func (doc *OpenAPI) resolve(schema *Schema) *Schema {
+	for schema != nil && len(schema.Ref) > 0 {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, schemaRef(""))]
+	}
+	return schema
+}
+
+// blockStyle writes the nodes decoded from JSON in YAML block style, quoting only
+// the strings that need it
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+func respondWithOpenAPI(w http.ResponseWriter, r *http.Request, asYAML bool) {
+	jsonDoc, yamlDoc, err := OpenAPIDocument()
+	if err != nil {
+		RequestLog(r).WithField("OpenAPI Error", err).Error()
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	if asYAML {
+		w.Header().Set("Content-Type", CONTENT_TYPE_YAML)
+		w.Write(yamlDoc)
+		return
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE_JSON)
+	w.Write(jsonDoc)
+}
+
+/******************************************************************************************
+ *
+ * Get the OpenAPI document as JSON
+ *
+*******************************************************************************************/
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	respondWithOpenAPI(w, r, false)
+}
+
+/******************************************************************************************
+ *
+ * Get the OpenAPI document as YAML
+ *
+*******************************************************************************************/
+func GetOpenAPIYAML(w http.ResponseWriter, r *http.Request) {
+	respondWithOpenAPI(w, r, true)
+}
+
+// Swagger UI page, the assets are served from the embedded Swagger UI distribution
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8">
+    <title>IMDB Movies REST Service</title>
+    <link rel="stylesheet" type="text/css" href="/imdb/docs/swagger-ui.css" />
+    <link rel="stylesheet" type="text/css" href="/imdb/docs/index.css" />
+    <link rel="icon" type="image/png" href="/imdb/docs/favicon-32x32.png" sizes="32x32" />
+  </head>
+  <body>
+    <div id="swagger-ui"></div>
+    <script src="/imdb/docs/swagger-ui-bundle.js" charset="UTF-8"></script>
+    <script src="/imdb/docs/swagger-ui-standalone-preset.js" charset="UTF-8"></script>
+    <script>
+      window.onload = function() {
+        window.ui = SwaggerUIBundle({
+          url: "/imdb/openapi.json",
+          dom_id: "#swagger-ui",
+          deepLinking: true,
+          presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+          layout: "StandaloneLayout"
+        });
+      };
+    </script>
+  </body>
+</html>
+`
+
+/******************************************************************************************
+ *
+ * Get the interactive API documentation, the Swagger UI on the OpenAPI document
+ *
+*******************************************************************************************/
+func GetDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_TYPE_HTML)
+	w.Write([]byte(docsPage))
+}
+
+var docsAssets = http.StripPrefix("/imdb/docs/", http.FileServer(http.FS(swaggerFiles.FS)))
+
+/******************************************************************************************
+ *
+ * Get a script, style sheet or image of the Swagger UI
+ *
+*******************************************************************************************/
+func GetDocsAsset(w http.ResponseWriter, r *http.Request) {
+	docsAssets.ServeHTTP(w, r)
+}
@@ -0,0 +1,167 @@
+/******************************************************************************
+ * \file        openapi_test.go
+ *
+ * \brief       GO File that tests the generated OpenAPI document and the API docs
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func serveDocs(path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	return response
+}
+
+func TestOpenAPIEveryRouteDocumented(t *testing.T) {
+	doc, err := NewOpenAPI(Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := make(map[string]bool)
+	vars := regexp.MustCompile(`\{([a-z]+)\}`)
+	for _, route := range Routes() {
+		routes[route.Name] = true
+		paths := []string{route.Path}
+		if route.Tenant {
+			paths = append(paths, TENANT_PATH_PREFIX+strings.TrimPrefix(route.Path, "/imdb"))
+		}
+		for _, path := range paths {
+			op := doc.Paths[path][strings.ToLower(route.Method)]
+			if op == nil {
+				t.Errorf("Expected %s %s to be documented", route.Method, path)
+				continue
+			}
+			// every path variable is a documented path parameter
+			documented := make(map[string]bool)
+			for _, param := range op.Parameters {
+				if param.In == "path" {
+					documented[param.Name] = true
+				}
+			}
+			for _, match := range vars.FindAllStringSubmatch(path, -1) {
+				if !documented[match[1]] {
+					t.Errorf("Expected path parameter %s of %s to be documented", match[1], path)
+				}
+				delete(documented, match[1])
+			}
+			if len(documented) > 0 {
+				t.Errorf("Expected no path parameters outside of %s, got %v", path, documented)
+			}
+			if len(op.Summary) == 0 || len(op.Tags) == 0 {
+				t.Errorf("Expected a summary and tag for %s", op.OperationID)
+			}
+		}
+	}
+	for name := range routeDocs {
+		if !routes[name] {
+			t.Errorf("Expected a route for the documentation of %s", name)
+		}
+	}
+}
+
+func TestOpenAPIUndocumentedRoute(t *testing.T) {
+	routes := append(Routes(), Route{"GetSecret", "GET", "/imdb/secret", ROLE_ADMIN, false, GetVersion})
+	if _, err := NewOpenAPI(routes); err == nil || !strings.Contains(err.Error(), "GetSecret") {
+		t.Errorf("Expected an error naming the undocumented route, got %v", err)
+	}
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	doc, err := NewOpenAPI(Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getMovies := doc.Paths["/imdb/movies"]["get"]
+	for _, status := range []string{"200", "204", "400", "401", "403", "429", "503"} {
+		if getMovies.Responses[status] == nil {
+			t.Errorf("Expected GetMovies to document %s, got %v", status, getMovies.Responses)
+		}
+	}
+	if getMovies.Responses["404"] != nil && !strings.Contains(getMovies.Responses["404"].Description, "Tenant") {
+		t.Errorf("Expected no movies to be answered with 204, not 404")
+	}
+	if getMovies.Role != "reader" || len(getMovies.Security) != 2 {
+		t.Errorf("Expected GetMovies to need a reader credential, got %s %v", getMovies.Role, getMovies.Security)
+	}
+	if version := doc.Paths["/imdb/version"]["get"]; version.Security == nil || len(version.Security) != 0 {
+		t.Errorf("Expected GetVersion to be public, got %v", version.Security)
+	}
+	if doc.Paths["/imdb/tenants/{tenant}/movies"]["get"].OperationID != "GetMoviesForTenant" {
+		t.Errorf("Expected the tenant route to be documented")
+	}
+
+	// the schemas are those of the Go types the handlers encode
+	movie := doc.Components.Schemas["Movie"]
+	if movie == nil || movie.Properties["id"].Pattern != OBJECT_ID_PATTERN || movie.Properties["genre"].Type != "array" ||
+		movie.Properties["EnrichedAt"] != nil || movie.Properties["enriched_at"] != nil {
+		t.Errorf("Expected the Movie schema, got %+v", movie)
+	}
+	files := doc.Components.Schemas["FileUploadResults"]
+	if files == nil || files.Properties["RecordsRead"] == nil || files.Properties["FileName"] == nil || files.Properties["progress"] != nil {
+		t.Errorf("Expected the embedded upload results, got %+v", files)
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+
+	response := serveDocs("/imdb/openapi.json")
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var fromJSON map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if fromJSON["openapi"] != OPENAPI_VERSION || fromJSON["host"] != nil {
+		t.Errorf("Expected an OpenAPI 3 document without a host, got %v %v", fromJSON["openapi"], fromJSON["host"])
+	}
+
+	for _, path := range []string{"/imdb/openapi.yaml", "/imdb/endpoints"} {
+		response = serveDocs(path)
+		checkResponseCode(t, http.StatusOK, response.Code)
+		if response.Header().Get("Content-Type") != CONTENT_TYPE_YAML || !strings.HasPrefix(response.Body.String(), "openapi: ") {
+			t.Errorf("Expected the YAML document on %s, got %s", path, response.Header().Get("Content-Type"))
+		}
+		var fromYAML map[string]interface{}
+		if err := yaml.Unmarshal(response.Body.Bytes(), &fromYAML); err != nil {
+			t.Fatal(err)
+		}
+		if len(fromYAML["paths"].(map[string]interface{})) != len(fromJSON["paths"].(map[string]interface{})) {
+			t.Errorf("Expected the YAML and JSON documents to have the same paths")
+		}
+	}
+}
+
+func TestDocsUI(t *testing.T) {
+	withAccessLog(t)
+	withAPIKeys(t)
+
+	response := serveDocs("/imdb/docs")
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if !strings.Contains(response.Body.String(), `url: "/imdb/openapi.json"`) {
+		t.Errorf("Expected the Swagger UI on the OpenAPI document")
+	}
+
+	for _, asset := range []string{"swagger-ui-bundle.js", "swagger-ui-standalone-preset.js", "swagger-ui.css"} {
+		response = serveDocs("/imdb/docs/" + asset)
+		checkResponseCode(t, http.StatusOK, response.Code)
+	}
+	checkResponseCode(t, http.StatusNotFound, serveDocs("/imdb/docs/missing.js").Code)
+}
@@ -0,0 +1,429 @@
+/******************************************************************************
+ * \file        progress.go
+ *
+ * \brief       GO File that streams upload progress as Server-Sent Events
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ProgressOptions struct for upload progress settings in config file
+type ProgressOptions struct {
+	Interval     string `toml:"interval"`
+	Retention    string `toml:"retention"`
+	LatestErrors int    `toml:"latesterrors"`
+}
+
+// UploadProgressEvent struct for a progress or summary event of an upload
+type UploadProgressEvent struct {
+	UploadID       string    `json:"UploadID"`
+	Tenant         string    `json:"Tenant,omitempty"`
+	Started        time.Time `json:"Started"`
+	RecordsRead    int       `json:"RecordsRead"`
+	RecordsCreated int       `json:"RecordsCreated"`
+	RecordsErrored int       `json:"RecordsErrored"`
+	LatestErrors   []string  `json:"LatestErrors,omitempty"`
+	Done           bool      `json:"Done"`
+	Error          string    `json:"Error,omitempty"`
+}
+
+// SSE event names
+const (
+	SSE_EVENT_PROGRESS = "progress"
+	SSE_EVENT_SUMMARY  = "summary"
+)
+
+// Header and query parameter naming the upload to follow
+const (
+	HEADER_UPLOAD_ID = "X-Upload-ID"
+	PARAM_UPLOAD_ID  = "upload_id"
+)
+
+// errUploadIDInUse is returned when an upload with the same id is still running
+var errUploadIDInUse = errors.New("upload id is in use")
+
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Upload Tracker of running and recently finished uploads
+var uploads = NewUploadTracker(ProgressOptions{})
+
+/******************************************************************************************
+ *
+ * Upload Tracker - keeps the progress of each upload by id
+ *
+*******************************************************************************************/
+type UploadTracker struct {
+	sync.Mutex
+	Interval     time.Duration
+	Retention    time.Duration
+	LatestErrors int
+	progress     map[string]*UploadProgress
+}
+
+/******************************************************************************************
+ *
+ * UploadProgress - counts of one upload and the clients watching it
+ *
+*******************************************************************************************/
+type UploadProgress struct {
+	sync.Mutex
+	tracker  *UploadTracker
+	event    UploadProgressEvent
+	changed  bool
+	watchers map[chan UploadProgressEvent]bool
+	stop     chan struct{}
+}
+
+/******************************************************************************************
+ *
+ * Create an Upload Tracker from config
+ *
+*******************************************************************************************/
+func NewUploadTracker(options ProgressOptions) *UploadTracker {
+	tracker := &UploadTracker{
+		Interval:     500 * time.Millisecond,
+		Retention:    5 * time.Minute,
+		LatestErrors: options.LatestErrors,
+		progress:     make(map[string]*UploadProgress),
+	}
+	if interval, err := time.ParseDuration(options.Interval); err == nil && interval > 0 {
+		tracker.Interval = interval
+	}
+	if retention, err := time.ParseDuration(options.Retention); err == nil && retention >= 0 {
+		tracker.Retention = retention
+	}
+	if tracker.LatestErrors <= 0 {
+		tracker.LatestErrors = 5
+	}
+	return tracker
+}
+
+/******************************************************************************************
+ *
+ * Start tracking an upload of a tenant, an empty id generates one
+ *
+*******************************************************************************************/
+func (t *UploadTracker) Start(tenant string, id string) (*UploadProgress, error) {
+	if len(id) == 0 {
+		id = bson.NewObjectId().Hex()
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if existing, ok := t.progress[id]; ok && !existing.Snapshot().Done {
+		return nil, errUploadIDInUse
+	}
+
+	p := &UploadProgress{
+		tracker:  t,
+		event:    UploadProgressEvent{UploadID: id, Tenant: tenant, Started: time.Now().UTC()},
+		watchers: make(map[chan UploadProgressEvent]bool),
+		stop:     make(chan struct{}),
+	}
+	t.progress[id] = p
+	go p.broadcastLoop()
+	return p, nil
+}
+
+/******************************************************************************************
+ *
+ * Find an upload of a tenant by id
+ *
+*******************************************************************************************/
+func (t *UploadTracker) Get(tenant string, id string) (*UploadProgress, bool) {
+	t.Lock()
+	defer t.Unlock()
+	p, ok := t.progress[id]
+	if !ok || p.event.Tenant != tenant {
+		return nil, false
+	}
+	return p, true
+}
+
+/******************************************************************************************
+ *
+ * List running and recently finished uploads of a tenant, newest first
+ *
+*******************************************************************************************/
+func (t *UploadTracker) List(tenant string) []UploadProgressEvent {
+	t.Lock()
+	list := make([]UploadProgressEvent, 0, len(t.progress))
+	for _, p := range t.progress {
+		if p.event.Tenant == tenant {
+			list = append(list, p.Snapshot())
+		}
+	}
+	t.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.After(list[j].Started) })
+	return list
+}
+
+func (t *UploadTracker) remove(id string, p *UploadProgress) {
+	t.Lock()
+	defer t.Unlock()
+	if t.progress[id] == p {
+		delete(t.progress, id)
+	}
+}
+
+// ID of the upload
+func (p *UploadProgress) ID() string {
+	return p.event.UploadID
+}
+
+/******************************************************************************************
+ *
+ * Count a processed record, err is the reason the record failed
+ *
+*******************************************************************************************/
+func (p *UploadProgress) Record(err error) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	p.event.RecordsRead += 1
+	if err == nil {
+		p.event.RecordsCreated += 1
+	} else {
+		p.event.RecordsErrored += 1
+		errs := append(p.event.LatestErrors, fmt.Sprintf("record %d: %v", p.event.RecordsRead, err))
+		if len(errs) > p.tracker.LatestErrors {
+			errs = errs[len(errs)-p.tracker.LatestErrors:]
+		}
+		p.event.LatestErrors = errs
+	}
+	p.changed = true
+}
+
+/******************************************************************************************
+ *
+ * Snapshot of the current progress
+ *
+*******************************************************************************************/
+func (p *UploadProgress) Snapshot() UploadProgressEvent {
+	p.Lock()
+	defer p.Unlock()
+	return p.snapshot()
+}
+
+func (p *UploadProgress) snapshot() UploadProgressEvent {
+	event := p.event
+	event.LatestErrors = append([]string(nil), p.event.LatestErrors...)
+	return event
+}
+
+/******************************************************************************************
+ *
+ * Finish the upload with its final results, or the error that aborted it. Watchers
+ * get the summary event and their streams end. Calling Finish again has no effect.
+ *
+*******************************************************************************************/
+func (p *UploadProgress) Finish(results *UploadResults, err error) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	if p.event.Done {
+		p.Unlock()
+		return
+	}
+
+	p.event.Done = true
+	if results != nil {
+		p.event.RecordsRead = results.RecordsRead
+		p.event.RecordsCreated = results.RecordsCreated
+		p.event.RecordsErrored = results.RecordsErrored
+	}
+	if err != nil {
+		p.event.Error = err.Error()
+	}
+
+	summary := p.snapshot()
+	for ch := range p.watchers {
+		send(ch, summary)
+		close(ch)
+	}
+	p.watchers = make(map[chan UploadProgressEvent]bool)
+	close(p.stop)
+	p.Unlock()
+
+	// keep the summary for clients that connect late
+	time.AfterFunc(p.tracker.Retention, func() { p.tracker.remove(p.ID(), p) })
+}
+
+/******************************************************************************************
+ *
+ * Subscribe to progress events. The current progress is sent right away, the
+ * channel is closed after the summary event.
+ *
+*******************************************************************************************/
+func (p *UploadProgress) Subscribe() chan UploadProgressEvent {
+	ch := make(chan UploadProgressEvent, 1)
+
+	p.Lock()
+	defer p.Unlock()
+	ch <- p.snapshot()
+	if p.event.Done {
+		close(ch)
+	} else {
+		p.watchers[ch] = true
+	}
+	return ch
+}
+
+/******************************************************************************************
+ *
+ * Unsubscribe a watcher that went away
+ *
+*******************************************************************************************/
+func (p *UploadProgress) Unsubscribe(ch chan UploadProgressEvent) {
+	p.Lock()
+	defer p.Unlock()
+	if p.watchers[ch] {
+		delete(p.watchers, ch)
+		close(ch)
+	}
+}
+
+// broadcastLoop sends the progress to watchers periodically while it changes
+func (p *UploadProgress) broadcastLoop() {
+	ticker := time.NewTicker(p.tracker.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Lock()
+			if p.changed && !p.event.Done {
+				event := p.snapshot()
+				for ch := range p.watchers {
+					send(ch, event)
+				}
+				p.changed = false
+			}
+			p.Unlock()
+		}
+	}
+}
+
+// send replaces an unread event so slow watchers always get the latest progress
+func send(ch chan UploadProgressEvent, event UploadProgressEvent) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- event
+}
+
+/******************************************************************************************
+ *
+ * Start tracking the progress of an upload request. The id is taken from the
+ * X-Upload-ID header or upload_id query parameter, and returned in X-Upload-ID.
+ *
+*******************************************************************************************/
+func startUploadProgress(w http.ResponseWriter, r *http.Request) (*UploadProgress, bool) {
+	id := r.Header.Get(HEADER_UPLOAD_ID)
+	if len(id) == 0 {
+		id = r.URL.Query().Get(PARAM_UPLOAD_ID)
+	}
+	if len(id) > 0 && !uploadIDPattern.MatchString(id) {
+		respondWithErrorCode(w, ERR_ID_INVALID)
+		return nil, false
+	}
+
+	progress, err := uploads.Start(RequestTenant(r).Name, id)
+	if err != nil {
+		respondWithErrorCode(w, ERR_UPLOAD_ID_IN_USE)
+		return nil, false
+	}
+	w.Header().Set(HEADER_UPLOAD_ID, progress.ID())
+	return progress, true
+}
+
+/******************************************************************************************
+ *
+ * List running and recently finished uploads
+ *
+*******************************************************************************************/
+func GetUploads(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, uploads.List(RequestTenant(r).Name))
+}
+
+/******************************************************************************************
+ *
+ * Stream the progress of an upload as Server-Sent Events
+ *
+*******************************************************************************************/
+func GetUploadEvents(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	progress, ok := uploads.Get(RequestTenant(r).Name, id)
+	if !ok {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "GetUploadEvents", "UploadID": id}).Info()
+
+	// the stream lasts as long as the upload, longer than the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := progress.Subscribe()
+	defer progress.Unsubscribe(events)
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			name := SSE_EVENT_PROGRESS
+			if event.Done {
+				name = SSE_EVENT_SUMMARY
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/******************************************************************************
+ * \file        progress_test.go
+ *
+ * \brief       GO File that has tests for upload progress events
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+		"bufio"
+		"errors"
+		"net/http"
+		"net/http/httptest"
+		"strings"
+		"time"
+)
+
+/******************************************************************************************
+ *
+ * Test that several watchers get periodic progress and the final summary
+ *
+*******************************************************************************************/
+func TestUploadProgressWatchers(t *testing.T) {
+	tracker := NewUploadTracker(ProgressOptions{Interval: "5ms", LatestErrors: 2})
+	progress, err := tracker.Start("", "upload-1")
+	if err != nil {
+		t.Fatalf("TestUploadProgressWatchers Failed %v", err)
+	}
+
+	// a running upload id can not be reused
+	if _, err := tracker.Start("", "upload-1"); err != errUploadIDInUse {
+		t.Errorf("TestUploadProgressWatchers Failed for id in use")
+	}
+
+	first := progress.Subscribe()
+	second := progress.Subscribe()
+	<-first
+	<-second
+
+	progress.Record(nil)
+	for i := 0; i < 3; i++ {
+		progress.Record(errors.New("bad year"))
+	}
+
+	select {
+	case event := <-first:
+		if event.RecordsRead != 4 || event.RecordsErrored != 3 || len(event.LatestErrors) != 2 || event.Done {
+			t.Errorf("TestUploadProgressWatchers Failed %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestUploadProgressWatchers Failed, no progress event")
+	}
+
+	progress.Finish(&UploadResults{RecordsRead: 4, RecordsCreated: 1, RecordsErrored: 3}, nil)
+
+	for _, ch := range []chan UploadProgressEvent{first, second} {
+		var last UploadProgressEvent
+		for event := range ch {
+			last = event
+		}
+		if !last.Done || last.RecordsCreated != 1 {
+			t.Errorf("TestUploadProgressWatchers Failed, no summary %+v", last)
+		}
+	}
+
+	// late watchers get the summary
+	late := progress.Subscribe()
+	if event := <-late; !event.Done {
+		t.Errorf("TestUploadProgressWatchers Failed for late watcher")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test the Server-Sent Events stream of an upload
+ *
+*******************************************************************************************/
+func TestGetUploadEvents(t *testing.T) {
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	progress, _ := uploads.Start("", "sse-test")
+
+	resp, err := http.Get(server.URL + "/imdb/uploads/sse-test/events")
+	if err != nil {
+		t.Fatalf("TestGetUploadEvents Failed %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("TestGetUploadEvents Failed, content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	progress.Record(nil)
+	progress.Finish(&UploadResults{RecordsRead: 1, RecordsCreated: 1}, nil)
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "event: ") {
+			events = append(events, strings.TrimPrefix(scanner.Text(), "event: "))
+		}
+	}
+
+	if len(events) < 2 || events[0] != SSE_EVENT_PROGRESS || events[len(events)-1] != SSE_EVENT_SUMMARY {
+		t.Errorf("TestGetUploadEvents Failed %v", events)
+	}
+
+	resp, _ = http.Get(server.URL + "/imdb/uploads/unknown/events")
+	if resp.StatusCode != 404 {
+		t.Errorf("TestGetUploadEvents Failed for unknown upload")
+	}
+}
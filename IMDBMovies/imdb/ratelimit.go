@@ -0,0 +1,363 @@
+/******************************************************************************
+ * \file        ratelimit.go
+ *
+ * \brief       GO File that rate limits clients and enforces daily upload quotas
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RateLimitOptions struct for rate limit settings in config file. Rate and Burst
+// apply to every route, Routes overrides them by route name.
+type RateLimitOptions struct {
+	Enabled     bool                  `toml:"enabled"`
+	Rate        float64               `toml:"rate"`
+	Burst       int                   `toml:"burst"`
+	IdleTimeout string                `toml:"idletimeout"`
+	Routes      map[string]RouteLimit `toml:"routes"`
+}
+
+// RouteLimit struct for the requests per second and burst of a route, a zero
+// rate leaves the route unlimited
+type RouteLimit struct {
+	Rate  float64 `toml:"rate"`
+	Burst int     `toml:"burst"`
+}
+
+// QuotaOptions struct for daily upload quotas in config file, zero is unlimited
+type QuotaOptions struct {
+	UploadRows  int   `toml:"uploadrows"`
+	UploadBytes int64 `toml:"uploadbytes"`
+}
+
+// Rate limit response headers
+const (
+	HEADER_RATELIMIT_LIMIT     = "RateLimit-Limit"
+	HEADER_RATELIMIT_REMAINING = "RateLimit-Remaining"
+	HEADER_RATELIMIT_RESET     = "RateLimit-Reset"
+	HEADER_RETRY_AFTER         = "Retry-After"
+	QUOTAS_COLLECTION          = "quotas"
+)
+
+// RateLimitResult of taking a request from a client bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+/******************************************************************************************
+ *
+ * Rate Limiter - a token bucket per route and client
+ *
+*******************************************************************************************/
+type RateLimiter struct {
+	sync.Mutex
+	Default     RouteLimit
+	Routes      map[string]RouteLimit
+	IdleTimeout time.Duration
+	buckets     map[string]*clientBucket
+	swept       time.Time
+}
+
+type clientBucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Rate Limiter, nil when rate limiting is disabled
+var rateLimiter *RateLimiter
+
+/******************************************************************************************
+ *
+ * Create the Rate Limiter from config, route names must be known routes
+ *
+*******************************************************************************************/
+func NewRateLimiter(options RateLimitOptions) (*RateLimiter, error) {
+	idle, err := parseDurationOption(options.IdleTimeout, 10*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: invalid idletimeout: %v", err)
+	}
+
+	known := make(map[string]bool)
+	for _, route := range Routes() {
+		known[route.Name] = true
+	}
+	for name := range options.Routes {
+		if !known[name] {
+			return nil, fmt.Errorf("ratelimit: unknown route %q", name)
+		}
+	}
+
+	return &RateLimiter{
+		Default:     RouteLimit{Rate: options.Rate, Burst: options.Burst},
+		Routes:      options.Routes,
+		IdleTimeout: idle,
+		buckets:     make(map[string]*clientBucket),
+	}, nil
+}
+
+// limit of a route, the burst is at least one request
+func (l *RateLimiter) limit(route string) RouteLimit {
+	limit, ok := l.Routes[route]
+	if !ok {
+		limit = l.Default
+	}
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+	return limit
+}
+
+/******************************************************************************************
+ *
+ * Take a request of a client on a route at time now
+ *
+*******************************************************************************************/
+func (l *RateLimiter) Take(route string, client string, now time.Time) RateLimitResult {
+	limit := l.limit(route)
+	if limit.Rate <= 0 {
+		return RateLimitResult{Allowed: true}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now)
+
+	key := route + "|" + client
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[key] = bucket
+	}
+	bucket.seen = now
+
+	result := RateLimitResult{Allowed: true, Limit: limit.Burst}
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.Allowed = false
+		result.RetryAfter = delay
+	}
+
+	tokens := bucket.limiter.TokensAt(now)
+	result.Remaining = int(math.Max(0, math.Floor(tokens)))
+	result.Reset = time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second))
+	return result
+}
+
+// sweep drops the buckets of clients idle for longer than the idle timeout
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.IdleTimeout {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.seen) > l.IdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+/******************************************************************************************
+ *
+ * Rate Limit Middleware, runs after authentication so clients are keyed by credential
+ *
+*******************************************************************************************/
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := RouteName(r)
+
+		client := ClientKey(r)
+		result := rateLimiter.Take(route, client, time.Now())
+		if result.Limit > 0 {
+			w.Header().Set(HEADER_RATELIMIT_LIMIT, strconv.Itoa(result.Limit))
+			w.Header().Set(HEADER_RATELIMIT_REMAINING, strconv.Itoa(result.Remaining))
+			w.Header().Set(HEADER_RATELIMIT_RESET, seconds(result.Reset))
+		}
+		if !result.Allowed {
+			RequestLog(r).WithFields(log.Fields{"Client": client, "Route": route}).Info("Rate limited")
+			w.Header().Set(HEADER_RETRY_AFTER, seconds(result.RetryAfter))
+			respondWithErrorCode(w, ERR_TOO_MANY_REQUESTS)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/******************************************************************************************
+ *
+ * Client of a request for limits and quotas, the credential if authenticated or the
+ * remote IP address
+ *
+*******************************************************************************************/
+func ClientKey(r *http.Request) string {
+	if principal := RequestPrincipal(r); principal != nil {
+		return principal.Method + ":" + principal.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds formats a duration as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// QuotaUsage struct for the uploads of a client on a day
+type QuotaUsage struct {
+	ID      string    `bson:"_id"`
+	Client  string    `bson:"client"`
+	Day     string    `bson:"day"`
+	Rows    int       `bson:"rows"`
+	Bytes   int64     `bson:"bytes"`
+	Created time.Time `bson:"created"`
+}
+
+// QuotaStore keeps the daily upload usage of clients
+type QuotaStore interface {
+	FindUsage(client string, day string) (QuotaUsage, error)
+	AddUsage(client string, day string, rows int, bytes int64) error
+}
+
+/******************************************************************************************
+ *
+ * Quota Tracker - daily upload rows and bytes per client, days are UTC
+ *
+*******************************************************************************************/
+type QuotaTracker struct {
+	Store QuotaStore
+	Rows  int
+	Bytes int64
+	now   func() time.Time
+}
+
+// Quota Tracker, nil when no quota is configured
+var quotas *QuotaTracker
+
+/******************************************************************************************
+ *
+ * Create the Quota Tracker from config, nil without quotas
+ *
+*******************************************************************************************/
+func NewQuotaTracker(options QuotaOptions, store QuotaStore) *QuotaTracker {
+	if options.UploadRows <= 0 && options.UploadBytes <= 0 {
+		return nil
+	}
+	return &QuotaTracker{Store: store, Rows: options.UploadRows, Bytes: options.UploadBytes, now: time.Now}
+}
+
+func (q *QuotaTracker) day() (string, time.Duration) {
+	now := q.now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return now.Format("2006-01-02"), midnight.Sub(now)
+}
+
+/******************************************************************************************
+ *
+ * Check the quota of a client before an upload, responds with 429 when it is used up.
+ * An upload in progress is not cut off, its usage counts against the next upload.
+ *
+*******************************************************************************************/
+func (q *QuotaTracker) Allow(w http.ResponseWriter, client string) bool {
+	if q == nil {
+		return true
+	}
+	day, reset := q.day()
+	usage, err := q.Store.FindUsage(client, day)
+	if err != nil && err != mgo.ErrNotFound {
+		// uploads are not blocked when the usage cannot be read
+		log.WithFields(log.Fields{"Client": client, "Quota Error": err}).Warning()
+		return true
+	}
+
+	detail := ""
+	if q.Rows > 0 && usage.Rows >= q.Rows {
+		detail = fmt.Sprintf("Daily upload quota of %d rows exceeded", q.Rows)
+	} else if q.Bytes > 0 && usage.Bytes >= q.Bytes {
+		detail = fmt.Sprintf("Daily upload quota of %d bytes exceeded", q.Bytes)
+	}
+	if len(detail) == 0 {
+		return true
+	}
+
+	log.WithFields(log.Fields{"Client": client, "Rows": usage.Rows, "Bytes": usage.Bytes}).Info("Upload quota exceeded")
+	w.Header().Set(HEADER_RETRY_AFTER, seconds(reset))
+	respondWithErrorDetail(w, ERR_TOO_MANY_REQUESTS, detail)
+	return false
+}
+
+/******************************************************************************************
+ *
+ * Record the rows and bytes of an upload
+ *
+*******************************************************************************************/
+func (q *QuotaTracker) Record(client string, rows int, bytes int64) {
+	if q == nil {
+		return
+	}
+	day, _ := q.day()
+	if err := q.Store.AddUsage(client, day, rows, bytes); err != nil {
+		log.WithFields(log.Fields{"Client": client, "Quota Error": err}).Warning()
+	}
+}
+
+// byteCounter counts the bytes read from a request body
+type byteCounter struct {
+	io.ReadCloser
+	Count int64
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.Count += int64(n)
+	return n, err
+}
+
+/******************************************************************************************
+ *
+ * Quota Database Access, usage documents expire two days after they were created
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindUsage(client string, day string) (QuotaUsage, error) {
+	var usage QuotaUsage
+	done := m.operation("FindUsage", QUOTAS_COLLECTION)
+	err := db().C(QUOTAS_COLLECTION).FindId(client + "|" + day).One(&usage)
+	return usage, done(err)
+}
+
+func (m *MoviesDAO) AddUsage(client string, day string, rows int, bytes int64) error {
+	done := m.operation("AddUsage", QUOTAS_COLLECTION)
+	_, err := db().C(QUOTAS_COLLECTION).UpsertId(client+"|"+day, bson.M{
+		"$inc":         bson.M{"rows": rows, "bytes": bytes},
+		"$setOnInsert": bson.M{"client": client, "day": day, "created": time.Now().UTC()},
+	})
+	return done(err)
+}
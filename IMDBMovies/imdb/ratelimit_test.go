@@ -0,0 +1,150 @@
+/******************************************************************************
+ * \file        ratelimit_test.go
+ *
+ * \brief       GO File that tests rate limiting and upload quotas
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// memoryQuotaStore keeps quota usage for testing without a database
+type memoryQuotaStore map[string]QuotaUsage
+
+func (s memoryQuotaStore) FindUsage(client string, day string) (QuotaUsage, error) {
+	usage, ok := s[client+"|"+day]
+	if !ok {
+		return usage, mgo.ErrNotFound
+	}
+	return usage, nil
+}
+
+func (s memoryQuotaStore) AddUsage(client string, day string, rows int, bytes int64) error {
+	usage := s[client+"|"+day]
+	usage.Rows += rows
+	usage.Bytes += bytes
+	s[client+"|"+day] = usage
+	return nil
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitOptions{Rate: 1, Burst: 2,
+		Routes: map[string]RouteLimit{"GetVersion": {Rate: 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if result := limiter.Take("GetMovies", "ip:10.0.0.1", now); !result.Allowed || result.Remaining != 1-i {
+			t.Errorf("Request %d: expected to be allowed, got %+v", i, result)
+		}
+	}
+	result := limiter.Take("GetMovies", "ip:10.0.0.1", now)
+	if result.Allowed || result.RetryAfter != time.Second || result.Limit != 2 {
+		t.Errorf("Expected the third request to be limited for a second, got %+v", result)
+	}
+
+	// other clients and routes have their own buckets
+	if !limiter.Take("GetMovies", "ip:10.0.0.2", now).Allowed || !limiter.Take("PostCSV", "ip:10.0.0.1", now).Allowed {
+		t.Errorf("Expected separate buckets per client and route")
+	}
+	// a zero rate leaves the route unlimited
+	for i := 0; i < 10; i++ {
+		if !limiter.Take("GetVersion", "ip:10.0.0.1", now).Allowed {
+			t.Errorf("Expected GetVersion to be unlimited")
+		}
+	}
+	// tokens refill at the rate
+	if !limiter.Take("GetMovies", "ip:10.0.0.1", now.Add(time.Second)).Allowed {
+		t.Errorf("Expected a token after a second")
+	}
+}
+
+func TestRateLimiterUnknownRoute(t *testing.T) {
+	if _, err := NewRateLimiter(RateLimitOptions{Routes: map[string]RouteLimit{"NoSuchRoute": {Rate: 1}}}); err == nil {
+		t.Errorf("Expected an error for an unknown route")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	var err error
+	rateLimiter, err = NewRateLimiter(RateLimitOptions{Routes: map[string]RouteLimit{"GetUploads": {Rate: 0.5, Burst: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { rateLimiter = nil }()
+	router := NewRouter()
+
+	req, _ := http.NewRequest("GET", "/imdb/uploads", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if response.Header().Get(HEADER_RATELIMIT_LIMIT) != "1" || response.Header().Get(HEADER_RATELIMIT_REMAINING) != "0" {
+		t.Errorf("Expected RateLimit headers, got %v", response.Header())
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusTooManyRequests, response.Code)
+	if response.Header().Get(HEADER_RETRY_AFTER) != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", response.Header().Get(HEADER_RETRY_AFTER))
+	}
+	if !strings.Contains(response.Body.String(), `"code":"429"`) {
+		t.Errorf("Expected the standard error body, got %s", response.Body.String())
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/imdb/movies", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if key := ClientKey(req); key != "ip:192.0.2.1" {
+		t.Errorf("Expected the client IP, got %q", key)
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	store := memoryQuotaStore{}
+	quota := NewQuotaTracker(QuotaOptions{UploadRows: 100, UploadBytes: 1000}, store)
+	quota.now = func() time.Time { return time.Date(2018, 6, 1, 23, 0, 0, 0, time.UTC) }
+
+	if !quota.Allow(httptest.NewRecorder(), "apikey:ci") {
+		t.Errorf("Expected the first upload to be allowed")
+	}
+	quota.Record("apikey:ci", 60, 400)
+	if !quota.Allow(httptest.NewRecorder(), "apikey:ci") {
+		t.Errorf("Expected an upload under the quota to be allowed")
+	}
+	quota.Record("apikey:ci", 60, 400)
+
+	response := httptest.NewRecorder()
+	if quota.Allow(response, "apikey:ci") {
+		t.Errorf("Expected the rows quota to be exceeded")
+	}
+	checkResponseCode(t, http.StatusTooManyRequests, response.Code)
+	if response.Header().Get(HEADER_RETRY_AFTER) != "3600" {
+		t.Errorf("Expected Retry-After until midnight UTC, got %q", response.Header().Get(HEADER_RETRY_AFTER))
+	}
+
+	// the quota starts over the next day
+	quota.now = func() time.Time { return time.Date(2018, 6, 2, 0, 0, 1, 0, time.UTC) }
+	if !quota.Allow(httptest.NewRecorder(), "apikey:ci") {
+		t.Errorf("Expected the quota to reset at midnight")
+	}
+
+	if NewQuotaTracker(QuotaOptions{}, store) != nil {
+		t.Errorf("Expected no tracker without quotas")
+	}
+}
@@ -0,0 +1,263 @@
+/******************************************************************************
+ * \file        reload.go
+ *
+ * \brief       GO File that holds the current config and reloads it on SIGHUP
+ *              or when the config file changes
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReloadOptions struct for config reload settings in config file
+type ReloadOptions struct {
+	Watch    bool   `toml:"watch"`
+	Interval string `toml:"interval"`
+}
+
+// Config sections applied without a restart, changes to any other key need one
+var reloadableSections = []string{"settings", "csv", "logging"}
+
+// Current config, replaced as a whole so readers see a consistent snapshot
+var currentConfig atomic.Pointer[TomlConfig]
+
+/******************************************************************************************
+ *
+ * Snapshot of the current config, do not modify it. Empty before the config is loaded.
+ *
+*******************************************************************************************/
+func Config() *TomlConfig {
+	if c := currentConfig.Load(); c != nil {
+		return c
+	}
+	return &TomlConfig{}
+}
+
+// SetConfig makes a config the current one
+func SetConfig(c TomlConfig) {
+	currentConfig.Store(&c)
+}
+
+/******************************************************************************************
+ *
+ * Config Reloader - loads the config again on SIGHUP or when the config file changes,
+ * the layers of the loader still apply so flags keep overriding the file
+ *
+*******************************************************************************************/
+type ConfigReloader struct {
+	Loader   *ConfigLoader
+	Interval time.Duration
+
+	mu      sync.Mutex
+	modTime time.Time
+	// keys needing a restart that were logged, they are logged again once they change
+	warned  string
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// ReloadResult lists the keys applied and the keys that differ from the running config
+// but need a restart
+type ReloadResult struct {
+	Applied []string
+	Restart []string
+}
+
+/******************************************************************************************
+ *
+ * Create the Config Reloader from config, the file is polled only when watched
+ *
+*******************************************************************************************/
+func NewConfigReloader(loader *ConfigLoader, options ReloadOptions) (*ConfigReloader, error) {
+	interval, err := parseDurationOption(options.Interval, 5*time.Second)
+	if err == nil && options.Watch && interval <= 0 {
+		err = errNotPositive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reload: invalid interval: %v", err)
+	}
+	if !options.Watch {
+		interval = 0
+	}
+	reloader := &ConfigReloader{
+		Loader:   loader,
+		Interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	reloader.modTime = reloader.fileModTime()
+	return reloader, nil
+}
+
+/******************************************************************************************
+ *
+ * Load the config again and apply the reloadable sections. An invalid config is not
+ * applied, the current one stays in use and the file is loaded again on the next poll.
+ * Log levels changed at runtime are kept.
+ *
+*******************************************************************************************/
+func (cr *ConfigReloader) Reload() (ReloadResult, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	var result ReloadResult
+	modTime := cr.fileModTime()
+	loaded, err := cr.Loader.Load()
+	if err != nil {
+		return result, err
+	}
+
+	current := *Config()
+	next := current
+	changed := configChanges(reflect.ValueOf(current), reflect.ValueOf(loaded), "")
+	for _, key := range changed {
+		if !reloadable(key) {
+			result.Restart = append(result.Restart, key)
+			continue
+		}
+		result.Applied = append(result.Applied, key)
+	}
+	if len(result.Applied) == 0 {
+		cr.modTime = modTime
+		return result, nil
+	}
+
+	next.Settings = loaded.Settings
+	next.CSV = loaded.CSV
+	next.Logging = loaded.Logging
+	if !reflect.DeepEqual(current.Logging, next.Logging) {
+		if err := ConfigureLogging(next.Logging, next.App.Logdir); err != nil {
+			return ReloadResult{Restart: result.Restart}, err
+		}
+	}
+	SetConfig(next)
+	cr.modTime = modTime
+	return result, nil
+}
+
+// reload logs the outcome of a reload
+func (cr *ConfigReloader) reload(reason string) {
+	result, err := cr.Reload()
+	fields := log.Fields{"Reason": reason, "Config File": cr.Loader.Loaded}
+	if err != nil {
+		fields["Config Error"] = err
+		log.WithFields(fields).Error("Config is not reloaded, keeping the current config")
+		configReloads.WithLabelValues("failed").Inc()
+		return
+	}
+	configReloads.WithLabelValues("applied").Inc()
+	// pending keys are logged once, and again when they change
+	if keys := strings.Join(result.Restart, ","); keys != cr.warned {
+		if len(keys) > 0 {
+			log.WithFields(log.Fields{"Keys": keys}).Warning("Config changes need a restart")
+		}
+		cr.warned = keys
+	}
+	fields["Applied"] = strings.Join(result.Applied, ",")
+	log.WithFields(fields).Info("Config reloaded")
+}
+
+/******************************************************************************************
+ *
+ * Reload on SIGHUP, and when the config file changes if it is watched, until Close
+ *
+*******************************************************************************************/
+func (cr *ConfigReloader) Run(signals <-chan os.Signal) {
+	defer close(cr.stopped)
+
+	var poll <-chan time.Time
+	if cr.Interval > 0 {
+		ticker := time.NewTicker(cr.Interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	for {
+		select {
+		case <-cr.done:
+			return
+		case sig := <-signals:
+			cr.reload(sig.String())
+		case <-poll:
+			if cr.fileChanged() {
+				cr.reload("file changed")
+			}
+		}
+	}
+}
+
+/******************************************************************************************
+ *
+ * Stop reloading on shutdown, waits for a reload in progress
+ *
+*******************************************************************************************/
+func (cr *ConfigReloader) Close() {
+	select {
+	case <-cr.done:
+	default:
+		close(cr.done)
+	}
+	<-cr.stopped
+}
+
+func (cr *ConfigReloader) fileChanged() bool {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return !cr.fileModTime().Equal(cr.modTime)
+}
+
+func (cr *ConfigReloader) fileModTime() time.Time {
+	if len(cr.Loader.Loaded) == 0 {
+		return time.Time{}
+	}
+	info, err := os.Stat(cr.Loader.Loaded)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func reloadable(key string) bool {
+	for _, section := range reloadableSections {
+		if key == section || strings.HasPrefix(key, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configChanges lists the keys whose values differ, maps and lists are compared whole
+func configChanges(a reflect.Value, b reflect.Value, prefix string) []string {
+	var keys []string
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			keys = append(keys, configChanges(a.Field(i), b.Field(i), prefix)...)
+			continue
+		}
+		name := configName(field)
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configChanges(a.Field(i), b.Field(i), prefix+name+".")...)
+		} else if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			keys = append(keys, prefix+name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+/******************************************************************************
+ * \file        reload_test.go
+ *
+ * \brief       GO File that tests reloading the config at runtime
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const reloadConfig = `
+[app]
+port = ":8000"
+
+[settings]
+defaultyear = 2016
+filesizekb = 2048
+
+[logging]
+outputs = []
+`
+
+// withConfig makes a config current for a test
+func withConfig(t *testing.T, c TomlConfig) {
+	previous := currentConfig.Load()
+	SetConfig(c)
+	t.Cleanup(func() { currentConfig.Store(previous) })
+}
+
+func newTestReloader(t *testing.T, content string, options ReloadOptions) (*ConfigReloader, string) {
+	withDefaultLogging(t)
+	file := writeConfigFile(t, content)
+	loader := &ConfigLoader{File: file}
+	conf, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, conf)
+	reloader, err := NewConfigReloader(loader, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reloader, file
+}
+
+func rewriteConfigFile(t *testing.T, file string, content string) {
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// a new modification time even on file systems with a coarse clock
+	later := time.Now().Add(time.Second)
+	os.Chtimes(file, later, later)
+}
+
+func TestReloadSettings(t *testing.T) {
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{})
+	before := Config()
+
+	changed := strings.Replace(reloadConfig, "filesizekb = 2048", "filesizekb = 8192", 1)
+	changed = strings.Replace(changed, ":8000", ":8025", 1)
+	rewriteConfigFile(t, file, changed+"level = \"debug\"\n")
+
+	result, err := reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Applied, ",") != "logging.level,settings.filesizekb" {
+		t.Errorf("Expected the settings and logging to be applied, got %v", result.Applied)
+	}
+	if strings.Join(result.Restart, ",") != "app.port" {
+		t.Errorf("Expected the port to need a restart, got %v", result.Restart)
+	}
+
+	if defaultMaxUploadSize() != 8192*1024 || (&Tenant{}).MaxUploadSize() != 8192*1024 {
+		t.Errorf("Expected the new upload size, got %d", defaultMaxUploadSize())
+	}
+	if Config().App.Port != ":8000" {
+		t.Errorf("Expected the running port to stay, got %s", Config().App.Port)
+	}
+	if log.GetLevel() != log.DebugLevel {
+		t.Errorf("Expected the new log level, got %s", log.GetLevel())
+	}
+	if before.Settings.FileSizeKB != 2048 {
+		t.Errorf("Expected the previous snapshot to be unchanged")
+	}
+}
+
+func TestReloadInterval(t *testing.T) {
+	for _, interval := range []string{"0s", "-5s"} {
+		if _, err := NewConfigReloader(&ConfigLoader{}, ReloadOptions{Watch: true, Interval: interval}); err == nil {
+			t.Errorf("Expected interval %s to be rejected", interval)
+		}
+	}
+	if _, err := NewConfigReloader(&ConfigLoader{}, ReloadOptions{Interval: "0s"}); err != nil {
+		t.Errorf("Expected the interval to be ignored without watch, got %v", err)
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{})
+
+	rewriteConfigFile(t, file, strings.Replace(reloadConfig, "filesizekb = 2048", "filesizekb = -1", 1))
+	if _, err := reloader.Reload(); err == nil {
+		t.Errorf("Expected an invalid config to be rejected")
+	}
+	if Config().Settings.FileSizeKB != 2048 {
+		t.Errorf("Expected the current config to stay, got %d", Config().Settings.FileSizeKB)
+	}
+}
+
+func TestReloadRestartPending(t *testing.T) {
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{})
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	// the port stays pending on every reload while it is not applied, it is logged once
+	rewriteConfigFile(t, file, strings.Replace(reloadConfig, ":8000", ":8025", 1))
+	for i := 0; i < 2; i++ {
+		if result, _ := reloader.Reload(); strings.Join(result.Restart, ",") != "app.port" {
+			t.Errorf("Expected the port to need a restart, got %v", result.Restart)
+		}
+		reloader.reload("test")
+	}
+	if count := strings.Count(logged.String(), "Config changes need a restart"); count != 1 {
+		t.Errorf("Expected the pending port to be logged once, got %d", count)
+	}
+
+	// changed back the running port is in use again
+	rewriteConfigFile(t, file, reloadConfig)
+	if result, _ := reloader.Reload(); len(result.Restart) > 0 {
+		t.Errorf("Expected nothing to need a restart, got %v", result.Restart)
+	}
+}
+
+func TestReloadRetriesInvalidFile(t *testing.T) {
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{})
+
+	rewriteConfigFile(t, file, "[settings\n")
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("Expected an invalid config to be rejected")
+	}
+	if !reloader.fileChanged() {
+		t.Errorf("Expected the invalid file to be loaded again on the next poll")
+	}
+
+	rewriteConfigFile(t, file, strings.Replace(reloadConfig, "defaultyear = 2016", "defaultyear = 2001", 1))
+	if _, err := reloader.Reload(); err != nil || reloader.fileChanged() || Config().Settings.DefaultYear != 2001 {
+		t.Errorf("Expected the fixed file to be applied, got %v %d", err, Config().Settings.DefaultYear)
+	}
+}
+
+func TestReloadKeepsLogLevels(t *testing.T) {
+	withAccessLog(t)
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{})
+
+	checkResponseCode(t, http.StatusOK, putLogLevel(`{"module": "ingest", "level": "debug"}`).Code)
+	rewriteConfigFile(t, file, reloadConfig+"level = \"warn\"\n")
+	if _, err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if log.GetLevel() != log.WarnLevel || ingestLog.GetLevel() != log.DebugLevel {
+		t.Errorf("Expected the reloaded level with the runtime one kept, got %+v", currentLogLevels())
+	}
+}
+
+func TestReloadSignalAndWatch(t *testing.T) {
+	reloader, file := newTestReloader(t, reloadConfig, ReloadOptions{Watch: true, Interval: "10ms"})
+	signals := make(chan os.Signal, 1)
+	go reloader.Run(signals)
+	defer reloader.Close()
+
+	waitFor := func(year int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for Config().Settings.DefaultYear != year {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected defaultyear %d, got %d", year, Config().Settings.DefaultYear)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// the file is watched
+	rewriteConfigFile(t, file, strings.Replace(reloadConfig, "defaultyear = 2016", "defaultyear = 2001", 1))
+	waitFor(2001)
+
+	// SIGHUP reloads even when the file looks unchanged
+	reloader.Loader.Overrides = []string{"settings.defaultyear=1999"}
+	signals <- syscall.SIGHUP
+	waitFor(1999)
+}
+
+func TestConfigChanges(t *testing.T) {
+	a, b := DefaultConfig(), DefaultConfig()
+	b.Database.PingInterval = "1m"
+	b.CSV.Profiles = map[string]CSVProfile{"tsv": {Delimiter: "\t"}}
+	b.Auth.Methods = []string{AUTH_METHOD_JWT}
+
+	keys := configChanges(reflect.ValueOf(a), reflect.ValueOf(b), "")
+	if strings.Join(keys, ",") != "auth.methods,csv.profiles,database.pinginterval" {
+		t.Errorf("Expected the changed keys, got %v", keys)
+	}
+}
@@ -21,12 +21,15 @@ import (
 	"strings"
 	"errors"
 	"encoding/csv"
+	"time"
     "gopkg.in/mgo.v2/bson"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 // Movie Struct for Movie Record in CSV
 type Movie struct {
-	ID      bson.ObjectId `bson:"_id,omitempty"`
+	ID      bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
 	Rank int `json:"rank"`
 	Title string `json:"title"`
 	Genre []string `json:"genre"`
@@ -39,6 +42,11 @@ type Movie struct {
 	Votes int `json:"votes"`
 	RevenueMil float64 `json:"revenue_mil"`
 	Metascore int `json:"metascore"`
+	IMDbID string `bson:"imdb_id,omitempty" json:"imdb_id,omitempty"`
+	Sources map[string]string `bson:"sources,omitempty" json:"sources,omitempty"`
+	EnrichedAt time.Time `bson:"enriched_at,omitempty" json:"-"`
+	CommunityRating float64 `bson:"community_rating,omitempty" json:"community_rating,omitempty"`
+	CommunityVotes int `bson:"community_votes,omitempty" json:"community_votes,omitempty"`
 }
 
 // MovieGet Struct for Get API
@@ -49,6 +57,8 @@ type MovieGet struct {
 	Year int `json:"year"`
 	RuntimeMin int `json:"runtime_min"`
 	Rating float64 `json:"rating"`
+	CommunityRating float64 `bson:"community_rating,omitempty" json:"community_rating,omitempty"`
+	CommunityVotes int `bson:"community_votes,omitempty" json:"community_votes,omitempty"`
 }
 
 // UploadResults Struct for POST Response
@@ -56,8 +66,46 @@ type UploadResults struct{
 	RecordsRead int `json:"RecordsRead"`
 	RecordsCreated int `json:"RecordsCreated"`
 	RecordsErrored int `json:"RecordsErrored"`
+	Files []FileUploadResults `json:"Files,omitempty"`
+	progress *UploadProgress
+	catalog *MoviesDAO
+	span trace.Span
 }
 
+// FileUploadResults Struct for each CSV file of a zip upload
+type FileUploadResults struct{
+	FileName string `json:"FileName"`
+	UploadResults
+	Error string `json:"Error,omitempty"`
+}
+
+// AddFile adds the counts of a single file to the upload totals
+func (u *UploadResults) AddFile(f FileUploadResults) {
+	u.RecordsRead += f.RecordsRead
+	u.RecordsCreated += f.RecordsCreated
+	u.RecordsErrored += f.RecordsErrored
+	u.Files = append(u.Files, f)
+}
+
+// Catalog the upload is stored in, the default tenant if not set
+func (u *UploadResults) Catalog() *MoviesDAO {
+	if u.catalog == nil {
+		return &dao
+	}
+	return u.catalog
+}
+
+// Log of the request of the upload
+func (u *UploadResults) Log() *log.Entry {
+	return ModuleLog(u.Catalog().ctx, MODULE_INGEST)
+}
+
+// errInvalidFormat is returned when the CSV content cannot be parsed
+var errInvalidFormat = errors.New("Invalid File Format")
+
+// errUploadFailed is reported to progress watchers when the upload is rejected
+var errUploadFailed = errors.New("upload failed")
+
 type ErrorCode int
 
 // Custom Error Codes
@@ -72,11 +120,36 @@ const (
     ERR_INTERNAL_SERVER				ErrorCode = 7
     ERR_NO_CONTENT					ErrorCode = 8
 	ERR_CONTENT_TYPE_INVALID		ErrorCode = 9
+	ERR_CSV_PROFILE_INVALID			ErrorCode = 10
+	ERR_DELIMITER_INVALID			ErrorCode = 11
+	ERR_ID_INVALID					ErrorCode = 12
+	ERR_MOVIE_INVALID				ErrorCode = 13
+	ERR_NOT_FOUND					ErrorCode = 14
+	ERR_DUPLICATE					ErrorCode = 15
+	ERR_WEBHOOK_INVALID				ErrorCode = 16
+	ERR_UPLOAD_ID_IN_USE			ErrorCode = 17
+	ERR_UNAUTHORIZED				ErrorCode = 18
+	ERR_FORBIDDEN					ErrorCode = 19
+	ERR_TOO_MANY_REQUESTS			ErrorCode = 20
+	ERR_TENANT_INVALID				ErrorCode = 21
+	ERR_TENANT_NOT_FOUND			ErrorCode = 22
+	ERR_TENANT_EXISTS				ErrorCode = 23
+	ERR_USER_INVALID				ErrorCode = 24
+	ERR_RATING_INVALID				ErrorCode = 25
+	ERR_WATCHED_INVALID				ErrorCode = 26
+	ERR_REVIEW_INVALID				ErrorCode = 27
+	ERR_REVIEW_STATUS_INVALID		ErrorCode = 28
+	ERR_PAGINATION_INVALID			ErrorCode = 29
+	ERR_SERVICE_UNAVAILABLE			ErrorCode = 30
+	ERR_LOG_LEVEL_INVALID			ErrorCode = 31
+	ERR_REQUEST_INVALID				ErrorCode = 32
+	ERR_RESPONSE_INVALID			ErrorCode = 33
 )
 
-// Maximum Upload Size File Settings
-var maxUploadSize = conf.Settings.FileSizeKB * 1024
-var defaultMaxUploadSize = Max(2048*1024,conf.Settings.FileSizeKB * 1024)
+// Maximum Upload Size of the default catalog, read from the current config on every call
+func defaultMaxUploadSize() int64 {
+    return Max(2048*1024, Config().Settings.FileSizeKB * 1024)
+}
 
 // Max function for int64 datatypes
 func Max(x, y int64) int64 {
@@ -97,7 +170,7 @@ func ErrorMsg(ec ErrorCode) string{
 		case ERR_FILE_INVALID_FORMAT:
 			msg = "Invalid File Format"
 		case ERR_FILE_TOO_BIG:
-			msg = fmt.Sprintf("File is too large. Maximum upload size is %d Bytes", Max(2048*1024,conf.Settings.FileSizeKB * 1024))
+			msg = fmt.Sprintf("File is too large. Maximum upload size is %d Bytes", defaultMaxUploadSize())
 		case ERR_YEAR_AND_RANGE:
 			msg = "Please provide either the year or a range but not both"
 		case ERR_YEAR_RANGE_INVALID:
@@ -110,6 +183,54 @@ func ErrorMsg(ec ErrorCode) string{
             msg = "Internal Server Error"
 		case ERR_CONTENT_TYPE_INVALID:
 			msg = "Please upload file as multipart/form-data with \"file\" as key"
+		case ERR_CSV_PROFILE_INVALID:
+			msg = "Please provide a valid CSV mapping profile"
+		case ERR_DELIMITER_INVALID:
+			msg = "Please provide a single character delimiter"
+		case ERR_ID_INVALID:
+			msg = "Please provide a valid id"
+		case ERR_MOVIE_INVALID:
+			msg = "Please provide a valid movie record"
+		case ERR_NOT_FOUND:
+			msg = "Not Found"
+		case ERR_DUPLICATE:
+			msg = "Movie with this title and year already exists"
+		case ERR_WEBHOOK_INVALID:
+			msg = "Please provide a valid webhook url and events"
+		case ERR_UPLOAD_ID_IN_USE:
+			msg = "An upload with this id is in progress"
+		case ERR_UNAUTHORIZED:
+			msg = "Please provide valid credentials"
+		case ERR_FORBIDDEN:
+			msg = "Your role is not allowed to perform this request"
+		case ERR_TOO_MANY_REQUESTS:
+			msg = "Too many requests, please retry later"
+		case ERR_TENANT_INVALID:
+			msg = "Please provide a valid tenant name, default year and file size"
+		case ERR_TENANT_NOT_FOUND:
+			msg = "Tenant not found"
+		case ERR_TENANT_EXISTS:
+			msg = "Tenant already exists"
+		case ERR_USER_INVALID:
+			msg = "Please provide a valid user"
+		case ERR_RATING_INVALID:
+			msg = "Please provide a rating from 1 to 10"
+		case ERR_WATCHED_INVALID:
+			msg = "Please provide watched as true or false"
+		case ERR_REVIEW_INVALID:
+			msg = "Please provide a valid review"
+		case ERR_REVIEW_STATUS_INVALID:
+			msg = "Please provide approved or rejected as status"
+		case ERR_PAGINATION_INVALID:
+			msg = "Please provide a valid page, per_page, sort and status"
+		case ERR_SERVICE_UNAVAILABLE:
+			msg = "Service is not ready, please retry later"
+		case ERR_LOG_LEVEL_INVALID:
+			msg = "Please provide a valid log level and module"
+		case ERR_REQUEST_INVALID:
+			msg = "Request does not match the API description"
+		case ERR_RESPONSE_INVALID:
+			msg = "Response does not match the API description"
         default:
             msg = "Unknown Error Occured"
     }
@@ -131,10 +252,40 @@ func HTTPCode(ec ErrorCode) int{
 			 ERR_YEAR_AND_RANGE,
 			 ERR_YEAR_RANGE_INVALID,
 			 ERR_YEAR_INVALID,
-			 ERR_GENRE_INVALID:
+			 ERR_GENRE_INVALID,
+			 ERR_CSV_PROFILE_INVALID,
+			 ERR_DELIMITER_INVALID,
+			 ERR_ID_INVALID,
+			 ERR_MOVIE_INVALID,
+			 ERR_WEBHOOK_INVALID,
+			 ERR_TENANT_INVALID,
+			 ERR_USER_INVALID,
+			 ERR_RATING_INVALID,
+			 ERR_WATCHED_INVALID,
+			 ERR_REVIEW_INVALID,
+			 ERR_REVIEW_STATUS_INVALID,
+			 ERR_PAGINATION_INVALID,
+			 ERR_LOG_LEVEL_INVALID,
+			 ERR_REQUEST_INVALID:
             code = 400
-        case ERR_INTERNAL_SERVER:
+		case ERR_UNAUTHORIZED:
+			code = 401
+		case ERR_FORBIDDEN:
+			code = 403
+		case ERR_TOO_MANY_REQUESTS:
+			code = 429
+		case ERR_NOT_FOUND,
+			 ERR_TENANT_NOT_FOUND:
+			code = 404
+		case ERR_DUPLICATE,
+			 ERR_UPLOAD_ID_IN_USE,
+			 ERR_TENANT_EXISTS:
+			code = 409
+        case ERR_INTERNAL_SERVER,
+			 ERR_RESPONSE_INVALID:
             code = 500
+		case ERR_SERVICE_UNAVAILABLE:
+			code = 503
         case ERR_NO_CONTENT:
             code = 204
 		case ERR_CONTENT_TYPE_INVALID:
@@ -154,6 +305,17 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
     w.Write(response)
 }
 
+/******************************************************************************************
+ * Send list of Movies, encoding is spanned to tell it apart from the query
+******************************************************************************************/
+func respondWithMovies(w http.ResponseWriter, r *http.Request, movies []MovieGet) {
+    span := startSpan(r, "GetMovies encode")
+    defer span.End()
+    span.SetAttributes(attribute.Int("imdb.movies", len(movies)))
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(movies)
+}
 
 /******************************************************************************************
  * Send JSON Response with Error code and message given an ErrorCode
@@ -161,7 +323,24 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 func respondWithErrorCode(w http.ResponseWriter, errc ErrorCode) {
     code := HTTPCode(errc)
     msg := ErrorMsg(errc)
-    respondWithJSON(w, code, map[string]string{"error":msg,"code":strconv.Itoa(code)})
+    respondWithJSON(w, code, errorBody(w, map[string]string{"error":msg,"code":strconv.Itoa(code)}))
+}
+
+/******************************************************************************************
+ * Send JSON Response for an ErrorCode with details on the cause
+******************************************************************************************/
+func respondWithErrorDetail(w http.ResponseWriter, errc ErrorCode, detail string) {
+    code := HTTPCode(errc)
+    msg := ErrorMsg(errc)
+    respondWithJSON(w, code, errorBody(w, map[string]string{"error":msg,"code":strconv.Itoa(code),"detail":detail}))
+}
+
+// errorBody with the request ID the response was sent with, to look up the request in the logs
+func errorBody(w http.ResponseWriter, body map[string]string) map[string]string {
+    if id := w.Header().Get(HEADER_REQUEST_ID); len(id) > 0 {
+        body["request_id"] = id
+    }
+    return body
 }
 
 /******************************************************************************************
@@ -171,7 +350,7 @@ func respondWithErrorCode(w http.ResponseWriter, errc ErrorCode) {
 ******************************************************************************************/
 func GetVersion(w http.ResponseWriter, r *http.Request) {
 
-    log.WithFields(log.Fields{"EndPoint":"GetVersion"}).Info()
+    RequestLog(r).WithFields(log.Fields{"EndPoint":"GetVersion"}).Info()
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -190,12 +369,11 @@ func GetVersion(w http.ResponseWriter, r *http.Request) {
 
 /******************************************************************************************
  *
- * Get Endpoints
+ * Get Endpoints, the OpenAPI document generated from the routes as YAML
  *
 ******************************************************************************************/
 func GetEndpoints(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/text")
-    http.ServeFile(w, r, "data/swagger.yaml")
+    GetOpenAPIYAML(w, r)
 }
 
 /******************************************************************************************
@@ -205,29 +383,100 @@ func GetEndpoints(w http.ResponseWriter, r *http.Request) {
 ******************************************************************************************/
 func PostCSV(w http.ResponseWriter, r *http.Request) {
 
-    log.WithFields(log.Fields{"EndPoint":"PostCSV"}).Info()
+    RequestLog(r).WithFields(log.Fields{"EndPoint":"PostCSV"}).Info()
 
 	contentType := r.Header.Get("Content-type")
 
-	log.WithFields(log.Fields{"contentType":contentType}).Info()
+	RequestLog(r).WithFields(log.Fields{"contentType":contentType}).Info()
 
-	if !(strings.Contains(contentType, "multipart/form-data")){
-		log.Info("Content is NOT multipart/form-data")
+	// JSON array and newline delimited JSON bodies are accepted besides CSV files
+	isJSON := strings.Contains(contentType, "application/json")
+	isNDJSON := strings.Contains(contentType, "application/x-ndjson")
+
+	if !(strings.Contains(contentType, "multipart/form-data") || isJSON || isNDJSON){
+		RequestLog(r).Info("Content is NOT multipart/form-data")
 		respondWithErrorCode(w, ERR_CONTENT_TYPE_INVALID)
 		return
 	}
 
+	// clients over their daily upload quota are throttled
+	client := ClientKey(r)
+	if !quotas.Allow(w, client) {
+		return
+	}
+
+	// clients can follow the progress of the upload by its id
+	progress, ok := startUploadProgress(w, r)
+	if !ok {
+		return
+	}
+	defer progress.Finish(nil, errUploadFailed)
+
+	// the movies are created in the span of the upload
+	r, span := startUploadSpan(r, progress)
+	defer span.End()
+
+	var uploadresults = new(UploadResults)
+	uploadresults.progress = progress
+	uploadresults.span = span
+	uploadresults.catalog = TenantDAO(r)
+
 	// Validate File size, return FILE_TOO_BIG
-	maxUploadSize := Max(2048*1024,conf.Settings.FileSizeKB * 1024)
-	log.WithFields(log.Fields{"maxUploadSize":maxUploadSize}).Info()
+	maxUploadSize := RequestTenant(r).MaxUploadSize()
+	RequestLog(r).WithFields(log.Fields{"maxUploadSize":maxUploadSize}).Info()
 	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// rows and bytes of the upload count against the daily quota
+	counter := &byteCounter{ReadCloser: r.Body}
+	r.Body = counter
+	defer func() { quotas.Record(client, uploadresults.RecordsRead, counter.Count) }()
+
+	// a gzip encoded request body is decompressed with the same size limit
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		body, err := OpenGzip(r.Body, &sizeLimiter{Remaining: maxUploadSize})
+		if err != nil {
+			RequestLog(r).WithFields(log.Fields{"err":err}).Info()
+			respondWithErrorCode(w, ERR_FILE_INVALID_FORMAT)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+	}
+
+	if isJSON || isNDJSON {
+		var err error
+		if isNDJSON {
+			err = ImportNDJSON(r.Body, uploadresults)
+		}else{
+			err = ImportJSON(r.Body, uploadresults)
+		}
+		respondWithUploadResults(w, uploadresults, err)
+		return
+	}
+
     if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		log.WithFields(log.Fields{"err":err}).Info()
+		RequestLog(r).WithFields(log.Fields{"err":err}).Info()
 		respondWithErrorCode(w, ERR_FILE_TOO_BIG)
         return
     }
 
-	file, _ , err := r.FormFile("file")
+	// column mapping profile and delimiter can be chosen per upload
+	profile, ok := LookupCSVProfile(r.FormValue("profile"))
+	if !ok {
+		respondWithErrorCode(w, ERR_CSV_PROFILE_INVALID)
+		return
+	}
+	if delimiter := r.FormValue("delimiter"); len(delimiter) > 0 {
+		custom := *profile
+		custom.Delimiter = delimiter
+		profile = &custom
+	}
+	if _, err := ParseDelimiter(profile.Delimiter); err != nil {
+		respondWithErrorCode(w, ERR_DELIMITER_INVALID)
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("file")
 
 	if err != nil {
 		respondWithErrorCode(w, ERR_FILE_INVALID)
@@ -236,18 +485,115 @@ func PostCSV(w http.ResponseWriter, r *http.Request) {
 
     defer file.Close()
 
-	reader := csv.NewReader(file)
-	if (err!= nil){
-		log.WithFields(log.Fields{"error":err}).Info()
+	// detect plain, gzip or zip content by magic bytes
+	format, reader, err := DetectFormat(file)
+	if err != nil {
+		respondWithErrorCode(w, ERR_FILE_INVALID)
+		return
 	}
+	RequestLog(r).WithFields(log.Fields{"format":format}).Info()
+
+	// decompressed size is limited to block zip bombs
+	limiter := &sizeLimiter{Remaining: maxUploadSize}
 
-	reader.FieldsPerRecord = 12
+	switch format {
+	case FORMAT_ZIP:
+		err = ImportZip(file, fileHeader.Size, limiter, profile, uploadresults)
+	case FORMAT_GZIP:
+		var gz io.ReadCloser
+		gz, err = OpenGzip(reader, limiter)
+		if err != nil {
+			err = errInvalidFormat
+			break
+		}
+		defer gz.Close()
+		err = ImportCSV(gz, profile, uploadresults)
+	default:
+		err = ImportCSV(limiter.Wrap(reader), profile, uploadresults)
+	}
+
+	if limiter.Exceeded {
+		err = errTooLarge
+	}
+	respondWithUploadResults(w, uploadresults, err)
+}
+
+/******************************************************************************************
+ * Send Upload Results, or the error that aborted the upload with the records stored before
+******************************************************************************************/
+func respondWithUploadResults(w http.ResponseWriter, uploadresults *UploadResults, err error) {
+	uploadresults.progress.Finish(uploadresults, err)
+	observeUpload(uploadresults, err)
+	traceUpload(uploadresults.span, uploadresults, err)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errTooLarge) || errors.As(err, &maxBytesErr) {
+		respondWithUploadError(w, uploadresults, ERR_FILE_TOO_BIG, "")
+		return
+	}
+	var missingErr *MissingColumnsError
+	if errors.As(err, &missingErr) {
+		respondWithUploadError(w, uploadresults, ERR_FILE_INVALID_FORMAT, missingErr.Error())
+		return
+	}
+	if err != nil {
+		respondWithUploadError(w, uploadresults, ERR_FILE_INVALID_FORMAT, "")
+		return
+	}
+
+	uploadresults.Log().WithFields(log.Fields{"Total Records Created":uploadresults.RecordsCreated}).Info()
+
+	webhooks.Publish(uploadresults.Catalog().Tenant, EVENT_UPLOAD_COMPLETED, uploadresults)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadresults)
+}
+
+// respondWithUploadError adds the results to the error once records were read, a stream
+// that breaks or grows over the size limit is only noticed after earlier rows are stored
+func respondWithUploadError(w http.ResponseWriter, uploadresults *UploadResults, errc ErrorCode, detail string) {
+	if uploadresults.RecordsRead == 0 && len(uploadresults.Files) == 0 {
+		if len(detail) > 0 {
+			respondWithErrorDetail(w, errc, detail)
+		}else{
+			respondWithErrorCode(w, errc)
+		}
+		return
+	}
+
+	code := HTTPCode(errc)
+	fields := map[string]string{"error":ErrorMsg(errc),"code":strconv.Itoa(code)}
+	if len(detail) > 0 {
+		fields["detail"] = detail
+	}
+	body := map[string]interface{}{"results": uploadresults}
+	for key, value := range errorBody(w, fields) {
+		body[key] = value
+	}
+	respondWithJSON(w, code, body)
+}
+
+/******************************************************************************************
+ *
+ * Import movie records from a CSV stream, counts are added to results
+ *
+******************************************************************************************/
+func ImportCSV(file io.Reader, profile *CSVProfile, results *UploadResults) error {
+
+	delimiter, err := ParseDelimiter(profile.Delimiter)
+	if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(file)
+
+	// every record must have as many fields as the header
+	reader.FieldsPerRecord = 0
+	reader.Comma = delimiter
 	reader.TrimLeadingSpace = true
 	header := true
+	var columns *ColumnMap
 
-	insRecords := 0
-	errRecords := 0
-	totRecords := 0
 	i := 0
 	for {
         line, error := reader.Read()
@@ -255,22 +601,38 @@ func PostCSV(w http.ResponseWriter, r *http.Request) {
         if error == io.EOF {
             break
         } else if error != nil {
-            log.WithFields(log.Fields{"Invalid File Content in line. Error":error}).Info()
+            results.Log().WithFields(log.Fields{"Invalid File Content in line. Error":error}).Info()
+			// only a malformed row can be skipped, a broken stream such as a truncated
+			// gzip returns the same error on every read and aborts the upload
+			var parseErr *csv.ParseError
+			if !errors.As(error, &parseErr) {
+				return uploadReadError(error)
+			}
 			// if we encounter this error in the first line - consider it as invalid file
 			if (header == true){
-				respondWithErrorCode(w, ERR_FILE_INVALID_FORMAT)
-				return
+				return errInvalidFormat
 			}
 			// else just skip the line and move to next
 			continue
         }
 
-		if line == nil || header == true {
-			log.WithFields(log.Fields{"Line Number":i,"Ignoring line":line}).Info()
+		if line == nil {
+			continue
+		}
+
+		// map the columns by header name, fail fast if required columns are missing
+		if header == true {
+			columns, err = NewColumnMap(line, profile)
+			if err != nil {
+				results.Log().WithFields(log.Fields{"Line Number":i,"Invalid header":line,"err":err}).Info()
+				return err
+			}
 			header = false
 			continue
 		}
 
+		line = columns.Canonical(line)
+
 		// ignore line if there is no rank
 		if (len(line[0]) == 0){
 			continue
@@ -278,41 +640,48 @@ func PostCSV(w http.ResponseWriter, r *http.Request) {
 
 		// if title and/or year are missing - skip the record
 		if (len(line[1]) == 0 || len(line[6]) == 0) {
-			log.WithFields(log.Fields{"Title and/or year are missing":line}).Info()
+			results.Log().WithFields(log.Fields{"Title and/or year are missing":line}).Info()
 			continue
 		}
 
-		// increment total valid records
-		totRecords += 1
-
 		movie, err := ValidateMovie(line)
 
 		if (err!= nil){
-			log.WithFields(log.Fields{"Movie Record Validation Failed for Line":line}).Info()
-			errRecords += 1
-			continue
+			results.Log().WithFields(log.Fields{"Movie Record Validation Failed for Line":line}).Info()
 		}
 
-		// insert to db
-		err = dao.Insert(*movie)
-		if err != nil {
-			log.WithFields(log.Fields{"Insert Error":err}).Info()
-			errRecords += 1
-		}else{ // insert failed
-			insRecords += 1
-		}
+		StoreMovie(movie, err, results)
 	}
 
-	log.WithFields(log.Fields{"Total Records Created":insRecords}).Info()
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
+/******************************************************************************************
+ *
+ * Count a parsed movie record and insert it to the database if it is valid.
+ * Shared by all upload formats so they report the same results.
+ *
+******************************************************************************************/
+func StoreMovie(movie *Movie, err error, results *UploadResults) {
 
-	var uploadresults = new(UploadResults)
-	uploadresults.RecordsRead = totRecords
-	uploadresults.RecordsCreated = insRecords
-	uploadresults.RecordsErrored = errRecords
+	// increment total valid records
+	results.RecordsRead += 1
 
-	json.NewEncoder(w).Encode(uploadresults)
+	if err != nil {
+		results.RecordsErrored += 1
+		results.progress.Record(err)
+		return
+	}
+
+	// insert to db
+	err = results.Catalog().Insert(*movie)
+	if err != nil {
+		results.Log().WithFields(log.Fields{"Insert Error":err}).Info()
+		results.RecordsErrored += 1
+	}else{
+		results.RecordsCreated += 1
+	}
+	results.progress.Record(err)
 }
 
 /******************************************************************************************
@@ -328,18 +697,18 @@ func ValidateMovie(line []string) (*Movie, error){
 	rank, err := strconv.Atoi(line[0])
 
 	if (err!= nil){
-		log.WithFields(log.Fields{"Rank conversion failed":line[0], "err":err}).Info()
+		ingestLog.WithFields(log.Fields{"Rank conversion failed":line[0], "err":err}).Debug()
 		return movie,err
 	}
 
 	// split genre list to array
-	genrelist := strings.Split(strings.ToLower(line[2]), ",")
+	genrelist := NormalizeGenres(strings.Split(line[2], ","))
 
 	// convert year from string to int
 	year, err := strconv.Atoi(line[6])
 
 	if (err!= nil){
-		log.WithFields(log.Fields{"Year conversion failed":line[6]}).Info()
+		ingestLog.WithFields(log.Fields{"Year conversion failed":line[6]}).Debug()
 		return movie,err
 	}
 
@@ -347,7 +716,7 @@ func ValidateMovie(line []string) (*Movie, error){
 	runtime, err := strconv.Atoi(line[7])
 
 	if (err!= nil){
-		log.WithFields(log.Fields{"RuntimeMin conversion failed":line[0]}).Info()
+		ingestLog.WithFields(log.Fields{"RuntimeMin conversion failed":line[0]}).Debug()
 		return movie,err
 	}
 
@@ -355,7 +724,7 @@ func ValidateMovie(line []string) (*Movie, error){
 	rating, err := strconv.ParseFloat(line[8], 64)
 
 	if (err!= nil){
-		log.WithFields(log.Fields{"Rating conversion failed":line[8]}).Info()
+		ingestLog.WithFields(log.Fields{"Rating conversion failed":line[8]}).Debug()
 		return movie,err
 	}
 
@@ -363,7 +732,7 @@ func ValidateMovie(line []string) (*Movie, error){
 	votes, err := strconv.Atoi(line[9])
 
 	if (err!= nil){
-		log.WithFields(log.Fields{"Votes conversion failed":line[9]}).Info()
+		ingestLog.WithFields(log.Fields{"Votes conversion failed":line[9]}).Debug()
 		return movie,err
 	}
 
@@ -377,7 +746,7 @@ func ValidateMovie(line []string) (*Movie, error){
 		revenue, err1 = strconv.ParseFloat(line[10], 64)
 
 		if (err1!= nil){
-			log.WithFields(log.Fields{"Revenue conversion failed":line[10]}).Info()
+			ingestLog.WithFields(log.Fields{"Revenue conversion failed":line[10]}).Debug()
 			return movie,err1
 		}
 	}
@@ -392,7 +761,7 @@ func ValidateMovie(line []string) (*Movie, error){
 		metascore, err1 = strconv.Atoi(line[11])
 
 		if (err1!= nil){
-			log.WithFields(log.Fields{"Metascore conversion failed":line[11]}).Info()
+			ingestLog.WithFields(log.Fields{"Metascore conversion failed":line[11]}).Debug()
 			return movie,err1
 		}
 	}
@@ -422,9 +791,13 @@ func GetMovies(w http.ResponseWriter, r *http.Request) {
 	qparams :=  r.URL.Query()
 
 	genre := ""
-	year := conf.Settings.DefaultYear
+	year := RequestTenant(r).Year()
+	catalog := TenantDAO(r)
 
-	// validate for query params first
+	// query params are checked against the API description by ValidationMiddleware
+	// first, the handler still validates them when called without it
+	validation := startSpan(r, "GetMovies validate")
+	defer validation.End()
 
 	if qparams["genre"] != nil {
 		if len(qparams["genre"][0]) == 0{
@@ -434,6 +807,12 @@ func GetMovies(w http.ResponseWriter, r *http.Request) {
 		genre = strings.ToLower(qparams["genre"][0])
 	}
 
+	// movies watched by the current user are filtered with the watched query param
+	ids, ok := watchedFilter(w, r)
+	if !ok {
+		return
+	}
+
 	// if both year and year range are provided, return an error
 	if (qparams["year"] != nil && (qparams["year_from"] != nil || qparams["year_to"] != nil)) {
         respondWithErrorCode(w, ERR_YEAR_AND_RANGE)
@@ -442,14 +821,14 @@ func GetMovies(w http.ResponseWriter, r *http.Request) {
 
 	// if no year query parameters are provided fallback to default year
 	if (qparams["year"] == nil && qparams["year_from"] == nil && qparams["year_to"] == nil) {
-		movies, err := dao.FindByYear(year, genre)
+		validation.End()
+		movies, err := catalog.FindByYear(year, genre, ids)
 		if err != nil || movies == nil || len(movies) == 0 {
-				log.Info("Responding with No Content")
+				RequestLog(r).Info("Responding with No Content")
 				respondWithErrorCode(w, ERR_NO_CONTENT)
 				return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(movies)
+		respondWithMovies(w, r, movies)
 		return
 	}
 
@@ -460,20 +839,20 @@ func GetMovies(w http.ResponseWriter, r *http.Request) {
 			respondWithErrorCode(w, ERR_YEAR_INVALID)
 			return
 		}else{
-			movies, err := dao.FindByYear(year, genre)
+			validation.End()
+			movies, err := catalog.FindByYear(year, genre, ids)
 			if err != nil || movies == nil || len(movies) == 0 {
-					log.Info("Responding with No Content")
+					RequestLog(r).Info("Responding with No Content")
 					respondWithErrorCode(w, ERR_NO_CONTENT)
 					return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(movies)
+			respondWithMovies(w, r, movies)
 			return
 		}
 
 	}
 
-	// Handle if query param is year range
+	// Handle if query param is year range, both halves are given
 	if qparams["year_from"] != nil && qparams["year_to"] != nil {
 		year_from, err := IsValidYear(qparams["year_from"][0])
 		if err != nil {
@@ -490,20 +869,20 @@ func GetMovies(w http.ResponseWriter, r *http.Request) {
 			respondWithErrorCode(w, ERR_YEAR_RANGE_INVALID)
 			return
 		}
-		movies, err := dao.FindByYearRange(year_from, year_to, genre)
+		validation.End()
+		movies, err := catalog.FindByYearRange(year_from, year_to, genre, ids)
 		if err != nil || movies == nil || len(movies) == 0 {
 				respondWithErrorCode(w, ERR_NO_CONTENT)
 				return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(movies)
+		respondWithMovies(w, r, movies)
 		return
 
 	}else{
+		// only one half of the year range is given
 		respondWithErrorCode(w, ERR_YEAR_RANGE_INVALID)
 		return
 	}
-
 }
 
 /******************************************************************************************
@@ -523,3 +902,20 @@ func IsValidYear(year string) (int,error){
 	}
 	return iyear,err
 }
+
+/******************************************************************************************
+ *
+ * Normalize Genres - every upload format stores genres in lower case without spaces
+ * around them and without empty ones, so the genre query matches them all
+ *
+******************************************************************************************/
+func NormalizeGenres(genres []string) []string{
+	genrelist := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		genre = strings.ToLower(strings.TrimSpace(genre))
+		if len(genre) > 0 {
+			genrelist = append(genrelist, genre)
+		}
+	}
+	return genrelist
+}
@@ -44,20 +44,16 @@ func Router() *mux.Router{
 
 	dao_test.Server = "localhost"
     dao_test.Database =  "MoviesDB"
-    dao_test.Connect()
+    if err := dao_test.Connect(); err != nil {
+		log.Fatal(err)
+	}
 
 
 	// Disable logging
 	discard := io.MultiWriter()
 	log.SetOutput(discard)
 
-	router := mux.NewRouter()
-    router.HandleFunc("/imdb/version", GetVersion).Methods("GET") // get version
-    router.HandleFunc("/imdb/uploadmovies", PostCSV).Methods("POST") // post movie uploads
-    router.HandleFunc("/imdb/movies", GetMovies).Methods("GET") // get movies
-    router.HandleFunc("/imdb/endpoints", GetEndpoints).Methods("GET") // get Rest Endpoint
-
-	return router
+	return NewRouter()
 
 }
 /******************************************************************************************
@@ -312,3 +308,134 @@ func TestGetByChronoOrder(t *testing.T) {
 }
 
 
+
+/******************************************************************************************
+ *
+ * Test for successful record creation from a gzip compressed CSV
+ *
+*******************************************************************************************/
+func TestPostCSVGzip(t *testing.T) {
+	dao_test.Clean()
+	path := "./test/passlist.csv.gz"
+	paramName := "file"
+	req,_ := SetUploadRequest("/imdb/uploadmovies",path,paramName,true)
+
+	resp := httptest.NewRecorder()
+	Router().ServeHTTP(resp, req)
+
+	var jres = new(UploadResults)
+	err := json.NewDecoder(resp.Body).Decode(jres)
+
+	if (err != nil || resp.Code != 200 || jres.RecordsCreated != 5){
+		t.Errorf("TestPostCSVGzip Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test for per file results of a zip archive with multiple CSVs
+ *
+*******************************************************************************************/
+func TestPostCSVZip(t *testing.T) {
+	dao_test.Clean()
+	path := "./test/passlist.zip"
+	paramName := "file"
+	req,_ := SetUploadRequest("/imdb/uploadmovies",path,paramName,true)
+
+	resp := httptest.NewRecorder()
+	Router().ServeHTTP(resp, req)
+
+	var jres = new(UploadResults)
+	err := json.NewDecoder(resp.Body).Decode(jres)
+
+	if (err != nil || resp.Code != 200 || len(jres.Files) != 2){
+		t.Errorf("TestPostCSVZip Failed")
+		return
+	}
+
+	// the second file holds the same records and must be reported as duplicates
+	if (jres.Files[0].RecordsCreated != 5 || jres.Files[1].RecordsErrored != 5 ||
+		jres.RecordsRead != 10){
+		t.Errorf("TestPostCSVZip Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test for ERR_FILE_TOO_BIG when the decompressed size is over the limit
+ *
+*******************************************************************************************/
+func TestPostCSVGzipTooLarge(t *testing.T) {
+	path := "./test/largefile.csv.gz"
+	paramName := "file"
+	req,_ := SetUploadRequest("/imdb/uploadmovies",path,paramName,true)
+
+	resp := httptest.NewRecorder()
+	Router().ServeHTTP(resp, req)
+
+	var errjson = new(ErrorJSON)
+	err := json.NewDecoder(resp.Body).Decode(errjson)
+
+	if (err != nil ||
+		resp.Code != 400 ||
+		!strings.Contains(errjson.ErrorMsg, "File is too large. Maximum upload size")){
+		t.Errorf("TestPostCSVGzipTooLarge Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Setup Upload Request with the file as request body
+ *
+*******************************************************************************************/
+func SetBodyUploadRequest(path string, contentType string)(*http.Request, error){
+	fileContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("POST","/imdb/uploadmovies", bytes.NewReader(fileContents))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Content-Type", contentType)
+	return request, nil
+}
+
+/******************************************************************************************
+ *
+ * Test for successful record creation from a JSON array
+ *
+*******************************************************************************************/
+func TestPostJSONCreate(t *testing.T) {
+	dao_test.Clean()
+	req,_ := SetBodyUploadRequest("./test/passlist.json", "application/json")
+
+	resp := httptest.NewRecorder()
+	Router().ServeHTTP(resp, req)
+
+	var jres = new(UploadResults)
+	err := json.NewDecoder(resp.Body).Decode(jres)
+
+	if (err != nil || resp.Code != 200 || jres.RecordsCreated != 5){
+		t.Errorf("TestPostJSONCreate Failed")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test for duplicate records from newline delimited JSON
+ *
+*******************************************************************************************/
+func TestPostNDJSONDuplicate(t *testing.T) {
+	req,_ := SetBodyUploadRequest("./test/passlist.ndjson", "application/x-ndjson")
+
+	resp := httptest.NewRecorder()
+	Router().ServeHTTP(resp, req)
+
+	var jres = new(UploadResults)
+	err := json.NewDecoder(resp.Body).Decode(jres)
+
+	if (err != nil || resp.Code != 200 || jres.RecordsErrored != 5){
+		t.Errorf("TestPostNDJSONDuplicate Failed")
+	}
+}
@@ -0,0 +1,536 @@
+/******************************************************************************
+ * \file        reviews.go
+ *
+ * \brief       GO File that has REST functions for movie reviews and their moderation
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReviewOptions struct for review settings in config file
+type ReviewOptions struct {
+	BannedWords []string `toml:"bannedwords"`
+	MaxLength   int      `toml:"maxlength"`
+	PageSize    int      `toml:"pagesize"`
+}
+
+// Review states, reviews are shown once approved by an administrator
+const (
+	REVIEW_PENDING     = "pending"
+	REVIEW_APPROVED    = "approved"
+	REVIEW_REJECTED    = "rejected"
+	REVIEWS_COLLECTION = "reviews"
+	MAX_PAGE_SIZE      = 100
+)
+
+// Review sort orders
+var reviewSorts = map[string][]string{
+	"helpful": {"-helpful", "-created"},
+	"newest":  {"-created"},
+	"oldest":  {"created"},
+}
+
+// Review struct for a review of a movie
+type Review struct {
+	ID           bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	Tenant       string        `bson:"tenant" json:"-"`
+	MovieID      bson.ObjectId `bson:"movie_id" json:"movie_id"`
+	Author       string        `bson:"author" json:"author"`
+	Title        string        `bson:"title" json:"title"`
+	Text         string        `bson:"text" json:"text"`
+	Status       string        `bson:"status" json:"status"`
+	Flagged      bool          `bson:"flagged" json:"flagged"`
+	FlaggedWords []string      `bson:"flagged_words,omitempty" json:"flagged_words,omitempty"`
+	Helpful      int           `bson:"helpful" json:"helpful"`
+	HelpfulBy    []string      `bson:"helpful_by,omitempty" json:"-"`
+	Created      time.Time     `bson:"created" json:"created"`
+	Updated      time.Time     `bson:"updated" json:"updated"`
+	ModeratedBy  string        `bson:"moderated_by,omitempty" json:"moderated_by,omitempty"`
+	Reason       string        `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// ReviewPage struct for a page of reviews
+type ReviewPage struct {
+	Reviews []Review `json:"reviews"`
+	Page    int      `json:"page"`
+	PerPage int      `json:"per_page"`
+	Total   int      `json:"total"`
+}
+
+/******************************************************************************************
+ *
+ * Review Moderation - checks submitted reviews against the banned words
+ *
+*******************************************************************************************/
+type ReviewModeration struct {
+	MaxLength int
+	PageSize  int
+	banned    *regexp.Regexp
+}
+
+// Review Moderation, replaced from config file on start
+var moderation = NewReviewModeration(ReviewOptions{})
+
+/******************************************************************************************
+ *
+ * Create the Review Moderation from config, banned words match whole words in any case
+ *
+*******************************************************************************************/
+func NewReviewModeration(options ReviewOptions) *ReviewModeration {
+	m := &ReviewModeration{MaxLength: options.MaxLength, PageSize: options.PageSize}
+	if m.MaxLength <= 0 {
+		m.MaxLength = 10000
+	}
+	if m.PageSize <= 0 || m.PageSize > MAX_PAGE_SIZE {
+		m.PageSize = 20
+	}
+
+	words := []string{}
+	for _, word := range options.BannedWords {
+		if word = strings.TrimSpace(word); len(word) > 0 {
+			words = append(words, regexp.QuoteMeta(strings.ToLower(word)))
+		}
+	}
+	if len(words) > 0 {
+		m.banned = regexp.MustCompile(`(?i)\b(` + strings.Join(words, "|") + `)\b`)
+	}
+	return m
+}
+
+/******************************************************************************************
+ *
+ * Banned words used in a review, each word once in lower case
+ *
+*******************************************************************************************/
+func (m *ReviewModeration) BannedWords(text string) []string {
+	if m.banned == nil {
+		return nil
+	}
+	var found []string
+	seen := make(map[string]bool)
+	for _, match := range m.banned.FindAllString(text, -1) {
+		word := strings.ToLower(match)
+		if !seen[word] {
+			seen[word] = true
+			found = append(found, word)
+		}
+	}
+	return found
+}
+
+/******************************************************************************************
+ *
+ * Decode a submitted review, it is pending moderation and flagged if it uses banned
+ * words
+ *
+*******************************************************************************************/
+func (m *ReviewModeration) decodeReview(w http.ResponseWriter, r *http.Request) (*Review, bool) {
+	var review Review
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		respondWithErrorCode(w, ERR_REVIEW_INVALID)
+		return nil, false
+	}
+	review.Title = strings.TrimSpace(review.Title)
+	review.Text = strings.TrimSpace(review.Text)
+	if len(review.Text) == 0 || utf8.RuneCountInString(review.Title)+utf8.RuneCountInString(review.Text) > m.MaxLength {
+		respondWithErrorDetail(w, ERR_REVIEW_INVALID, fmt.Sprintf("A review has a text and at most %d characters", m.MaxLength))
+		return nil, false
+	}
+
+	review.Status = REVIEW_PENDING
+	review.FlaggedWords = m.BannedWords(review.Title + "\n" + review.Text)
+	review.Flagged = len(review.FlaggedWords) > 0
+	return &review, true
+}
+
+/******************************************************************************************
+ *
+ * Parse the page, per_page and sort query parameters
+ *
+*******************************************************************************************/
+func (m *ReviewModeration) pagination(w http.ResponseWriter, r *http.Request) (page int, perPage int, sort []string, ok bool) {
+	qparams := r.URL.Query()
+	page, perPage, sort = 1, m.PageSize, reviewSorts["helpful"]
+
+	var err error
+	if value := qparams.Get("page"); len(value) > 0 {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			respondWithErrorCode(w, ERR_PAGINATION_INVALID)
+			return 0, 0, nil, false
+		}
+	}
+	if value := qparams.Get("per_page"); len(value) > 0 {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 || perPage > MAX_PAGE_SIZE {
+			respondWithErrorCode(w, ERR_PAGINATION_INVALID)
+			return 0, 0, nil, false
+		}
+	}
+	if value := qparams.Get("sort"); len(value) > 0 {
+		if sort = reviewSorts[value]; sort == nil {
+			respondWithErrorCode(w, ERR_PAGINATION_INVALID)
+			return 0, 0, nil, false
+		}
+	}
+	return page, perPage, sort, true
+}
+
+// reviewStatusFilter is the status query parameter of administrators, approved otherwise
+func reviewStatusFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	value := r.URL.Query().Get("status")
+	switch value {
+	case "":
+		return REVIEW_APPROVED, true
+	case REVIEW_PENDING, REVIEW_APPROVED, REVIEW_REJECTED:
+		if isAdmin(r) {
+			return value, true
+		}
+		return REVIEW_APPROVED, true
+	}
+	respondWithErrorCode(w, ERR_PAGINATION_INVALID)
+	return "", false
+}
+
+// reviewAuthor is the current user, needed to write reviews
+func reviewAuthor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	author := CurrentUser(r)
+	if len(author) == 0 {
+		respondWithErrorCode(w, ERR_USER_INVALID)
+		return "", false
+	}
+	return author, true
+}
+
+// isAdmin is true for administrators and when authentication is disabled
+func isAdmin(r *http.Request) bool {
+	principal := RequestPrincipal(r)
+	return principal == nil || principal.Role >= ROLE_ADMIN
+}
+
+/******************************************************************************************
+ *
+ * Find the review of the path of a request, with owner only the author and
+ * administrators may access it
+ *
+*******************************************************************************************/
+func pathReview(w http.ResponseWriter, r *http.Request, owner bool) (*Review, bool) {
+	movieID, ok := pathID(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, ok := pathVarID(w, r, "rid")
+	if !ok {
+		return nil, false
+	}
+
+	review, err := TenantDAO(r).FindReview(id)
+	if err == mgo.ErrNotFound || (err == nil && review.MovieID != movieID) {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return nil, false
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return nil, false
+	}
+	if owner && review.Author != CurrentUser(r) && !isAdmin(r) {
+		respondWithErrorCode(w, ERR_FORBIDDEN)
+		return nil, false
+	}
+	return review, true
+}
+
+/******************************************************************************************
+ *
+ * Get the approved reviews of a movie, administrators can ask for a status
+ *
+*******************************************************************************************/
+func GetReviews(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	page, perPage, sort, ok := moderation.pagination(w, r)
+	if !ok {
+		return
+	}
+	status, ok := reviewStatusFilter(w, r)
+	if !ok {
+		return
+	}
+
+	reviews, total, err := TenantDAO(r).FindReviews(bson.M{"movie_id": id, "status": status}, sort, page, perPage)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ReviewPage{Reviews: reviews, Page: page, PerPage: perPage, Total: total})
+}
+
+/******************************************************************************************
+ *
+ * Create a review of a movie, pending moderation
+ *
+*******************************************************************************************/
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "CreateReview"}).Info()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	author, ok := reviewAuthor(w, r)
+	if !ok {
+		return
+	}
+	review, ok := moderation.decodeReview(w, r)
+	if !ok {
+		return
+	}
+	if !movieExists(w, r, id) {
+		return
+	}
+
+	now := time.Now().UTC()
+	review.ID = bson.NewObjectId()
+	review.Tenant = RequestTenant(r).Name
+	review.MovieID = id
+	review.Author = author
+	review.Helpful = 0
+	review.Created, review.Updated = now, now
+	if err := TenantDAO(r).InsertReview(*review); err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	if review.Flagged {
+		RequestLog(r).WithFields(log.Fields{"Review": review.ID.Hex(), "Banned Words": review.FlaggedWords}).Info("Review flagged")
+	}
+	respondWithJSON(w, http.StatusCreated, review)
+}
+
+/******************************************************************************************
+ *
+ * Edit a review, the edited review is moderated again
+ *
+*******************************************************************************************/
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "UpdateReview"}).Info()
+
+	review, ok := pathReview(w, r, true)
+	if !ok {
+		return
+	}
+	edited, ok := moderation.decodeReview(w, r)
+	if !ok {
+		return
+	}
+
+	review.Title, review.Text = edited.Title, edited.Text
+	review.Status, review.Flagged, review.FlaggedWords = edited.Status, edited.Flagged, edited.FlaggedWords
+	review.ModeratedBy, review.Reason = "", ""
+	review.Updated = time.Now().UTC()
+	if err := TenantDAO(r).UpdateReview(*review); err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, review)
+}
+
+/******************************************************************************************
+ *
+ * Delete a review
+ *
+*******************************************************************************************/
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "DeleteReview"}).Info()
+
+	review, ok := pathReview(w, r, true)
+	if !ok {
+		return
+	}
+	if err := TenantDAO(r).DeleteReview(review.ID); err != nil && err != mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************************
+ *
+ * Mark an approved review as helpful, once per user
+ *
+*******************************************************************************************/
+func PostReviewHelpful(w http.ResponseWriter, r *http.Request) {
+	review, ok := pathReview(w, r, false)
+	if !ok {
+		return
+	}
+	user, ok := reviewAuthor(w, r)
+	if !ok {
+		return
+	}
+	if review.Status != REVIEW_APPROVED {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	}
+
+	if err := TenantDAO(r).VoteReviewHelpful(review.ID, user); err == nil {
+		review.Helpful += 1
+	} else if err != mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, review)
+}
+
+/******************************************************************************************
+ *
+ * Moderation queue of reviews pending approval, flagged reviews first and then by age
+ *
+*******************************************************************************************/
+func GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	page, perPage, _, ok := moderation.pagination(w, r)
+	if !ok {
+		return
+	}
+	query := bson.M{"status": REVIEW_PENDING}
+	if value := r.URL.Query().Get("flagged"); len(value) > 0 {
+		flagged, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithErrorCode(w, ERR_PAGINATION_INVALID)
+			return
+		}
+		query["flagged"] = flagged
+	}
+
+	reviews, total, err := TenantDAO(r).FindReviews(query, []string{"-flagged", "created"}, page, perPage)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ReviewPage{Reviews: reviews, Page: page, PerPage: perPage, Total: total})
+}
+
+/******************************************************************************************
+ *
+ * Approve or reject a review with an optional reason
+ *
+*******************************************************************************************/
+func ModerateReview(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "ModerateReview"}).Info()
+
+	id, ok := pathVarID(w, r, "rid")
+	if !ok {
+		return
+	}
+	var decision struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil ||
+		(decision.Status != REVIEW_APPROVED && decision.Status != REVIEW_REJECTED) {
+		respondWithErrorCode(w, ERR_REVIEW_STATUS_INVALID)
+		return
+	}
+
+	moderator := CurrentUser(r)
+	review, err := TenantDAO(r).ModerateReview(id, decision.Status, moderator, strings.TrimSpace(decision.Reason))
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	RequestLog(r).WithFields(log.Fields{"Review": id.Hex(), "Status": decision.Status}).Info("Review moderated")
+	respondWithJSON(w, http.StatusOK, review)
+}
+
+/******************************************************************************************
+ *
+ * Review Database Access, reviews are scoped to the tenant of the DAO
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) InsertReview(review Review) error {
+	done := m.operation("InsertReview", REVIEWS_COLLECTION)
+	return done(db().C(REVIEWS_COLLECTION).Insert(&review))
+}
+
+func (m *MoviesDAO) FindReview(id bson.ObjectId) (*Review, error) {
+	var review Review
+	done := m.operation("FindReview", REVIEWS_COLLECTION)
+	err := done(db().C(REVIEWS_COLLECTION).Find(bson.M{"_id": id, "tenant": tenantFilter(m.Tenant)}).One(&review))
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (m *MoviesDAO) FindReviews(query bson.M, sort []string, page int, perPage int) ([]Review, int, error) {
+	query["tenant"] = tenantFilter(m.Tenant)
+	done := m.operation("FindReviews", REVIEWS_COLLECTION)
+	total, err := db().C(REVIEWS_COLLECTION).Find(query).Count()
+	if err != nil {
+		return nil, 0, done(err)
+	}
+	list := []Review{}
+	err = db().C(REVIEWS_COLLECTION).Find(query).
+		Sort(sort...).
+		Skip((page - 1) * perPage).
+		Limit(perPage).
+		All(&list)
+	return list, total, done(err)
+}
+
+func (m *MoviesDAO) UpdateReview(review Review) error {
+	done := m.operation("UpdateReview", REVIEWS_COLLECTION)
+	return done(db().C(REVIEWS_COLLECTION).UpdateId(review.ID, bson.M{
+		"$set": bson.M{"title": review.Title, "text": review.Text, "status": review.Status,
+			"flagged": review.Flagged, "flagged_words": review.FlaggedWords, "updated": review.Updated},
+		"$unset": bson.M{"moderated_by": "", "reason": ""},
+	}))
+}
+
+func (m *MoviesDAO) DeleteReview(id bson.ObjectId) error {
+	done := m.operation("DeleteReview", REVIEWS_COLLECTION)
+	return done(db().C(REVIEWS_COLLECTION).Remove(bson.M{"_id": id, "tenant": tenantFilter(m.Tenant)}))
+}
+
+// VoteReviewHelpful returns ErrNotFound if the user already voted
+func (m *MoviesDAO) VoteReviewHelpful(id bson.ObjectId, user string) error {
+	done := m.operation("VoteReviewHelpful", REVIEWS_COLLECTION)
+	return done(db().C(REVIEWS_COLLECTION).Update(bson.M{"_id": id, "helpful_by": bson.M{"$ne": user}},
+		bson.M{"$addToSet": bson.M{"helpful_by": user}, "$inc": bson.M{"helpful": 1}}))
+}
+
+func (m *MoviesDAO) ModerateReview(id bson.ObjectId, status string, moderator string, reason string) (*Review, error) {
+	set := bson.M{"status": status, "moderated_by": moderator, "reason": reason, "updated": time.Now().UTC()}
+	var review Review
+	done := m.operation("ModerateReview", REVIEWS_COLLECTION)
+	_, err := db().C(REVIEWS_COLLECTION).Find(bson.M{"_id": id, "tenant": tenantFilter(m.Tenant)}).
+		Apply(mgo.Change{Update: bson.M{"$set": set}, ReturnNew: true}, &review)
+	if err := done(err); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// EnsureReviewIndexes for listing the reviews of a movie and the moderation queue
+func EnsureReviewIndexes() {
+	db().C(REVIEWS_COLLECTION).EnsureIndex(mgo.Index{Key: []string{"tenant", "movie_id", "status", "-helpful"}})
+	db().C(REVIEWS_COLLECTION).EnsureIndex(mgo.Index{Key: []string{"tenant", "status", "-flagged", "created"}})
+}
@@ -0,0 +1,124 @@
+/******************************************************************************
+ * \file        reviews_test.go
+ *
+ * \brief       GO File that tests review validation, banned words and moderation access
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReviewBannedWords(t *testing.T) {
+	m := NewReviewModeration(ReviewOptions{BannedWords: []string{"Spoiler", "darn it", " "}})
+
+	words := m.BannedWords("SPOILER: the butler did it. Darn it, spoiler again")
+	if !reflect.DeepEqual(words, []string{"spoiler", "darn it"}) {
+		t.Errorf("Expected each banned word once, got %v", words)
+	}
+	// only whole words are banned
+	if words := m.BannedWords("No spoilers here"); len(words) != 0 {
+		t.Errorf("Expected no banned words, got %v", words)
+	}
+	if words := NewReviewModeration(ReviewOptions{}).BannedWords("spoiler"); words != nil {
+		t.Errorf("Expected no banned words without a filter, got %v", words)
+	}
+}
+
+func TestDecodeReview(t *testing.T) {
+	m := NewReviewModeration(ReviewOptions{BannedWords: []string{"spoiler"}, MaxLength: 20})
+
+	req, _ := http.NewRequest("POST", "/imdb/movies/id/reviews", strings.NewReader(`{"text":" Spoiler ahead ","status":"approved","helpful":99}`))
+	review, ok := m.decodeReview(httptest.NewRecorder(), req)
+	if !ok || review.Text != "Spoiler ahead" || review.Status != REVIEW_PENDING || !review.Flagged {
+		t.Errorf("Expected a flagged pending review, got %+v", review)
+	}
+
+	for _, body := range []string{`{"text":"   "}`, `{"text":"far too long for the limit"}`, `not json`} {
+		req, _ := http.NewRequest("POST", "/imdb/movies/id/reviews", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		if _, ok := m.decodeReview(response, req); ok {
+			t.Errorf("Expected %s to be rejected", body)
+		}
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestReviewPagination(t *testing.T) {
+	m := NewReviewModeration(ReviewOptions{PageSize: 5})
+
+	req, _ := http.NewRequest("GET", "/imdb/movies/id/reviews", nil)
+	page, perPage, sort, ok := m.pagination(httptest.NewRecorder(), req)
+	if !ok || page != 1 || perPage != 5 || sort[0] != "-helpful" {
+		t.Errorf("Expected the defaults, got %d %d %v", page, perPage, sort)
+	}
+
+	req, _ = http.NewRequest("GET", "/imdb/movies/id/reviews?page=3&per_page=50&sort=newest", nil)
+	page, perPage, sort, ok = m.pagination(httptest.NewRecorder(), req)
+	if !ok || page != 3 || perPage != 50 || sort[0] != "-created" {
+		t.Errorf("Expected the query parameters, got %d %d %v", page, perPage, sort)
+	}
+
+	for _, query := range []string{"page=0", "per_page=101", "per_page=x", "sort=rating"} {
+		req, _ := http.NewRequest("GET", "/imdb/movies/id/reviews?"+query, nil)
+		response := httptest.NewRecorder()
+		if _, _, _, ok := m.pagination(response, req); ok {
+			t.Errorf("Expected %s to be rejected", query)
+		}
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestReviewStatusFilter(t *testing.T) {
+	cases := []struct {
+		query     string
+		principal *Principal
+		status    string
+		code      int
+	}{
+		{"", &Principal{Subject: "jdoe", Role: ROLE_READER}, REVIEW_APPROVED, http.StatusOK},
+		{"status=pending", &Principal{Subject: "jdoe", Role: ROLE_READER}, REVIEW_APPROVED, http.StatusOK},
+		{"status=pending", &Principal{Subject: "admin", Role: ROLE_ADMIN}, REVIEW_PENDING, http.StatusOK},
+		{"status=rejected", &Principal{Subject: "admin", Role: ROLE_ADMIN}, REVIEW_REJECTED, http.StatusOK},
+		{"status=rejectd", &Principal{Subject: "admin", Role: ROLE_ADMIN}, "", http.StatusBadRequest},
+		{"status=Pending", &Principal{Subject: "jdoe", Role: ROLE_READER}, "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/imdb/movies/id/reviews?"+c.query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), principalKey{}, c.principal))
+		response := httptest.NewRecorder()
+		status, ok := reviewStatusFilter(response, req)
+		if status != c.status || ok != (c.code == http.StatusOK) {
+			t.Errorf("%s: expected status %q, got %q", c.query, c.status, status)
+		}
+		checkResponseCode(t, c.code, response.Code)
+	}
+
+	// the validator rejects other values before the handler
+	_, errs := serveValidated(t, "GET", "/imdb/movies/5b1e4b0a9d1f2a3b4c5d6e7f/reviews?status=rejectd", "")
+	if errs.Error != ErrorMsg(ERR_PAGINATION_INVALID) || len(errs.Errors) != 1 || errs.Errors[0].Name != "status" {
+		t.Errorf("Expected the status to be rejected, got %+v", errs)
+	}
+}
+
+func TestModerationAccess(t *testing.T) {
+	withAPIKeys(t)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/reviews/moderation", "uploader-key").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("PUT", "/imdb/reviews/5b1e4b0a9d1f2a3b4c5d6e7f/moderation", "reader-key").Code)
+
+	// the decision is checked before the review is looked up
+	req, _ := http.NewRequest("PUT", "/imdb/reviews/5b1e4b0a9d1f2a3b4c5d6e7f/moderation", strings.NewReader(`{"status":"maybe"}`))
+	req.Header.Set(HEADER_API_KEY, "admin-key")
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
@@ -0,0 +1,149 @@
+/******************************************************************************
+ * \file        routes.go
+ *
+ * \brief       GO File that has the REST Endpoints and the role each requires
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Route struct for a REST Endpoint, Tenant endpoints work on the catalog of a tenant
+type Route struct {
+	Name    string
+	Method  string
+	Path    string
+	Role    Role
+	Tenant  bool
+	Handler http.HandlerFunc
+}
+
+/******************************************************************************************
+ *
+ * REST Endpoints, the Role is the least role allowed to call the endpoint
+ *
+*******************************************************************************************/
+func Routes() []Route {
+	return []Route{
+		{"GetVersion", "GET", "/imdb/version", ROLE_PUBLIC, false, GetVersion},
+		{"PostCSV", "POST", "/imdb/uploadmovies", ROLE_UPLOADER, true, PostCSV},
+		{"GetMovies", "GET", "/imdb/movies", ROLE_READER, true, GetMovies},
+		{"CreateMovie", "POST", "/imdb/movies", ROLE_UPLOADER, true, CreateMovie},
+		{"CleanMovies", "DELETE", "/imdb/movies", ROLE_ADMIN, true, CleanMovies},
+		{"GetMovie", "GET", "/imdb/movies/{id}", ROLE_READER, true, GetMovie},
+		{"UpdateMovie", "PUT", "/imdb/movies/{id}", ROLE_UPLOADER, true, UpdateMovie},
+		{"DeleteMovie", "DELETE", "/imdb/movies/{id}", ROLE_ADMIN, true, DeleteMovie},
+		{"GetReviews", "GET", "/imdb/movies/{id}/reviews", ROLE_READER, true, GetReviews},
+		{"CreateReview", "POST", "/imdb/movies/{id}/reviews", ROLE_READER, true, CreateReview},
+		{"UpdateReview", "PUT", "/imdb/movies/{id}/reviews/{rid}", ROLE_READER, true, UpdateReview},
+		{"DeleteReview", "DELETE", "/imdb/movies/{id}/reviews/{rid}", ROLE_READER, true, DeleteReview},
+		{"PostReviewHelpful", "POST", "/imdb/movies/{id}/reviews/{rid}/helpful", ROLE_READER, true, PostReviewHelpful},
+		{"GetModerationQueue", "GET", "/imdb/reviews/moderation", ROLE_ADMIN, true, GetModerationQueue},
+		{"ModerateReview", "PUT", "/imdb/reviews/{rid}/moderation", ROLE_ADMIN, true, ModerateReview},
+		{"GetUploads", "GET", "/imdb/uploads", ROLE_UPLOADER, true, GetUploads},
+		{"GetUploadEvents", "GET", "/imdb/uploads/{id}/events", ROLE_UPLOADER, true, GetUploadEvents},
+		{"GetWatchlist", "GET", "/imdb/users/{uid}/watchlist", ROLE_READER, true, GetWatchlist},
+		{"PutWatchlistMovie", "PUT", "/imdb/users/{uid}/watchlist/{id}", ROLE_READER, true, PutWatchlistMovie},
+		{"DeleteWatchlistMovie", "DELETE", "/imdb/users/{uid}/watchlist/{id}", ROLE_READER, true, DeleteWatchlistMovie},
+		{"GetUserRatings", "GET", "/imdb/users/{uid}/ratings", ROLE_READER, true, GetUserRatings},
+		{"PutUserRating", "PUT", "/imdb/users/{uid}/ratings/{id}", ROLE_READER, true, PutUserRating},
+		{"DeleteUserRating", "DELETE", "/imdb/users/{uid}/ratings/{id}", ROLE_READER, true, DeleteUserRating},
+		{"GetWebhooks", "GET", "/imdb/webhooks", ROLE_ADMIN, true, GetWebhooks},
+		{"CreateWebhook", "POST", "/imdb/webhooks", ROLE_ADMIN, true, CreateWebhook},
+		{"DeleteWebhook", "DELETE", "/imdb/webhooks/{id}", ROLE_ADMIN, true, DeleteWebhook},
+		{"GetWebhookDeliveries", "GET", "/imdb/webhooks/{id}/deliveries", ROLE_ADMIN, true, GetWebhookDeliveries},
+		{"GetTenants", "GET", "/imdb/tenants", ROLE_ADMIN, false, GetTenants},
+		{"CreateTenant", "POST", "/imdb/tenants", ROLE_ADMIN, false, CreateTenant},
+		{"DeleteTenant", "DELETE", "/imdb/tenants/{tenant}", ROLE_ADMIN, false, DeleteTenant},
+		{"GetEndpoints", "GET", "/imdb/endpoints", ROLE_PUBLIC, false, GetEndpoints},
+		{"GetOpenAPI", "GET", "/imdb/openapi.json", ROLE_PUBLIC, false, GetOpenAPI},
+		{"GetOpenAPIYAML", "GET", "/imdb/openapi.yaml", ROLE_PUBLIC, false, GetOpenAPIYAML},
+		{"GetDocs", "GET", "/imdb/docs", ROLE_PUBLIC, false, GetDocs},
+		{"GetDocsAsset", "GET", "/imdb/docs/{file}", ROLE_PUBLIC, false, GetDocsAsset},
+		{"GetLogLevels", "GET", "/imdb/logging", ROLE_ADMIN, false, GetLogLevels},
+		{"PutLogLevel", "PUT", "/imdb/logging", ROLE_ADMIN, false, PutLogLevel},
+		{"GetHealth", "GET", "/imdb/healthz", ROLE_PUBLIC, false, GetHealth},
+		{"GetReady", "GET", "/imdb/readyz", ROLE_PUBLIC, false, GetReady},
+		{"GetMetrics", "GET", "/metrics", ROLE_PUBLIC, false, promhttp.Handler().ServeHTTP},
+	}
+}
+
+/******************************************************************************************
+ *
+ * Build HTTP Router with all REST Endpoints. Tenant endpoints are also served under
+ * /imdb/tenants/{tenant}, e.g. /imdb/tenants/team-a/movies.
+ *
+*******************************************************************************************/
+func NewRouter() *mux.Router {
+	router := mux.NewRouter()
+	roles := make(map[string]Role)
+	scoped := make(map[string]bool)
+	public := make(map[string]bool)
+	for _, route := range Routes() {
+		router.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.Name)
+		roles[route.Name] = route.Role
+		scoped[route.Name] = route.Tenant
+		public[route.Name] = route.Role == ROLE_PUBLIC
+	}
+	// methods of each path in the order of the routes, for OPTIONS requests
+	var paths []string
+	methods := make(map[string][]string)
+	addPath := func(path string, method string) {
+		if _, ok := methods[path]; !ok {
+			paths = append(paths, path)
+		}
+		methods[path] = append(methods[path], method)
+	}
+	for _, route := range Routes() {
+		addPath(route.Path, route.Method)
+	}
+	for _, route := range Routes() {
+		if route.Tenant {
+			path := TENANT_PATH_PREFIX + strings.TrimPrefix(route.Path, "/imdb")
+			router.HandleFunc(path, route.Handler).Methods(route.Method).Name(route.Name + TENANT_ROUTE_SUFFIX)
+			addPath(path, route.Method)
+		}
+	}
+	// OPTIONS and CORS preflight requests are answered for every path
+	for _, path := range paths {
+		router.HandleFunc(path, optionsHandler(methods[path])).Methods(http.MethodOptions).Name(PREFLIGHT_ROUTE)
+	}
+	roles[PREFLIGHT_ROUTE] = ROLE_PUBLIC
+	public[PREFLIGHT_ROUTE] = true
+	// requests without a route are logged too
+	router.NotFoundHandler = RequestIDMiddleware(AccessLogMiddleware(http.NotFoundHandler()))
+	router.MethodNotAllowedHandler = RequestIDMiddleware(AccessLogMiddleware(http.HandlerFunc(methodNotAllowed)))
+
+	router.Use(RequestIDMiddleware, AccessLogMiddleware, TracingMiddleware, MetricsMiddleware, CORSMiddleware, DatabaseMiddleware(public), AuthMiddleware(roles), TenantMiddleware(scoped), RateLimitMiddleware, ValidationMiddleware)
+	return router
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// Suffix of the names of tenant path prefixed routes
+const TENANT_ROUTE_SUFFIX = "ForTenant"
+
+/******************************************************************************************
+ *
+ * Name of the route of a request, tenant path prefixed routes have the name of the
+ * route they prefix
+ *
+*******************************************************************************************/
+func RouteName(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	return strings.TrimSuffix(route.GetName(), TENANT_ROUTE_SUFFIX)
+}
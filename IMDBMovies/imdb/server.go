@@ -0,0 +1,144 @@
+/******************************************************************************
+ * \file        server.go
+ *
+ * \brief       GO File that runs the HTTP server and shuts it down gracefully
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerOptions struct for HTTP server timeouts in config file
+type ServerOptions struct {
+	ReadHeaderTimeout string `toml:"readheadertimeout"`
+	ReadTimeout       string `toml:"readtimeout"`
+	WriteTimeout      string `toml:"writetimeout"`
+	IdleTimeout       string `toml:"idletimeout"`
+	ShutdownTimeout   string `toml:"shutdowntimeout"`
+}
+
+/******************************************************************************************
+ *
+ * Create the HTTP server from config
+ *
+*******************************************************************************************/
+func NewServer(addr string, handler http.Handler, options ServerOptions) (*http.Server, error) {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	timeouts := []struct {
+		name   string
+		value  string
+		def    time.Duration
+		target *time.Duration
+	}{
+		{"readheadertimeout", options.ReadHeaderTimeout, 10 * time.Second, &server.ReadHeaderTimeout},
+		{"readtimeout", options.ReadTimeout, 5 * time.Minute, &server.ReadTimeout},
+		{"writetimeout", options.WriteTimeout, 5 * time.Minute, &server.WriteTimeout},
+		{"idletimeout", options.IdleTimeout, 2 * time.Minute, &server.IdleTimeout},
+	}
+	for _, timeout := range timeouts {
+		// zero turns a timeout off
+		value, err := parseDurationOption(timeout.value, timeout.def)
+		if err == nil && value < 0 {
+			err = errNegative
+		}
+		if err != nil {
+			return nil, fmt.Errorf("app: invalid %s: %v", timeout.name, err)
+		}
+		*timeout.target = value
+	}
+	return server, nil
+}
+
+// shutdownTimeout is the time in-flight requests and background work get to finish
+func (o ServerOptions) shutdownTimeout() (time.Duration, error) {
+	timeout, err := parseDurationOption(o.ShutdownTimeout, 30*time.Second)
+	if err == nil && timeout < 0 {
+		err = errNegative
+	}
+	return timeout, err
+}
+
+/******************************************************************************************
+ *
+ * Serve, over TLS when the server has a TLS config, until SIGTERM or SIGINT, then
+ * stop accepting connections, wait for in-flight
+ * requests and run the drain functions (background work like webhook deliveries)
+ * within the shutdown timeout. The contexts of requests still running when the
+ * timeout passes are cancelled.
+ *
+*******************************************************************************************/
+func ServeGracefully(server *http.Server, options ServerOptions, drains ...func(context.Context) error) error {
+	timeout, err := options.shutdownTimeout()
+	if err != nil {
+		return fmt.Errorf("app: invalid shutdowntimeout: %v", err)
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	return serveUntil(server, listener, signals, timeout, drains...)
+}
+
+func serveUntil(server *http.Server, listener net.Listener, stop <-chan os.Signal, timeout time.Duration, drains ...func(context.Context) error) error {
+	// uploads in flight keep their context while they are drained
+	base, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	server.BaseContext = func(net.Listener) context.Context { return base }
+
+	errc := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			// the certificates come from the TLS config
+			errc <- server.ServeTLS(listener, "", "")
+			return
+		}
+		errc <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		log.WithFields(log.Fields{"Signal": sig.String(), "Timeout": timeout.String()}).Warning("Shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	cancelBase()
+	if err != nil {
+		log.WithFields(log.Fields{"Shutdown Error": err}).Error("In-flight requests did not finish")
+	}
+	for _, drain := range drains {
+		if derr := drain(ctx); derr != nil {
+			log.WithFields(log.Fields{"Shutdown Error": derr}).Error("Background work did not finish")
+			if err == nil {
+				err = derr
+			}
+		}
+	}
+	if err == nil {
+		log.Info("Shutdown complete")
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+/******************************************************************************
+ * \file        server_test.go
+ *
+ * \brief       GO File that tests server timeouts and graceful shutdown
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	var config TomlConfig
+	if _, err := toml.DecodeFile("data/config.toml", &config); err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(":0", http.NotFoundHandler(), config.App.ServerOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ReadHeaderTimeout != 10*time.Second || server.ReadTimeout != 5*time.Minute || server.IdleTimeout != 2*time.Minute {
+		t.Errorf("Expected the timeouts of config.toml, got %+v", server)
+	}
+
+	for _, options := range []ServerOptions{{WriteTimeout: "soon"}, {ReadTimeout: "-1s"}} {
+		if _, err := NewServer(":0", http.NotFoundHandler(), options); err == nil {
+			t.Errorf("Expected an error for the invalid timeout of %+v", options)
+		}
+	}
+	if _, err := (ServerOptions{ShutdownTimeout: "-30s"}).shutdownTimeout(); err == nil {
+		t.Errorf("Expected an error for a negative shutdown timeout")
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test in-flight requests and background work finish on shutdown
+ *
+*******************************************************************************************/
+func TestServeUntilDrains(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	server, _ := NewServer("127.0.0.1:0", handler, ServerOptions{})
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drained := false
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntil(server, listener, stop, 5*time.Second, func(ctx context.Context) error {
+			drained = true
+			return nil
+		})
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+listener.Addr().String(), "text/csv", nil)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+	stop <- syscall.SIGTERM
+
+	// the server waits for the request in flight
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the server to wait for the request, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	if code := <-status; code != http.StatusCreated {
+		t.Errorf("Expected the request in flight to finish, got %d", code)
+	}
+	if err := <-done; err != nil || !drained {
+		t.Errorf("Expected a clean shutdown with drained work, got %v %v", err, drained)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Errorf("Expected no new connections after shutdown")
+	}
+}
+
+func TestServeUntilUploadInFlight(t *testing.T) {
+	// an upload whose body is still being sent when shutdown starts
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		io.Copy(io.Discard, r.Body)
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	server, _ := NewServer("127.0.0.1:0", handler, ServerOptions{})
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- serveUntil(server, listener, stop, 5*time.Second) }()
+
+	body, upload := io.Pipe()
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+listener.Addr().String()+"/imdb/uploadmovies", "text/csv", body)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	upload.Write([]byte("Rank,Title,Year\n"))
+	<-started
+	stop <- syscall.SIGTERM
+
+	// the rest of the file arrives while the server shuts down
+	time.Sleep(100 * time.Millisecond)
+	upload.Write([]byte("1,Movie,2016\n"))
+	upload.Close()
+
+	if code := <-status; code != http.StatusCreated {
+		t.Errorf("Expected the upload in flight to finish with its context, got %d", code)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServeUntilDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	server, _ := NewServer("127.0.0.1:0", handler, ServerOptions{})
+	listener, _ := net.Listen("tcp", server.Addr)
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- serveUntil(server, listener, stop, 50*time.Millisecond) }()
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+	stop <- syscall.SIGTERM
+
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Errorf("Expected the shutdown deadline to pass, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+/******************************************************************************
+ * \file        tenants.go
+ *
+ * \brief       GO File that scopes movie catalogs to tenants
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Tenant is chosen by path prefix, header or the tenant of the credential
+const (
+	HEADER_TENANT      = "X-Tenant-ID"
+	TENANT_PATH_PREFIX = "/imdb/tenants/{tenant}"
+	TENANTS_COLLECTION = "tenants"
+)
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Tenant struct for a tenant, zero settings fall back to the config file
+type Tenant struct {
+	Name        string    `bson:"_id" json:"name"`
+	DefaultYear int       `bson:"defaultyear,omitempty" json:"defaultyear,omitempty"`
+	FileSizeKB  int64     `bson:"filesizekb,omitempty" json:"filesizekb,omitempty"`
+	Created     time.Time `bson:"created" json:"created"`
+}
+
+// TenantStore finds tenants by name
+type TenantStore interface {
+	FindTenant(name string) (*Tenant, error)
+}
+
+// Tenant Store, only the default tenant is served without it
+var tenants TenantStore
+
+type tenantKey struct{}
+
+// Year used when a movie query has no year
+func (t *Tenant) Year() int {
+	if t.DefaultYear > 0 {
+		return t.DefaultYear
+	}
+	return Config().Settings.DefaultYear
+}
+
+// MaxUploadSize in bytes, at least 2MB
+func (t *Tenant) MaxUploadSize() int64 {
+	if t.FileSizeKB <= 0 {
+		return defaultMaxUploadSize()
+	}
+	return Max(2048*1024, t.FileSizeKB*1024)
+}
+
+/******************************************************************************************
+ *
+ * Tenant Middleware - resolves the tenant of requests to catalog endpoints from the
+ * path prefix, the X-Tenant-ID header or the credential. A credential bound to a
+ * tenant cannot be used for another one.
+ *
+*******************************************************************************************/
+func TenantMiddleware(scoped map[string]bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !scoped[RouteName(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			name := mux.Vars(r)["tenant"]
+			if len(name) == 0 {
+				name = r.Header.Get(HEADER_TENANT)
+			}
+			if principal := RequestPrincipal(r); principal != nil && len(principal.Tenant) > 0 {
+				if len(name) == 0 {
+					name = principal.Tenant
+				} else if name != principal.Tenant {
+					RequestLog(r).WithFields(log.Fields{"Tenant": name}).Info("Tenant not allowed")
+					respondWithErrorCode(w, ERR_FORBIDDEN)
+					return
+				}
+			}
+
+			tenant := &Tenant{}
+			if len(name) > 0 {
+				var err error
+				tenant, err = findTenant(name)
+				if err == mgo.ErrNotFound {
+					respondWithErrorCode(w, ERR_TENANT_NOT_FOUND)
+					return
+				} else if err != nil {
+					respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+		})
+	}
+}
+
+func findTenant(name string) (*Tenant, error) {
+	if !tenantNamePattern.MatchString(name) || tenants == nil {
+		return nil, mgo.ErrNotFound
+	}
+	return tenants.FindTenant(name)
+}
+
+/******************************************************************************************
+ *
+ * Tenant of a request, the default tenant if none was chosen
+ *
+*******************************************************************************************/
+func RequestTenant(r *http.Request) *Tenant {
+	if tenant, ok := r.Context().Value(tenantKey{}).(*Tenant); ok {
+		return tenant
+	}
+	return &Tenant{}
+}
+
+/******************************************************************************************
+ *
+ * Movies DAO of the tenant of a request
+ *
+*******************************************************************************************/
+func TenantDAO(r *http.Request) *MoviesDAO {
+	return dao.ForTenant(RequestTenant(r).Name).WithContext(r.Context())
+}
+
+/******************************************************************************************
+ *
+ * Tenant Database Access
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindTenant(name string) (*Tenant, error) {
+	var tenant Tenant
+	done := m.operation("FindTenant", TENANTS_COLLECTION)
+	if err := done(db().C(TENANTS_COLLECTION).FindId(name).One(&tenant)); err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+func (m *MoviesDAO) FindTenants() ([]Tenant, error) {
+	list := []Tenant{}
+	done := m.operation("FindTenants", TENANTS_COLLECTION)
+	err := db().C(TENANTS_COLLECTION).Find(nil).Sort("_id").All(&list)
+	return list, done(err)
+}
+
+func (m *MoviesDAO) InsertTenant(tenant Tenant) error {
+	done := m.operation("InsertTenant", TENANTS_COLLECTION)
+	if err := done(db().C(TENANTS_COLLECTION).Insert(&tenant)); err != nil {
+		return err
+	}
+	return m.ForTenant(tenant.Name).EnsureMovieIndexes()
+}
+
+/******************************************************************************************
+ *
+ * Delete a tenant with its movies, watchlists, ratings, reviews and webhooks
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) DeleteTenant(name string) error {
+	done := m.operation("DeleteTenant", TENANTS_COLLECTION)
+	if err := done(db().C(TENANTS_COLLECTION).RemoveId(name)); err != nil {
+		return err
+	}
+	scoped := m.ForTenant(name)
+	done = scoped.operation("DropMovies", scoped.Collection())
+	if err := scoped.movies().DropCollection(); err != nil && err.Error() != "ns not found" {
+		return done(err)
+	}
+	done(nil)
+	if err := scoped.DeleteUserData(); err != nil {
+		return err
+	}
+	return scoped.DeleteWebhooks()
+}
+
+/******************************************************************************************
+ *
+ * Movies DAOs of the default tenant and every created tenant
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) AllTenants() ([]*MoviesDAO, error) {
+	list, err := m.FindTenants()
+	if err != nil {
+		return nil, err
+	}
+	scoped := []*MoviesDAO{m.ForTenant("")}
+	for _, tenant := range list {
+		scoped = append(scoped, m.ForTenant(tenant.Name))
+	}
+	return scoped, nil
+}
+
+/******************************************************************************************
+ *
+ * Only administrators not bound to a tenant can manage tenants
+ *
+*******************************************************************************************/
+func tenantAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if principal := RequestPrincipal(r); principal != nil && len(principal.Tenant) > 0 {
+		respondWithErrorCode(w, ERR_FORBIDDEN)
+		return false
+	}
+	return true
+}
+
+/******************************************************************************************
+ *
+ * Create a tenant
+ *
+*******************************************************************************************/
+func CreateTenant(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "CreateTenant"}).Info()
+	if !tenantAdmin(w, r) {
+		return
+	}
+
+	var tenant Tenant
+	if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+		respondWithErrorCode(w, ERR_TENANT_INVALID)
+		return
+	}
+	if !tenantNamePattern.MatchString(tenant.Name) || tenant.DefaultYear < 0 || tenant.FileSizeKB < 0 {
+		respondWithErrorCode(w, ERR_TENANT_INVALID)
+		return
+	}
+	if tenant.DefaultYear > 0 {
+		if _, err := IsValidYear(strconv.Itoa(tenant.DefaultYear)); err != nil {
+			respondWithErrorCode(w, ERR_TENANT_INVALID)
+			return
+		}
+	}
+
+	tenant.Created = time.Now().UTC()
+	if err := dao.WithContext(r.Context()).InsertTenant(tenant); mgo.IsDup(err) {
+		respondWithErrorCode(w, ERR_TENANT_EXISTS)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, tenant)
+}
+
+/******************************************************************************************
+ *
+ * List tenants
+ *
+*******************************************************************************************/
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	if !tenantAdmin(w, r) {
+		return
+	}
+	list, err := dao.WithContext(r.Context()).FindTenants()
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+/******************************************************************************************
+ *
+ * Delete a tenant and all of its movies
+ *
+*******************************************************************************************/
+func DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["tenant"]
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "DeleteTenant", "Tenant": name}).Warning()
+	if !tenantAdmin(w, r) {
+		return
+	}
+
+	if err := dao.WithContext(r.Context()).DeleteTenant(name); err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_TENANT_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tenantFilter matches documents of the tenant, documents of the default tenant
+// stored before tenants existed have no tenant field
+func tenantFilter(tenant string) interface{} {
+	if len(tenant) == 0 {
+		return bson.M{"$in": []interface{}{"", nil}}
+	}
+	return tenant
+}
@@ -0,0 +1,113 @@
+/******************************************************************************
+ * \file        tenants_test.go
+ *
+ * \brief       GO File that tests tenant resolution and isolation
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+// memoryTenantStore keeps tenants by name for testing without a database
+type memoryTenantStore map[string]*Tenant
+
+func (s memoryTenantStore) FindTenant(name string) (*Tenant, error) {
+	if tenant, ok := s[name]; ok {
+		return tenant, nil
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func withTenants(t *testing.T) {
+	tenants = memoryTenantStore{
+		"team-a": {Name: "team-a", DefaultYear: 1999, FileSizeKB: 8192},
+		"team-b": {Name: "team-b"},
+	}
+	t.Cleanup(func() { tenants = nil })
+}
+
+// uploadsOf lists the uploads seen by a request
+func uploadsOf(t *testing.T, req *http.Request) []UploadProgressEvent {
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var list []UploadProgressEvent
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestTenantUploadsIsolated(t *testing.T) {
+	withTenants(t)
+	uploads = NewUploadTracker(ProgressOptions{})
+	uploads.Start("team-a", "upload-a")
+	uploads.Start("", "upload-default")
+
+	req, _ := http.NewRequest("GET", "/imdb/tenants/team-a/uploads", nil)
+	if list := uploadsOf(t, req); len(list) != 1 || list[0].UploadID != "upload-a" {
+		t.Errorf("Expected only the uploads of team-a by path, got %+v", list)
+	}
+
+	req, _ = http.NewRequest("GET", "/imdb/uploads", nil)
+	req.Header.Set(HEADER_TENANT, "team-b")
+	if list := uploadsOf(t, req); len(list) != 0 {
+		t.Errorf("Expected no uploads for team-b by header, got %+v", list)
+	}
+
+	req, _ = http.NewRequest("GET", "/imdb/uploads", nil)
+	if list := uploadsOf(t, req); len(list) != 1 || list[0].UploadID != "upload-default" {
+		t.Errorf("Expected only the uploads of the default tenant, got %+v", list)
+	}
+}
+
+func TestTenantNotFound(t *testing.T) {
+	withTenants(t)
+	for _, path := range []string{"/imdb/tenants/team-c/uploads", "/imdb/tenants/Not_Valid/uploads"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		NewRouter().ServeHTTP(response, req)
+		checkResponseCode(t, http.StatusNotFound, response.Code)
+	}
+}
+
+func TestTenantBoundCredential(t *testing.T) {
+	withTenants(t)
+	store := memoryAPIKeyStore{HashAPIKey("team-a-key"): &APIKey{Name: "team-a-ci", Role: "admin", Tenant: "team-a"}}
+	auth = &Auth{Authenticators: []Authenticator{&APIKeyAuthenticator{Store: store}}}
+	defer func() { auth = nil }()
+
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/uploads", "team-a-key").Code)
+	checkResponseCode(t, http.StatusOK, serveWithKey("GET", "/imdb/tenants/team-a/uploads", "team-a-key").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/tenants/team-b/uploads", "team-a-key").Code)
+	// tenants are managed by administrators not bound to a tenant
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/tenants", "team-a-key").Code)
+}
+
+func TestTenantSettings(t *testing.T) {
+	tenant := &Tenant{Name: "team-a", DefaultYear: 1999, FileSizeKB: 8192}
+	if tenant.Year() != 1999 || tenant.MaxUploadSize() != 8192*1024 {
+		t.Errorf("Expected the settings of the tenant, got %d %d", tenant.Year(), tenant.MaxUploadSize())
+	}
+
+	tenant = &Tenant{Name: "team-b"}
+	if tenant.Year() != Config().Settings.DefaultYear || tenant.MaxUploadSize() != defaultMaxUploadSize() {
+		t.Errorf("Expected the settings of the config file, got %d %d", tenant.Year(), tenant.MaxUploadSize())
+	}
+}
+
+func TestMoviesDAOCollection(t *testing.T) {
+	if dao.ForTenant("").Collection() != COLLECTION || dao.ForTenant("team-a").Collection() != COLLECTION+"_team-a" {
+		t.Errorf("Expected a collection per tenant, got %s %s", dao.ForTenant("").Collection(), dao.ForTenant("team-a").Collection())
+	}
+}
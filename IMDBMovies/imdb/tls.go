@@ -0,0 +1,317 @@
+/******************************************************************************
+ * \file        tls.go
+ *
+ * \brief       GO File that terminates TLS, reloads certificates when their files
+ *              change and authenticates clients by their certificate
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSOptions struct for the TLS listener in config file
+type TLSOptions struct {
+	Enabled        bool     `toml:"enabled"`
+	CertFile       string   `toml:"certfile"`
+	KeyFile        string   `toml:"keyfile"`
+	MinVersion     string   `toml:"minversion"`
+	Ciphers        []string `toml:"ciphers"`
+	ClientCA       string   `toml:"clientca"`
+	ClientAuth     string   `toml:"clientauth"`
+	ReloadInterval string   `toml:"reloadinterval"`
+}
+
+// Client certificate modes: none, verify a certificate if given, or require one
+const (
+	CLIENT_AUTH_NONE     = "none"
+	CLIENT_AUTH_OPTIONAL = "optional"
+	CLIENT_AUTH_REQUIRE  = "require"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	CLIENT_AUTH_NONE:     tls.NoClientCert,
+	CLIENT_AUTH_OPTIONAL: tls.VerifyClientCertIfGiven,
+	CLIENT_AUTH_REQUIRE:  tls.RequireAndVerifyClientCert,
+}
+
+/******************************************************************************************
+ *
+ * Create the TLS config of the server from config. Ciphers are the names of the
+ * secure TLS 1.2 cipher suites allowed, Go's defaults when empty; TLS 1.3 suites
+ * are not configurable.
+ *
+*******************************************************************************************/
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if len(options.CertFile) == 0 || len(options.KeyFile) == 0 {
+		return nil, errors.New("app.tls: certfile and keyfile are required")
+	}
+	minVersion := options.MinVersion
+	if len(minVersion) == 0 {
+		minVersion = "1.2"
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("app.tls: unknown minversion %q, expected 1.2 or 1.3", options.MinVersion)
+	}
+	ciphers, err := parseCipherSuites(options.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := strings.ToLower(options.ClientAuth)
+	if len(clientAuth) == 0 {
+		clientAuth = CLIENT_AUTH_NONE
+	}
+	authType, ok := clientAuthTypes[clientAuth]
+	if !ok {
+		return nil, fmt.Errorf("app.tls: unknown clientauth %q, expected none, optional or require", options.ClientAuth)
+	}
+	if authType != tls.NoClientCert && len(options.ClientCA) == 0 {
+		return nil, errors.New("app.tls: clientca is required to verify client certificates")
+	}
+	interval, err := parseDurationOption(options.ReloadInterval, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("app.tls: invalid reloadinterval: %v", err)
+	}
+
+	certs := &TLSCertificates{
+		CertFile: options.CertFile,
+		KeyFile:  options.KeyFile,
+		Interval: interval,
+	}
+	if authType != tls.NoClientCert {
+		certs.ClientCA = options.ClientCA
+	}
+	if err := certs.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   ciphers,
+		ClientAuth:     authType,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: certs.GetCertificate,
+	}
+	if authType != tls.NoClientCert {
+		// each handshake verifies against the current CA bundle
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := config.Clone()
+			current.GetConfigForClient = nil
+			current.ClientCAs = certs.ClientCAs()
+			return current, nil
+		}
+	}
+	return config, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("app.tls: unknown or insecure cipher %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+/******************************************************************************************
+ *
+ * TLS Certificates - the server certificate and client CA bundle, loaded again when
+ * their files change. The files are checked at most every interval on handshakes.
+ * A certificate that does not load is logged and the previous one is kept.
+ *
+*******************************************************************************************/
+type TLSCertificates struct {
+	CertFile string
+	KeyFile  string
+	ClientCA string
+	Interval time.Duration
+
+	mu        sync.Mutex
+	checked   time.Time
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func (c *TLSCertificates) files() []string {
+	files := []string{c.CertFile, c.KeyFile}
+	if len(c.ClientCA) > 0 {
+		files = append(files, c.ClientCA)
+	}
+	return files
+}
+
+func (c *TLSCertificates) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("app.tls: %v", err)
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("app.tls: %v", err)
+	}
+	var pool *x509.CertPool
+	if len(c.ClientCA) > 0 {
+		data, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return fmt.Errorf("app.tls: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("app.tls: no certificates in clientca %s", c.ClientCA)
+		}
+	}
+
+	c.cert = &cert
+	c.clientCAs = pool
+	c.modTimes = modTimes
+	c.checked = time.Now()
+	return nil
+}
+
+// refresh loads the files again if one of them changed since the last check
+func (c *TLSCertificates) refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < c.Interval {
+		return
+	}
+	c.checked = time.Now()
+
+	changed := false
+	for i, file := range c.files() {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(c.modTimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := c.load(); err != nil {
+		log.WithFields(log.Fields{"TLS Error": err}).Error("Certificate is not reloaded, keeping the current one")
+		return
+	}
+	log.WithFields(log.Fields{"Certificate": c.CertFile, "Client CA": c.ClientCA}).Info("Reloaded TLS certificates")
+}
+
+/******************************************************************************************
+ *
+ * Current server certificate, for tls.Config.GetCertificate
+ *
+*******************************************************************************************/
+func (c *TLSCertificates) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.refresh()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+/******************************************************************************************
+ *
+ * Current CA bundle client certificates are verified against
+ *
+*******************************************************************************************/
+func (c *TLSCertificates) ClientCAs() *x509.CertPool {
+	c.refresh()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientCAs
+}
+
+// CertOptions struct for client certificate authentication in config file
+type CertOptions struct {
+	Role  string            `toml:"role"`
+	Roles map[string]string `toml:"roles"`
+}
+
+/******************************************************************************************
+ *
+ * Certificate Authenticator - the subject of a client certificate verified during
+ * the TLS handshake is the identity. The role is looked up by the subject
+ * (CN=...,O=...) or its common name, and is Role for other subjects.
+ *
+*******************************************************************************************/
+type CertAuthenticator struct {
+	Role  Role
+	Roles map[string]Role
+}
+
+/******************************************************************************************
+ *
+ * Create the Certificate Authenticator from config, uploader is the default role
+ *
+*******************************************************************************************/
+func NewCertAuthenticator(options CertOptions) (*CertAuthenticator, error) {
+	authenticator := &CertAuthenticator{Role: ROLE_UPLOADER, Roles: make(map[string]Role)}
+	if len(options.Role) > 0 {
+		role, err := ParseRole(options.Role)
+		if err != nil {
+			return nil, fmt.Errorf("auth.cert: role: %v", err)
+		}
+		authenticator.Role = role
+	}
+	for subject, name := range options.Roles {
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("auth.cert: roles.%s: %v", subject, err)
+		}
+		authenticator.Roles[subject] = role
+	}
+	return authenticator, nil
+}
+
+// Scheme is empty, client certificates are not asked for with a challenge
+func (a *CertAuthenticator) Scheme() string {
+	return ""
+}
+
+func (a *CertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	subject := cert.Subject.String()
+
+	role, ok := a.Roles[subject]
+	if !ok {
+		if role, ok = a.Roles[cert.Subject.CommonName]; !ok {
+			role = a.Role
+		}
+	}
+	return &Principal{Subject: subject, Role: role, Method: AUTH_METHOD_CERT}, nil
+}
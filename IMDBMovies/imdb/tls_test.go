@@ -0,0 +1,273 @@
+/******************************************************************************
+ * \file        tls_test.go
+ *
+ * \brief       GO File that tests TLS termination, certificate reload and client
+ *              certificate authentication
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testCA signs the server and client certificates of a test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var certSerial int64
+
+func newCertificate(t *testing.T, template *x509.Certificate, parent *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSerial++
+	template.SerialNumber = big.NewInt(certSerial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func newTestCA(t *testing.T) *testCA {
+	cert, key, certPEM, _ := newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "IMDB Test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	return &testCA{cert: cert, key: key, pem: certPEM}
+}
+
+// writeServerCert writes a certificate for 127.0.0.1 and its key to dir
+func (ca *testCA) writeServerCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	cert, _, certPEM, keyPEM := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "imdb-restapi"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	os.WriteFile(certFile, certPEM, 0600)
+	os.WriteFile(keyFile, keyPEM, 0600)
+	later := time.Now().Add(time.Duration(certSerial) * time.Second)
+	os.Chtimes(certFile, later, later)
+	return cert, certFile, keyFile
+}
+
+func (ca *testCA) clientCert(t *testing.T, name string) tls.Certificate {
+	_, _, certPEM, keyPEM := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name, Organization: []string{"Studio"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// serveTLS serves handler over TLS until the test ends and returns its address
+func serveTLS(t *testing.T, handler http.Handler, config *tls.Config) string {
+	server, _ := NewServer("127.0.0.1:0", handler, ServerOptions{})
+	server.TLSConfig = config
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- serveUntil(server, listener, stop, time.Second) }()
+	t.Cleanup(func() {
+		stop <- syscall.SIGTERM
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+func tlsClient(ca *testCA, certs ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+		DisableKeepAlives: true,
+	}}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	_, certFile, keyFile := ca.writeServerCert(t, t.TempDir())
+
+	config, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS13 || config.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected TLS 1.3 without client certificates, got %x %v", config.MinVersion, config.ClientAuth)
+	}
+
+	for _, options := range []TLSOptions{
+		{CertFile: certFile},
+		{CertFile: certFile, KeyFile: filepath.Join(t.TempDir(), "missing.key")},
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.0"},
+		{CertFile: certFile, KeyFile: keyFile, Ciphers: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuth: CLIENT_AUTH_REQUIRE},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuth: "always", ClientCA: certFile},
+	} {
+		if _, err := NewTLSConfig(options); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	first, certFile, keyFile := ca.writeServerCert(t, dir)
+	config, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, ReloadInterval: "1ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, http.NotFoundHandler(), config)
+
+	serial := func() *big.Int {
+		resp, err := tlsClient(ca).Get("https://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber
+	}
+	if serial().Cmp(first.SerialNumber) != 0 {
+		t.Fatalf("Expected the first certificate")
+	}
+
+	second, _, _ := ca.writeServerCert(t, dir)
+	if serial().Cmp(second.SerialNumber) != 0 {
+		t.Errorf("Expected the renewed certificate")
+	}
+
+	// a broken certificate keeps the current one
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(certFile, later, later)
+	if serial().Cmp(second.SerialNumber) != 0 {
+		t.Errorf("Expected the renewed certificate to stay")
+	}
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	withAccessLog(t)
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	_, certFile, keyFile := ca.writeServerCert(t, dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, ca.pem, 0600)
+
+	config, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile,
+		ClientCA: caFile, ClientAuth: CLIENT_AUTH_REQUIRE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewCertAuthenticator(CertOptions{Roles: map[string]string{"reader-1": "reader"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth = &Auth{Authenticators: []Authenticator{authenticator}}
+	t.Cleanup(func() { auth = nil })
+	addr := serveTLS(t, NewRouter(), config)
+
+	// the handshake fails without a client certificate
+	if _, err := tlsClient(ca).Get("https://" + addr + "/imdb/uploads"); err == nil {
+		t.Errorf("Expected a client certificate to be required")
+	}
+
+	// a certificate of another CA is not accepted
+	other := newTestCA(t)
+	if _, err := tlsClient(ca, other.clientCert(t, "uploader-1")).Get("https://" + addr + "/imdb/uploads"); err == nil {
+		t.Errorf("Expected a certificate of another CA to be rejected")
+	}
+
+	resp, err := tlsClient(ca, ca.clientCert(t, "uploader-1")).Get("https://" + addr + "/imdb/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkResponseCode(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = tlsClient(ca, ca.clientCert(t, "reader-1")).Get("https://" + addr + "/imdb/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkResponseCode(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	authenticator, err := NewCertAuthenticator(CertOptions{
+		Role:  "reader",
+		Roles: map[string]string{"CN=ingest,O=Studio": "uploader", "ops": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal := func(name string) *Principal {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name, Organization: []string{"Studio"}}}
+		req, _ := http.NewRequest("POST", "/imdb/uploadmovies", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		p, _ := authenticator.Authenticate(req)
+		return p
+	}
+	if p := principal("ingest"); p.Role != ROLE_UPLOADER || p.Subject != "CN=ingest,O=Studio" || p.Method != AUTH_METHOD_CERT {
+		t.Errorf("Expected the role of the subject, got %+v", p)
+	}
+	if p := principal("ops"); p.Role != ROLE_ADMIN {
+		t.Errorf("Expected the role of the common name, got %+v", p)
+	}
+	if p := principal("someone"); p.Role != ROLE_READER {
+		t.Errorf("Expected the default role, got %+v", p)
+	}
+
+	req, _ := http.NewRequest("POST", "/imdb/uploadmovies", nil)
+	req.TLS = &tls.ConnectionState{}
+	if p, err := authenticator.Authenticate(req); p != nil || err != nil {
+		t.Errorf("Expected no principal without a verified certificate, got %+v %v", p, err)
+	}
+
+	if _, err := NewCertAuthenticator(CertOptions{Roles: map[string]string{"ops": "root"}}); err == nil {
+		t.Errorf("Expected an unknown role to be rejected")
+	}
+}
@@ -0,0 +1,266 @@
+/******************************************************************************
+ * \file        tracing.go
+ *
+ * \brief       GO File that traces requests, handlers and database calls with
+ *              OpenTelemetry
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/mgo.v2"
+)
+
+// TracingOptions struct for the span exporter in config file
+type TracingOptions struct {
+	Enabled     bool    `toml:"enabled"`
+	Exporter    string  `toml:"exporter"`
+	Endpoint    string  `toml:"endpoint"`
+	Insecure    bool    `toml:"insecure"`
+	File        string  `toml:"file"`
+	SampleRatio float64 `toml:"sampleratio"`
+	ServiceName string  `toml:"servicename"`
+}
+
+// Span exporters
+const (
+	EXPORTER_OTLP   = "otlp"
+	EXPORTER_STDOUT = "stdout"
+	EXPORTER_FILE   = "file"
+)
+
+// Spans of the application, a no-op until a tracer provider is set
+var tracer = otel.Tracer("imdb")
+
+func init() {
+	// W3C trace context is propagated even when spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+/******************************************************************************************
+ *
+ * Create the tracer provider exporting spans in batches to the configured exporter.
+ * OTLP spans are sent over HTTP to endpoint (host:port, the OTEL_EXPORTER_OTLP_*
+ * environment variables apply when empty), stdout and file write them as JSON.
+ *
+*******************************************************************************************/
+func NewTracerProvider(options TracingOptions) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(options.Exporter) {
+	case EXPORTER_OTLP, "":
+		var opts []otlptracehttp.Option
+		if len(options.Endpoint) > 0 {
+			opts = append(opts, otlptracehttp.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case EXPORTER_STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case EXPORTER_FILE:
+		if len(options.File) == 0 {
+			return nil, fmt.Errorf("tracing: file exporter needs a file")
+		}
+		var f io.Writer
+		f, err = os.OpenFile(options.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %v", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", options.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+
+	if options.SampleRatio < 0 || options.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing: sampleratio %v is not between 0 and 1", options.SampleRatio)
+	}
+	ratio := options.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	name := options.ServiceName
+	if len(name) == 0 {
+		name = "imdb-restapi"
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// a sampled caller keeps its traces complete
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(name), semconv.ServiceVersion(Version()))),
+	), nil
+}
+
+/******************************************************************************************
+ *
+ * Export the spans of the application, the returned function flushes the pending
+ * spans and stops the exporter
+ *
+*******************************************************************************************/
+func InitTracing(options TracingOptions) (func(context.Context) error, error) {
+	provider, err := NewTracerProvider(options)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+/******************************************************************************************
+ *
+ * Tracing Middleware - continues the trace of the caller from the traceparent header
+ * and spans each request by its route
+ *
+*******************************************************************************************/
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				path = template
+			}
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(path),
+				semconv.URLPath(r.URL.Path),
+				attribute.String("imdb.route", RouteName(r)),
+				attribute.String("imdb.request_id", RequestID(r)),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+/******************************************************************************************
+ *
+ * Start a span of a step of a handler, e.g. parameter validation or encoding
+ *
+*******************************************************************************************/
+func startSpan(r *http.Request, name string) trace.Span {
+	_, span := tracer.Start(r.Context(), name)
+	return span
+}
+
+/******************************************************************************************
+ *
+ * Movies DAO whose operations are spanned as children of the span of ctx
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) WithContext(ctx context.Context) *MoviesDAO {
+	scoped := *m
+	scoped.ctx = ctx
+	return &scoped
+}
+
+/******************************************************************************************
+ *
+ * Start the span of a DAO operation on a collection, the returned function ends it,
+ * records the latency and error metrics and returns the error
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) operation(name string, collection string) func(error) error {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := time.Now()
+	_, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNamespace(m.Database),
+			semconv.DBCollectionName(collection),
+			semconv.DBOperationName(name),
+		))
+	return func(err error) error {
+		if err != nil && err != mgo.ErrNotFound {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		// duplicates are answered with 409 or counted by uploads
+		if err != nil && err != mgo.ErrNotFound && !mgo.IsDup(err) {
+			ModuleLog(ctx, MODULE_DATABASE).WithFields(log.Fields{"Operation": name, "Collection": collection, "Database Error": err}).Warning()
+		}
+		span.End()
+		return observeDAO(name, start, err)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Start the span of an upload, the request of the returned span context creates
+ * the movies
+ *
+*******************************************************************************************/
+func startUploadSpan(r *http.Request, progress *UploadProgress) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(r.Context(), "Upload",
+		trace.WithAttributes(
+			attribute.String("imdb.upload.id", progress.ID()),
+			attribute.String("imdb.upload.content_type", r.Header.Get("Content-Type")),
+			attribute.String("imdb.tenant", RequestTenant(r).Name),
+		))
+	return r.WithContext(ctx), span
+}
+
+/******************************************************************************************
+ *
+ * Record the rows and error of a finished upload on its span
+ *
+*******************************************************************************************/
+func traceUpload(span trace.Span, results *UploadResults, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Int("imdb.upload.rows_read", results.RecordsRead),
+		attribute.Int("imdb.upload.rows_created", results.RecordsCreated),
+		attribute.Int("imdb.upload.rows_errored", results.RecordsErrored),
+		attribute.Int("imdb.upload.files", len(results.Files)),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,170 @@
+/******************************************************************************
+ * \file        tracing_test.go
+ *
+ * \brief       GO File that tests the OpenTelemetry spans
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recorderOnce sync.Once
+	spanRecorder *tracetest.SpanRecorder
+)
+
+// the global tracer delegates to the first provider set, so all tests share one recorder
+func withSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+// ended spans of a trace
+func spansOf(recorder *tracetest.SpanRecorder, id trace.TraceID) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == id {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := withSpanRecorder(t)
+
+	req, _ := http.NewRequest("GET", "/imdb/version", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	id, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spans := spansOf(recorder, id)
+	if len(spans) != 1 {
+		t.Fatalf("Expected the request span in the trace of the caller, got %d spans", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /imdb/version" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Expected a server span named by the route, got %s %v", span.Name(), span.SpanKind())
+	}
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the span of the caller as parent, got %s", span.Parent().SpanID())
+	}
+	if code := spanAttribute(span, "http.response.status_code").AsInt64(); code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", code)
+	}
+	if route := spanAttribute(span, "imdb.route").AsString(); route != "GetVersion" {
+		t.Errorf("Expected route GetVersion, got %s", route)
+	}
+}
+
+func TestDAOOperationSpan(t *testing.T) {
+	recorder := withSpanRecorder(t)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "handler")
+	catalog := dao.ForTenant("team-a").WithContext(ctx)
+	err := errors.New("connection reset")
+	if catalog.operation("TestOperation", catalog.Collection())(err) != err {
+		t.Errorf("Expected the error to be returned")
+	}
+	parent.End()
+
+	spans := spansOf(recorder, parent.SpanContext().TraceID())
+	if len(spans) != 2 {
+		t.Fatalf("Expected the operation and handler spans, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "TestOperation" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected the operation span as child of the handler span, got %s", span.Name())
+	}
+	if collection := spanAttribute(span, "db.collection.name").AsString(); collection != COLLECTION+"_team-a" {
+		t.Errorf("Expected the collection of the tenant, got %s", collection)
+	}
+	if span.Status().Code != codes.Error || len(span.Events()) == 0 {
+		t.Errorf("Expected the error to be recorded, got %+v", span.Status())
+	}
+}
+
+func TestTraceUpload(t *testing.T) {
+	recorder := withSpanRecorder(t)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "Upload")
+	results := &UploadResults{RecordsRead: 10, RecordsCreated: 7, RecordsErrored: 3}
+	traceUpload(span, results, errUploadFailed)
+
+	spans := spansOf(recorder, span.SpanContext().TraceID())
+	if len(spans) != 1 {
+		t.Fatalf("Expected the upload span to end, got %d spans", len(spans))
+	}
+	for key, expected := range map[attribute.Key]int64{
+		"imdb.upload.rows_read":    10,
+		"imdb.upload.rows_created": 7,
+		"imdb.upload.rows_errored": 3,
+	} {
+		if value := spanAttribute(spans[0], key).AsInt64(); value != expected {
+			t.Errorf("Expected %s %d, got %d", key, expected, value)
+		}
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("Expected the failed upload to be an error")
+	}
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.json")
+	provider, err := NewTracerProvider(TracingOptions{Exporter: EXPORTER_FILE, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := provider.Tracer("test").Start(context.Background(), "GET /imdb/movies")
+	span.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "GET /imdb/movies") {
+		t.Errorf("Expected the span in the file, got %s", data)
+	}
+
+	for _, options := range []TracingOptions{
+		{Exporter: "zipkin"},
+		{Exporter: EXPORTER_FILE},
+		{Exporter: EXPORTER_STDOUT, SampleRatio: 2},
+	} {
+		if _, err := NewTracerProvider(options); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+/******************************************************************************
+ * \file        users.go
+ *
+ * \brief       GO File that has REST functions for user watchlists and ratings
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	WATCHLISTS_COLLECTION = "watchlists"
+	RATINGS_COLLECTION    = "ratings"
+	// path user id of the user of the credential
+	CURRENT_USER = "me"
+	MIN_RATING   = 1
+	MAX_RATING   = 10
+)
+
+// WatchlistEntry struct for a movie on the watchlist of a user
+type WatchlistEntry struct {
+	ID        bson.ObjectId `bson:"_id,omitempty" json:"-"`
+	Tenant    string        `bson:"tenant" json:"-"`
+	User      string        `bson:"user" json:"user"`
+	MovieID   bson.ObjectId `bson:"movie_id" json:"movie_id"`
+	Watched   bool          `bson:"watched" json:"watched"`
+	Added     time.Time     `bson:"added" json:"added"`
+	WatchedAt time.Time     `bson:"watched_at,omitempty" json:"watched_at,omitempty"`
+	Movie     *Movie        `bson:"-" json:"movie,omitempty"`
+}
+
+// UserRating struct for the personal rating of a movie by a user
+type UserRating struct {
+	ID      bson.ObjectId `bson:"_id,omitempty" json:"-"`
+	Tenant  string        `bson:"tenant" json:"-"`
+	User    string        `bson:"user" json:"user"`
+	MovieID bson.ObjectId `bson:"movie_id" json:"movie_id"`
+	Rating  int           `bson:"rating" json:"rating"`
+	Updated time.Time     `bson:"updated" json:"updated"`
+}
+
+/******************************************************************************************
+ *
+ * User of a request for user scoped endpoints. 'me' is the user of the credential.
+ * Users can only access their own resources, administrators those of every user.
+ *
+*******************************************************************************************/
+func pathUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user := mux.Vars(r)["uid"]
+	principal := RequestPrincipal(r)
+	if user == CURRENT_USER {
+		if principal == nil {
+			respondWithErrorCode(w, ERR_USER_INVALID)
+			return "", false
+		}
+		return principal.Subject, true
+	}
+	if principal != nil && principal.Subject != user && principal.Role < ROLE_ADMIN {
+		RequestLog(r).WithFields(log.Fields{"User": user}).Info("User not allowed")
+		respondWithErrorCode(w, ERR_FORBIDDEN)
+		return "", false
+	}
+	return user, true
+}
+
+/******************************************************************************************
+ *
+ * Current user of a request, the user of the credential or the uid query parameter
+ * when authentication is disabled
+ *
+*******************************************************************************************/
+func CurrentUser(r *http.Request) string {
+	if principal := RequestPrincipal(r); principal != nil {
+		return principal.Subject
+	}
+	return r.URL.Query().Get("uid")
+}
+
+/******************************************************************************************
+ *
+ * Movie id filter for the watched query parameter of GetMovies, watched=false leaves
+ * out the movies the current user has watched and watched=true keeps only those.
+ * Returns nil without the parameter.
+ *
+*******************************************************************************************/
+func watchedFilter(w http.ResponseWriter, r *http.Request) (bson.M, bool) {
+	qparams := r.URL.Query()
+	if qparams["watched"] == nil {
+		return nil, true
+	}
+	watched, err := strconv.ParseBool(qparams["watched"][0])
+	if err != nil {
+		respondWithErrorCode(w, ERR_WATCHED_INVALID)
+		return nil, false
+	}
+	user := CurrentUser(r)
+	if len(user) == 0 {
+		respondWithErrorCode(w, ERR_USER_INVALID)
+		return nil, false
+	}
+
+	ids, err := TenantDAO(r).FindWatchedIDs(user)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return nil, false
+	}
+	if watched {
+		return bson.M{"$in": ids}, true
+	}
+	return bson.M{"$nin": ids}, true
+}
+
+/******************************************************************************************
+ *
+ * Get the watchlist of a user, optionally only watched or unwatched movies
+ *
+*******************************************************************************************/
+func GetWatchlist(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	var watched *bool
+	if value := r.URL.Query().Get("watched"); len(value) > 0 {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithErrorCode(w, ERR_WATCHED_INVALID)
+			return
+		}
+		watched = &parsed
+	}
+
+	catalog := TenantDAO(r)
+	list, err := catalog.FindWatchlist(user, watched)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+
+	// the movies are looked up for the response, deleted movies are left out
+	ids := make([]bson.ObjectId, len(list))
+	for i := range list {
+		ids[i] = list[i].MovieID
+	}
+	movies, err := catalog.FindByIDs(ids)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	entries := []WatchlistEntry{}
+	for _, entry := range list {
+		if movie, ok := movies[entry.MovieID]; ok {
+			entry.Movie = &movie
+			entries = append(entries, entry)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+/******************************************************************************************
+ *
+ * Add a movie to the watchlist of a user or change its watched state
+ *
+*******************************************************************************************/
+func PutWatchlistMovie(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Watched bool `json:"watched"`
+	}
+	// the body is optional, a movie is added unwatched
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		respondWithErrorCode(w, ERR_WATCHED_INVALID)
+		return
+	}
+	if !movieExists(w, r, id) {
+		return
+	}
+
+	entry, err := TenantDAO(r).UpsertWatchlist(user, id, body.Watched)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entry)
+}
+
+/******************************************************************************************
+ *
+ * Remove a movie from the watchlist of a user
+ *
+*******************************************************************************************/
+func DeleteWatchlistMovie(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := TenantDAO(r).RemoveWatchlist(user, id); err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************************
+ *
+ * Get the personal ratings of a user
+ *
+*******************************************************************************************/
+func GetUserRatings(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	list, err := TenantDAO(r).FindUserRatings(user)
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+/******************************************************************************************
+ *
+ * Rate a movie from 1 to 10, the community rating of the movie is updated
+ *
+*******************************************************************************************/
+func PutUserRating(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Rating int `json:"rating"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !IsValidUserRating(body.Rating) {
+		respondWithErrorCode(w, ERR_RATING_INVALID)
+		return
+	}
+	if !movieExists(w, r, id) {
+		return
+	}
+
+	catalog := TenantDAO(r)
+	rating, err := catalog.UpsertUserRating(user, id, body.Rating)
+	if err == nil {
+		err = catalog.UpdateCommunityRating(id)
+	}
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, rating)
+}
+
+/******************************************************************************************
+ *
+ * Delete the personal rating of a movie, the community rating of the movie is updated
+ *
+*******************************************************************************************/
+func DeleteUserRating(w http.ResponseWriter, r *http.Request) {
+	user, ok := pathUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	catalog := TenantDAO(r)
+	err := catalog.RemoveUserRating(user, id)
+	if err == nil {
+		err = catalog.UpdateCommunityRating(id)
+	}
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IsValidUserRating checks a personal rating is a whole number from 1 to 10
+func IsValidUserRating(rating int) bool {
+	return rating >= MIN_RATING && rating <= MAX_RATING
+}
+
+// movieExists responds with 404 if the movie is not in the catalog of the request
+func movieExists(w http.ResponseWriter, r *http.Request, id bson.ObjectId) bool {
+	if _, err := TenantDAO(r).FindByID(id); err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return false
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return false
+	}
+	return true
+}
+
+/******************************************************************************************
+ *
+ * Watchlist and Rating Database Access, entries are scoped to the tenant of the DAO
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) userMovie(user string, id bson.ObjectId) bson.M {
+	return bson.M{"tenant": tenantFilter(m.Tenant), "user": user, "movie_id": id}
+}
+
+func (m *MoviesDAO) FindWatchlist(user string, watched *bool) ([]WatchlistEntry, error) {
+	query := bson.M{"tenant": tenantFilter(m.Tenant), "user": user}
+	if watched != nil {
+		query["watched"] = *watched
+	}
+	list := []WatchlistEntry{}
+	done := m.operation("FindWatchlist", WATCHLISTS_COLLECTION)
+	err := db().C(WATCHLISTS_COLLECTION).Find(query).Sort("-added").All(&list)
+	return list, done(err)
+}
+
+func (m *MoviesDAO) FindWatchedIDs(user string) ([]bson.ObjectId, error) {
+	var list []WatchlistEntry
+	done := m.operation("FindWatchedIDs", WATCHLISTS_COLLECTION)
+	err := db().C(WATCHLISTS_COLLECTION).Find(bson.M{"tenant": tenantFilter(m.Tenant), "user": user, "watched": true}).
+		Select(bson.M{"movie_id": 1}).
+		All(&list)
+	ids := make([]bson.ObjectId, len(list))
+	for i := range list {
+		ids[i] = list[i].MovieID
+	}
+	return ids, done(err)
+}
+
+func (m *MoviesDAO) UpsertWatchlist(user string, id bson.ObjectId, watched bool) (WatchlistEntry, error) {
+	now := time.Now().UTC()
+	set := bson.M{"watched": watched}
+	if watched {
+		set["watched_at"] = now
+	}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"tenant": m.Tenant, "added": now}}
+	if !watched {
+		update["$unset"] = bson.M{"watched_at": ""}
+	}
+
+	var entry WatchlistEntry
+	done := m.operation("UpsertWatchlist", WATCHLISTS_COLLECTION)
+	_, err := db().C(WATCHLISTS_COLLECTION).Find(m.userMovie(user, id)).
+		Apply(mgo.Change{Update: update, Upsert: true, ReturnNew: true}, &entry)
+	return entry, done(err)
+}
+
+func (m *MoviesDAO) RemoveWatchlist(user string, id bson.ObjectId) error {
+	done := m.operation("RemoveWatchlist", WATCHLISTS_COLLECTION)
+	return done(db().C(WATCHLISTS_COLLECTION).Remove(m.userMovie(user, id)))
+}
+
+func (m *MoviesDAO) FindUserRatings(user string) ([]UserRating, error) {
+	list := []UserRating{}
+	done := m.operation("FindUserRatings", RATINGS_COLLECTION)
+	err := db().C(RATINGS_COLLECTION).Find(bson.M{"tenant": tenantFilter(m.Tenant), "user": user}).Sort("-updated").All(&list)
+	return list, done(err)
+}
+
+func (m *MoviesDAO) UpsertUserRating(user string, id bson.ObjectId, rating int) (UserRating, error) {
+	update := bson.M{
+		"$set":         bson.M{"rating": rating, "updated": time.Now().UTC()},
+		"$setOnInsert": bson.M{"tenant": m.Tenant},
+	}
+	var result UserRating
+	done := m.operation("UpsertUserRating", RATINGS_COLLECTION)
+	_, err := db().C(RATINGS_COLLECTION).Find(m.userMovie(user, id)).
+		Apply(mgo.Change{Update: update, Upsert: true, ReturnNew: true}, &result)
+	return result, done(err)
+}
+
+func (m *MoviesDAO) RemoveUserRating(user string, id bson.ObjectId) error {
+	done := m.operation("RemoveUserRating", RATINGS_COLLECTION)
+	return done(db().C(RATINGS_COLLECTION).Remove(m.userMovie(user, id)))
+}
+
+/******************************************************************************************
+ *
+ * Aggregate the personal ratings of a movie into its community rating, rounded to
+ * one decimal like the IMDb rating
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) UpdateCommunityRating(id bson.ObjectId) (err error) {
+	done := m.operation("UpdateCommunityRating", RATINGS_COLLECTION)
+	defer func() { done(err) }()
+
+	var result struct {
+		Average float64 `bson:"average"`
+		Count   int     `bson:"count"`
+	}
+	err = db().C(RATINGS_COLLECTION).Pipe([]bson.M{
+		{"$match": bson.M{"tenant": tenantFilter(m.Tenant), "movie_id": id}},
+		{"$group": bson.M{"_id": nil, "average": bson.M{"$avg": "$rating"}, "count": bson.M{"$sum": 1}}},
+	}).One(&result)
+	if err == mgo.ErrNotFound {
+		return m.movies().UpdateId(id, bson.M{"$unset": bson.M{"community_rating": "", "community_votes": ""}})
+	} else if err != nil {
+		return err
+	}
+	return m.movies().UpdateId(id, bson.M{"$set": bson.M{
+		"community_rating": math.Round(result.Average*10) / 10,
+		"community_votes":  result.Count,
+	}})
+}
+
+/******************************************************************************************
+ *
+ * Remove the watchlist entries, ratings and reviews of movies, of all movies of the
+ * tenant if no id is given
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) DeleteUserData(ids ...bson.ObjectId) (err error) {
+	done := m.operation("DeleteUserData", WATCHLISTS_COLLECTION)
+	defer func() { done(err) }()
+
+	query := bson.M{"tenant": tenantFilter(m.Tenant)}
+	if len(ids) > 0 {
+		query["movie_id"] = bson.M{"$in": ids}
+	}
+	if _, err := db().C(WATCHLISTS_COLLECTION).RemoveAll(query); err != nil {
+		return err
+	}
+	if _, err := db().C(RATINGS_COLLECTION).RemoveAll(query); err != nil {
+		return err
+	}
+	_, err = db().C(REVIEWS_COLLECTION).RemoveAll(query)
+	return err
+}
+
+// EnsureUserIndexes keeps one watchlist entry and rating per user and movie
+func EnsureUserIndexes() {
+	for _, collection := range []string{WATCHLISTS_COLLECTION, RATINGS_COLLECTION} {
+		db().C(collection).EnsureIndex(mgo.Index{Key: []string{"tenant", "user", "movie_id"}, Unique: true})
+		db().C(collection).EnsureIndex(mgo.Index{Key: []string{"tenant", "movie_id"}})
+	}
+}
@@ -0,0 +1,78 @@
+/******************************************************************************
+ * \file        users_test.go
+ *
+ * \brief       GO File that tests access to user watchlists and ratings
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// userRequest is a request for the resources of uid made by principal
+func userRequest(uid string, principal *Principal) *http.Request {
+	req, _ := http.NewRequest("GET", "/imdb/users/"+uid+"/watchlist", nil)
+	req = mux.SetURLVars(req, map[string]string{"uid": uid})
+	if principal != nil {
+		req = req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+	}
+	return req
+}
+
+func TestPathUser(t *testing.T) {
+	reader := &Principal{Subject: "jdoe", Role: ROLE_READER}
+	admin := &Principal{Subject: "ops", Role: ROLE_ADMIN}
+
+	cases := []struct {
+		uid       string
+		principal *Principal
+		user      string
+		code      int
+	}{
+		{"jdoe", reader, "jdoe", http.StatusOK},
+		{"me", reader, "jdoe", http.StatusOK},
+		{"asmith", reader, "", http.StatusForbidden},
+		{"asmith", admin, "asmith", http.StatusOK},
+		// without authentication users are trusted by path
+		{"asmith", nil, "asmith", http.StatusOK},
+		{"me", nil, "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		response := httptest.NewRecorder()
+		user, ok := pathUser(response, userRequest(c.uid, c.principal))
+		if user != c.user || ok != (c.code == http.StatusOK) {
+			t.Errorf("%s: expected user %q, got %q", c.uid, c.user, user)
+		}
+		checkResponseCode(t, c.code, response.Code)
+	}
+}
+
+func TestUserRoutesForbidden(t *testing.T) {
+	withAPIKeys(t)
+	checkResponseCode(t, http.StatusUnauthorized, serveWithKey("GET", "/imdb/users/me/watchlist", "").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("GET", "/imdb/users/someone/watchlist", "reader-key").Code)
+	checkResponseCode(t, http.StatusForbidden, serveWithKey("PUT", "/imdb/users/someone/ratings/5b1e4b0a9d1f2a3b4c5d6e7f", "uploader-key").Code)
+}
+
+func TestGetMoviesWatchedFilter(t *testing.T) {
+	// the current user is needed to filter watched movies
+	checkResponseCode(t, http.StatusBadRequest, serveWithKey("GET", "/imdb/movies?watched=false", "").Code)
+	checkResponseCode(t, http.StatusBadRequest, serveWithKey("GET", "/imdb/movies?watched=maybe&uid=jdoe", "").Code)
+}
+
+func TestIsValidUserRating(t *testing.T) {
+	for rating, valid := range map[int]bool{0: false, 1: true, 7: true, 10: true, 11: false, -3: false} {
+		if IsValidUserRating(rating) != valid {
+			t.Errorf("Expected rating %d valid %v", rating, valid)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+/******************************************************************************
+ * \file        validate.go
+ *
+ * \brief       GO File that validates requests, and in tests responses, against
+ *              the OpenAPI document of the routes
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// ValidationOptions struct for response validation in config file
+type ValidationOptions struct {
+	Responses bool `toml:"responses"`
+}
+
+// Responses are validated in tests, and when enabled in config during development
+var responseValidation bool
+
+// Largest JSON request body that is validated
+const MAX_VALIDATED_BODY = 1 << 20
+
+// ValidationError struct for a parameter or body field that does not match the API description
+type ValidationError struct {
+	In     string `json:"in"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	code   *ErrorCode
+}
+
+func (e ValidationError) Error() string {
+	if len(e.Name) == 0 {
+		return e.In + " " + e.Reason
+	}
+	return e.Name + " " + e.Reason
+}
+
+/******************************************************************************************
+ *
+ * Validation Middleware - rejects requests whose path, query and header parameters or
+ * JSON body do not match the OpenAPI operation of the route: types, ranges, patterns,
+ * required and mutually exclusive parameters. With response validation, responses are
+ * checked against the documented statuses and schemas, and a response that drifted
+ * from the document is replaced with a 500.
+ *
+*******************************************************************************************/
+func ValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := RouteOperation(RouteName(r))
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// resources of other users are forbidden before their requests are validated
+		if _, scoped := mux.Vars(r)["uid"]; scoped {
+			if _, ok := pathUser(w, r); !ok {
+				return
+			}
+		}
+
+		errs := validateParams(r, op)
+		errs = append(errs, validateBody(w, r, op)...)
+		if len(errs) > 0 {
+			RequestLog(r).WithFields(log.Fields{"Validation Errors": validationDetail(errs)}).Info("Request does not match the API description")
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		// streamed responses are not buffered, e.g. upload progress events
+		if !responseValidation || streamed(op) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buffer := &responseBuffer{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(buffer, r)
+		buffer.flush(r, op)
+	})
+}
+
+func streamed(op *Operation) bool {
+	if response, ok := op.Responses["200"]; ok {
+		_, events := response.Content["text/event-stream"]
+		return events
+	}
+	return false
+}
+
+// validateParams checks the parameters of the operation, then the exclusive and
+// dependent ones
+func validateParams(r *http.Request, op *Operation) []ValidationError {
+	query := r.URL.Query()
+	vars := mux.Vars(r)
+	present := make(map[string]bool)
+	location := make(map[string]string)
+
+	var errs []ValidationError
+	for _, param := range op.Parameters {
+		location[param.Name] = param.In
+		var value string
+		var ok bool
+		switch param.In {
+		case "query":
+			if values, found := query[param.Name]; found {
+				value, ok = values[0], true
+			}
+		case "header":
+			value = r.Header.Get(param.Name)
+			ok = len(value) > 0
+		case "path":
+			value, ok = vars[param.Name]
+		}
+		if !ok {
+			if param.Required {
+				errs = append(errs, ValidationError{In: param.In, Name: param.Name, Reason: "is required", code: param.Error})
+			}
+			continue
+		}
+		present[param.Name] = true
+		if reason := validateParam(param.Schema, value); len(reason) > 0 {
+			errs = append(errs, ValidationError{In: param.In, Name: param.Name, Reason: reason, code: param.Error})
+		}
+	}
+
+	for _, names := range op.Exclusive {
+		given := 0
+		for _, name := range names {
+			if present[name] {
+				given++
+			}
+		}
+		if given > 1 {
+			errs = append(errs, ValidationError{In: location[names[0]], Name: strings.Join(names, ","),
+				Reason: "cannot be combined", code: op.ExclusiveError})
+		}
+	}
+
+	names := make([]string, 0, len(op.Requires))
+	for name := range op.Requires {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !present[name] {
+			continue
+		}
+		for _, needed := range op.Requires[name] {
+			if !present[needed] {
+				errs = append(errs, ValidationError{In: location[needed], Name: needed,
+					Reason: "is required with " + name, code: op.RequiresError})
+			}
+		}
+	}
+	return errs
+}
+
+// validateParam parses a parameter value as the type of its schema and checks it
+func validateParam(schema *Schema, raw string) string {
+	var value interface{} = raw
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "must be an integer"
+		}
+		value = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		value = n
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "must be true or false"
+		}
+		value = b
+	}
+	if errs := openAPIDoc.validateValue(schema, value, ""); len(errs) > 0 {
+		return errs[0].Reason
+	}
+	return ""
+}
+
+// validateBody checks a JSON request body, the body is read again by the handler
+func validateBody(w http.ResponseWriter, r *http.Request, op *Operation) []ValidationError {
+	if op.RequestBody == nil || op.StreamBody {
+		return nil
+	}
+	media, ok := op.RequestBody.Content[CONTENT_TYPE_JSON]
+	contentType := r.Header.Get("Content-Type")
+	if !ok || len(contentType) > 0 && !strings.Contains(contentType, CONTENT_TYPE_JSON) {
+		return nil
+	}
+
+	invalid := func(reason string) []ValidationError {
+		return []ValidationError{{In: "body", Reason: reason, code: op.BodyError}}
+	}
+	var data []byte
+	if r.Body != nil {
+		var err error
+		data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_VALIDATED_BODY))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			return invalid("cannot be read: " + err.Error())
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		if op.RequestBody.Required {
+			return invalid("is required")
+		}
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return invalid("must be valid JSON")
+	}
+	errs := openAPIDoc.validateValue(media.Schema, value, "")
+	for i := range errs {
+		errs[i].In = "body"
+		errs[i].code = op.BodyError
+	}
+	return errs
+}
+
+func validationDetail(errs []ValidationError) string {
+	details := make([]string, len(errs))
+	for i, err := range errs {
+		details[i] = err.Error()
+	}
+	return strings.Join(details, "; ")
+}
+
+/******************************************************************************************
+ * Send the error of the first validation error, with every validation error in errors
+******************************************************************************************/
+func respondWithValidationErrors(w http.ResponseWriter, errs []ValidationError) {
+	errc := ERR_REQUEST_INVALID
+	if errs[0].code != nil {
+		errc = *errs[0].code
+	}
+	code := HTTPCode(errc)
+	body := map[string]interface{}{"errors": errs}
+	for key, value := range errorBody(w, map[string]string{"error": ErrorMsg(errc), "code": strconv.Itoa(code), "detail": errs[0].Error()}) {
+		body[key] = value
+	}
+	respondWithJSON(w, code, body)
+}
+
+// Compiled patterns of the schemas
+var schemaPatterns sync.Map
+
+func schemaPattern(pattern string) *regexp.Regexp {
+	if compiled, ok := schemaPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp)
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		log.WithFields(log.Fields{"Pattern": pattern, "Error": err}).Warning("Schema pattern is not checked")
+	}
+	schemaPatterns.Store(pattern, compiled)
+	return compiled
+}
+
+func fieldName(parent string, name string) string {
+	if len(parent) == 0 {
+		return name
+	}
+	return parent + "." + name
+}
+
+/******************************************************************************************
+ *
+ * Validate a value decoded from JSON against a schema, name is the path of the value
+ * in the body. Formats are descriptive and not checked.
+ *
+*******************************************************************************************/
+func (doc *OpenAPI) validateValue(schema *Schema, value interface{}, name string) []ValidationError {
+	schema = doc.resolve(schema)
+	if schema == nil || len(schema.Type) == 0 {
+		return nil
+	}
+	invalid := func(format string, args ...interface{}) []ValidationError {
+		return []ValidationError{{Name: name, Reason: fmt.Sprintf(format, args...)}}
+	}
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return invalid("must not be null")
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("must be an object")
+		}
+		var errs []ValidationError
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				errs = append(errs, ValidationError{Name: fieldName(name, key), Reason: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			errs = append(errs, doc.validateValue(property, object[key], fieldName(name, key))...)
+		}
+		return errs
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return invalid("must be an array")
+		}
+		var errs []ValidationError
+		for i, item := range items {
+			errs = append(errs, doc.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", name, i))...)
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+		if len(schema.Enum) > 0 {
+			found := false
+			for _, allowed := range schema.Enum {
+				found = found || allowed == s
+			}
+			if !found {
+				return invalid("must be one of %s", strings.Join(schema.Enum, ", "))
+			}
+		}
+		if len(schema.Pattern) > 0 {
+			if pattern := schemaPattern(schema.Pattern); pattern != nil && !pattern.MatchString(s) {
+				return invalid("must match %s", schema.Pattern)
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || schema.Type == "integer" && n != math.Trunc(n) {
+			return invalid("must be an %s", schema.Type)
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return invalid("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			return invalid("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid("must be true or false")
+		}
+	}
+	return nil
+}
+
+// responseBuffer holds a response until it is validated, a handler that flushes
+// streams its response and it is passed through without validation
+type responseBuffer struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	streaming   bool
+	body        bytes.Buffer
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.streaming {
+		b.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !b.wroteHeader {
+		b.code = code
+		b.wroteHeader = true
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.streaming {
+		return b.ResponseWriter.Write(p)
+	}
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) Flush() {
+	if !b.streaming {
+		b.streaming = true
+		if b.wroteHeader {
+			b.ResponseWriter.WriteHeader(b.code)
+			b.ResponseWriter.Write(b.body.Bytes())
+			b.body.Reset()
+		}
+	}
+	if flusher, ok := b.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (b *responseBuffer) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// flush sends the response, or a 500 naming what does not match the API description
+func (b *responseBuffer) flush(r *http.Request, op *Operation) {
+	if b.streaming {
+		return
+	}
+	if problems := checkResponse(op, b.code, b.Header().Get("Content-Type"), b.body.Bytes()); len(problems) > 0 {
+		detail := fmt.Sprintf("%s %d: %s", op.OperationID, b.code, strings.Join(problems, "; "))
+		RequestLog(r).WithFields(log.Fields{"Validation Errors": detail}).Error("Response does not match the API description")
+		// the error replaces the whole response, only the request ID and CORS headers stay
+		header := b.Header()
+		for key := range header {
+			if key != http.CanonicalHeaderKey(HEADER_REQUEST_ID) && key != "Vary" && !strings.HasPrefix(key, "Access-Control-") {
+				header.Del(key)
+			}
+		}
+		respondWithErrorDetail(b.ResponseWriter, ERR_RESPONSE_INVALID, detail)
+		return
+	}
+	b.ResponseWriter.WriteHeader(b.code)
+	b.ResponseWriter.Write(b.body.Bytes())
+}
+
+/******************************************************************************************
+ *
+ * Check a response against the operation: the status is documented, and a JSON body
+ * matches the schema of the status
+ *
+*******************************************************************************************/
+func checkResponse(op *Operation, status int, contentType string, body []byte) []string {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return []string{fmt.Sprintf("status %d is not documented", status)}
+	}
+	media, ok := response.Content[CONTENT_TYPE_JSON]
+	if !ok {
+		return nil
+	}
+	if !strings.HasPrefix(contentType, CONTENT_TYPE_JSON) {
+		return []string{fmt.Sprintf("Content-Type %q is not %s", contentType, CONTENT_TYPE_JSON)}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{"body is not valid JSON"}
+	}
+	var problems []string
+	for _, err := range openAPIDoc.validateValue(media.Schema, value, "") {
+		err.In = "body"
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
@@ -0,0 +1,248 @@
+/******************************************************************************
+ * \file        validate_test.go
+ *
+ * \brief       GO File that tests request and response validation against the
+ *              OpenAPI document
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Every response of the router tests is checked against the API description,
+// so handlers and the document cannot drift
+func TestMain(m *testing.M) {
+	responseValidation = true
+	os.Exit(m.Run())
+}
+
+// validationResponse struct for the structured errors of an invalid request
+type validationResponse struct {
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Detail string            `json:"detail"`
+	Errors []ValidationError `json:"errors"`
+}
+
+func serveValidated(t *testing.T, method string, path string, body string) (*httptest.ResponseRecorder, validationResponse) {
+	t.Helper()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", CONTENT_TYPE_JSON)
+	response := httptest.NewRecorder()
+	NewRouter().ServeHTTP(response, req)
+
+	var errs validationResponse
+	if response.Code >= http.StatusBadRequest {
+		if err := json.Unmarshal(response.Body.Bytes(), &errs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response, errs
+}
+
+func TestValidateQuery(t *testing.T) {
+	withAccessLog(t)
+
+	for path, expected := range map[string]ErrorCode{
+		"/imdb/movies?year=20155":                 ERR_YEAR_INVALID,
+		"/imdb/movies?year=twenty":                ERR_YEAR_INVALID,
+		"/imdb/movies?year_from=999&year_to=2015": ERR_YEAR_INVALID,
+		"/imdb/movies?genre=":                     ERR_GENRE_INVALID,
+		"/imdb/movies?watched=maybe":              ERR_WATCHED_INVALID,
+		"/imdb/movies?year=2016&year_from=2015":   ERR_YEAR_AND_RANGE,
+		"/imdb/movies?year=2016&year_to=2017":     ERR_YEAR_AND_RANGE,
+		"/imdb/movies?year_from=2014":             ERR_YEAR_RANGE_INVALID,
+		"/imdb/movies?year_to=2014":               ERR_YEAR_RANGE_INVALID,
+	} {
+		response, errs := serveValidated(t, "GET", path, "")
+		checkResponseCode(t, HTTPCode(expected), response.Code)
+		if errs.Error != ErrorMsg(expected) || len(errs.Errors) == 0 || errs.Detail != errs.Errors[0].Error() {
+			t.Errorf("Expected %q for %s, got %+v", ErrorMsg(expected), path, errs)
+		}
+	}
+}
+
+func TestValidateRangeMissingHalf(t *testing.T) {
+	withAccessLog(t)
+
+	_, errs := serveValidated(t, "GET", "/imdb/movies?year_from=2014", "")
+	if len(errs.Errors) != 1 || errs.Errors[0] != (ValidationError{In: "query", Name: "year_to", Reason: "is required with year_from"}) {
+		t.Errorf("Expected year_to to be required, got %+v", errs.Errors)
+	}
+	if errs.Detail != "year_to is required with year_from" {
+		t.Errorf("Expected the missing half in the detail, got %s", errs.Detail)
+	}
+
+	_, errs = serveValidated(t, "GET", "/imdb/movies?year=2016&year_from=2015&genre=", "")
+	if len(errs.Errors) != 3 || errs.Error != ErrorMsg(ERR_GENRE_INVALID) {
+		t.Errorf("Expected every violation with the first one answered, got %+v", errs)
+	}
+}
+
+func TestGetMoviesWithoutValidation(t *testing.T) {
+	for path, expected := range map[string]ErrorCode{
+		"/imdb/movies?year_from=2014":           ERR_YEAR_RANGE_INVALID,
+		"/imdb/movies?year_to=2014":             ERR_YEAR_RANGE_INVALID,
+		"/imdb/movies?year=2010&year_from=2000": ERR_YEAR_AND_RANGE,
+		"/imdb/movies?year=2010&year_to=2020":   ERR_YEAR_AND_RANGE,
+		"/imdb/movies?genre=":                   ERR_GENRE_INVALID,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		GetMovies(response, req)
+		var errjson map[string]string
+		json.Unmarshal(response.Body.Bytes(), &errjson)
+		if response.Code != HTTPCode(expected) || errjson["error"] != ErrorMsg(expected) {
+			t.Errorf("Expected %q for %s, got %d %v", ErrorMsg(expected), path, response.Code, errjson)
+		}
+	}
+}
+
+func TestValidationErrorCode(t *testing.T) {
+	for expected, code := range map[ErrorCode]*ErrorCode{
+		ERR_REQUEST_INVALID: nil,
+		ERR_FILE_INVALID:    errorCode(ERR_FILE_INVALID),
+	} {
+		response := httptest.NewRecorder()
+		respondWithValidationErrors(response, []ValidationError{{In: "body", Reason: "is required", code: code}})
+		var errjson map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &errjson)
+		if response.Code != HTTPCode(expected) || errjson["error"] != ErrorMsg(expected) {
+			t.Errorf("Expected %q, got %d %v", ErrorMsg(expected), response.Code, errjson)
+		}
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	withAccessLog(t)
+	withDefaultLogging(t)
+
+	for body, reason := range map[string]string{
+		`{"level": "loud"}`:               "level must be one of",
+		`{"module": "ingest"}`:            "level is required",
+		`{"level": "debug", "module": 3}`: "module must be a string",
+		`["debug"]`:                       "body must be an object",
+		`{"level": `:                      "body must be valid JSON",
+		``:                                "body is required",
+	} {
+		response, errs := serveValidated(t, "PUT", "/imdb/logging", body)
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+		if errs.Error != ErrorMsg(ERR_LOG_LEVEL_INVALID) || !strings.HasPrefix(errs.Detail, reason) || errs.Errors[0].In != "body" {
+			t.Errorf("Expected %q for %s, got %+v", reason, body, errs)
+		}
+	}
+
+	// the handler reads the validated body again
+	response, _ := serveValidated(t, "PUT", "/imdb/logging", `{"module": "ingest", "level": "debug"}`)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestValidateValue(t *testing.T) {
+	rating := RouteOperation("PutUserRating").RequestBody.Content[CONTENT_TYPE_JSON].Schema
+
+	for body, expected := range map[string]string{
+		`{"rating": 7}`:    "",
+		`{"rating": 11}`:   "rating must be at most 10",
+		`{"rating": 0}`:    "rating must be at least 1",
+		`{"rating": 7.5}`:  "rating must be an integer",
+		`{"rating": "7"}`:  "rating must be an integer",
+		`{"rating": null}`: "rating must not be null",
+	} {
+		var value interface{}
+		json.Unmarshal([]byte(body), &value)
+		errs := openAPIDoc.validateValue(rating, value, "")
+		if len(expected) == 0 && len(errs) > 0 || len(expected) > 0 && (len(errs) != 1 || errs[0].Error() != expected) {
+			t.Errorf("Expected %q for %s, got %v", expected, body, errs)
+		}
+	}
+
+	// referenced schemas are resolved, nested fields are named by their path
+	movie := RouteOperation("CreateMovie").RequestBody.Content[CONTENT_TYPE_JSON].Schema
+	var value interface{}
+	json.Unmarshal([]byte(`{"year": "2016", "metascore": null, "genre": ["Drama", 4]}`), &value)
+	names := make(map[string]bool)
+	for _, err := range openAPIDoc.validateValue(movie, value, "") {
+		names[err.Name] = true
+	}
+	if len(names) != 2 || !names["year"] || !names["genre[1]"] {
+		t.Errorf("Expected the year and second genre to be invalid, got %v", names)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	withAccessLog(t)
+
+	// a handler that drifted from the documented response of GetVersion
+	router := mux.NewRouter()
+	router.Use(ValidationMiddleware)
+	var handler http.HandlerFunc
+	router.Methods("GET").Path("/imdb/version").Name("GetVersion").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+	})
+	serve := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/imdb/version", nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	handler = GetVersion
+	checkResponseCode(t, http.StatusOK, serve().Code)
+
+	for detail, drifted := range map[string]http.HandlerFunc{
+		"version must be a string": func(w http.ResponseWriter, r *http.Request) {
+			respondWithJSON(w, http.StatusOK, map[string]interface{}{"name": "imdb", "version": 2})
+		},
+		"status 404 is not documented": func(w http.ResponseWriter, r *http.Request) {
+			respondWithErrorCode(w, ERR_NOT_FOUND)
+		},
+		"is not application/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set(HEADER_RATELIMIT_REMAINING, "4")
+			w.Write([]byte("imdb 2"))
+		},
+	} {
+		handler = drifted
+		response := serve()
+		checkResponseCode(t, http.StatusInternalServerError, response.Code)
+		if response.Header().Get("Content-Type") != CONTENT_TYPE_JSON || len(response.Header().Get(HEADER_RATELIMIT_REMAINING)) > 0 {
+			t.Errorf("Expected only the headers of the error response, got %v", response.Header())
+		}
+		var errjson map[string]string
+		json.Unmarshal(response.Body.Bytes(), &errjson)
+		if errjson["error"] != ErrorMsg(ERR_RESPONSE_INVALID) || !strings.Contains(errjson["detail"], detail) {
+			t.Errorf("Expected %q, got %v", detail, errjson)
+		}
+	}
+
+	// a handler that flushes streams its response without validation
+	handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("imdb "))
+		http.NewResponseController(w).Flush()
+		w.Write([]byte("2"))
+	}
+	if response := serve(); response.Code != http.StatusOK || response.Body.String() != "imdb 2" || !response.Flushed {
+		t.Errorf("Expected the streamed response, got %d %q", response.Code, response.Body.String())
+	}
+
+	// responses are passed through without validation
+	responseValidation = false
+	defer func() { responseValidation = true }()
+	handler = func(w http.ResponseWriter, r *http.Request) {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+	}
+	checkResponseCode(t, http.StatusNotFound, serve().Code)
+}
@@ -0,0 +1,564 @@
+/******************************************************************************
+ * \file        webhooks.go
+ *
+ * \brief       GO File that delivers catalog change events to webhooks
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Catalog change events
+const (
+	EVENT_UPLOAD_COMPLETED = "upload.completed"
+	EVENT_MOVIE_CREATED    = "movie.created"
+	EVENT_MOVIE_UPDATED    = "movie.updated"
+	EVENT_MOVIE_DELETED    = "movie.deleted"
+	EVENT_MOVIES_CLEANED   = "movies.cleaned"
+)
+
+// Events a webhook can subscribe to, "*" subscribes to all
+var webhookEvents = map[string]bool{
+	EVENT_UPLOAD_COMPLETED: true,
+	EVENT_MOVIE_CREATED:    true,
+	EVENT_MOVIE_UPDATED:    true,
+	EVENT_MOVIE_DELETED:    true,
+	EVENT_MOVIES_CLEANED:   true,
+	"*":                    true,
+}
+
+// Collections for webhook subscriptions and delivery attempts
+const (
+	WEBHOOKS_COLLECTION   = "webhooks"
+	DELIVERIES_COLLECTION = "webhook_deliveries"
+)
+
+// Headers sent with every delivery
+const (
+	HEADER_EVENT     = "X-IMDB-Event"
+	HEADER_DELIVERY  = "X-IMDB-Delivery"
+	HEADER_SIGNATURE = "X-IMDB-Signature"
+)
+
+// WebhookOptions struct for webhook delivery settings in config file
+type WebhookOptions struct {
+	Workers     int    `toml:"workers"`
+	QueueSize   int    `toml:"queuesize"`
+	MaxAttempts int    `toml:"maxattempts"`
+	Backoff     string `toml:"backoff"`
+	MaxBackoff  string `toml:"maxbackoff"`
+	Timeout     string `toml:"timeout"`
+}
+
+// Webhook struct for a webhook subscription
+type Webhook struct {
+	ID      bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	URL     string        `bson:"url" json:"url"`
+	Secret  string        `bson:"secret" json:"secret,omitempty"`
+	Events  []string      `bson:"events" json:"events"`
+	Tenant  string        `bson:"tenant" json:"tenant,omitempty"`
+	Created time.Time     `bson:"created" json:"created"`
+}
+
+// WebhookEvent struct for the JSON body of a delivery
+type WebhookEvent struct {
+	ID      string      `json:"id"`
+	Event   string      `json:"event"`
+	Tenant  string      `json:"tenant,omitempty"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// DeliveryAttempt struct for the log of each delivery attempt
+type DeliveryAttempt struct {
+	ID         bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	WebhookID  bson.ObjectId `bson:"webhook_id" json:"webhook_id"`
+	DeliveryID string        `bson:"delivery_id" json:"delivery_id"`
+	Event      string        `bson:"event" json:"event"`
+	Attempt    int           `bson:"attempt" json:"attempt"`
+	StatusCode int           `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string        `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64         `bson:"duration_ms" json:"duration_ms"`
+	Success    bool          `bson:"success" json:"success"`
+	Time       time.Time     `bson:"time" json:"time"`
+}
+
+// WebhookStore finds subscriptions and records delivery attempts
+type WebhookStore interface {
+	FindWebhooksForEvent(tenant string, event string) ([]Webhook, error)
+	LogDelivery(attempt DeliveryAttempt) error
+}
+
+// Webhook Dispatcher, nil when webhooks are not started
+var webhooks *WebhookDispatcher
+
+/******************************************************************************************
+ *
+ * Webhook Dispatcher - queues events and delivers them with retries
+ *
+*******************************************************************************************/
+type WebhookDispatcher struct {
+	Store       WebhookStore
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	queue       chan *webhookJob
+	wg          sync.WaitGroup
+	pending     sync.WaitGroup
+}
+
+// webhookJob is one event to deliver to one webhook, or to the subscribed webhooks
+// of the tenant while webhook is nil
+type webhookJob struct {
+	webhook *Webhook
+	tenant  string
+	event   string
+	body    []byte
+	id      string
+	attempt int
+}
+
+/******************************************************************************************
+ *
+ * Create a Webhook Dispatcher from config and start its workers
+ *
+*******************************************************************************************/
+func NewWebhookDispatcher(store WebhookStore, options WebhookOptions) (*WebhookDispatcher, error) {
+	backoff, err := parseDurationOption(options.Backoff, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: invalid backoff: %v", err)
+	}
+	maxBackoff, err := parseDurationOption(options.MaxBackoff, 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: invalid maxbackoff: %v", err)
+	}
+	timeout, err := parseDurationOption(options.Timeout, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: invalid timeout: %v", err)
+	}
+
+	d := &WebhookDispatcher{
+		Store:       store,
+		Client:      &http.Client{Timeout: timeout},
+		MaxAttempts: options.MaxAttempts,
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = 5
+	}
+	queueSize := options.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	workers := options.Workers
+	if workers <= 0 {
+		workers = 2
+	}
+
+	d.queue = make(chan *webhookJob, queueSize)
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	return d, nil
+}
+
+/******************************************************************************************
+ *
+ * Publish an event of a tenant to every webhook of the tenant subscribed to it.
+ * The event is queued, the workers find the webhooks and deliver it in the background.
+ *
+*******************************************************************************************/
+func (d *WebhookDispatcher) Publish(tenant string, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+
+	id := bson.NewObjectId().Hex()
+	body, err := json.Marshal(WebhookEvent{ID: id, Event: event, Tenant: tenant, Created: time.Now().UTC(), Data: data})
+	if err != nil {
+		webhooksLog.WithFields(log.Fields{"Event": event, "err": err}).Warning("Encoding webhook event failed")
+		return
+	}
+	d.enqueue(&webhookJob{tenant: tenant, event: event, body: body, id: id, attempt: 1})
+}
+
+// fanOut queues a delivery of the event for every webhook subscribed to it
+func (d *WebhookDispatcher) fanOut(job *webhookJob) {
+	defer d.pending.Done()
+
+	hooks, err := d.Store.FindWebhooksForEvent(job.tenant, job.event)
+	if err != nil {
+		webhooksLog.WithFields(log.Fields{"Event": job.event, "err": err}).Warning("Finding webhooks failed")
+		return
+	}
+	for i := range hooks {
+		delivery := *job
+		delivery.webhook = &hooks[i]
+		d.enqueue(&delivery)
+	}
+}
+
+// enqueue adds a job without blocking the caller, events are dropped if the queue is full
+func (d *WebhookDispatcher) enqueue(job *webhookJob) {
+	d.pending.Add(1)
+	select {
+	case d.queue <- job:
+	default:
+		d.pending.Done()
+		fields := log.Fields{"Event": job.event, "Tenant": job.tenant}
+		if job.webhook != nil {
+			fields["Webhook"] = job.webhook.URL
+		}
+		webhooksLog.WithFields(fields).Warning("Webhook queue is full, event dropped")
+	}
+}
+
+func (d *WebhookDispatcher) worker() {
+	defer d.wg.Done()
+	for job := range d.queue {
+		if job.webhook == nil {
+			d.fanOut(job)
+		} else {
+			d.deliver(job)
+		}
+	}
+}
+
+/******************************************************************************************
+ *
+ * Deliver a job once, failed attempts are retried with exponential backoff
+ *
+*******************************************************************************************/
+func (d *WebhookDispatcher) deliver(job *webhookJob) {
+	defer d.pending.Done()
+
+	attempt := DeliveryAttempt{
+		WebhookID:  job.webhook.ID,
+		DeliveryID: job.id,
+		Event:      job.event,
+		Attempt:    job.attempt,
+		Time:       time.Now().UTC(),
+	}
+
+	start := time.Now()
+	statusCode, err := d.post(job)
+	attempt.DurationMs = int64(time.Since(start) / time.Millisecond)
+	attempt.StatusCode = statusCode
+	attempt.Success = err == nil
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if err := d.Store.LogDelivery(attempt); err != nil {
+		webhooksLog.WithFields(log.Fields{"Webhook": job.webhook.URL, "err": err}).Warning("Logging webhook delivery failed")
+	}
+	webhooksLog.WithFields(log.Fields{"Webhook": job.webhook.URL, "Event": job.event, "Attempt": job.attempt,
+		"StatusCode": statusCode, "Success": attempt.Success}).Info()
+
+	if attempt.Success || job.attempt >= d.MaxAttempts {
+		return
+	}
+
+	// retry later without holding a worker
+	retry := *job
+	retry.attempt += 1
+	d.pending.Add(1)
+	time.AfterFunc(d.backoff(job.attempt), func() {
+		d.enqueue(&retry)
+		d.pending.Done()
+	})
+}
+
+// backoff returns the delay after the given attempt, doubled each time
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempt && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+// post sends the signed event, any status other than 2xx is a failure
+func (d *WebhookDispatcher) post(job *webhookJob) (int, error) {
+	req, err := http.NewRequest("POST", job.webhook.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_EVENT, job.event)
+	req.Header.Set(HEADER_DELIVERY, job.id)
+	req.Header.Set(HEADER_SIGNATURE, SignPayload(job.webhook.Secret, job.body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+/******************************************************************************************
+ *
+ * Wait until queued deliveries and retries are done
+ *
+*******************************************************************************************/
+func (d *WebhookDispatcher) Flush() {
+	d.pending.Wait()
+}
+
+/******************************************************************************************
+ *
+ * Wait until queued deliveries and retries are done or the context is done
+ *
+*******************************************************************************************/
+func (d *WebhookDispatcher) Drain(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		d.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhooks: deliveries pending: %v", ctx.Err())
+	}
+}
+
+/******************************************************************************************
+ *
+ * Sign a payload with the webhook secret, "sha256=" followed by the hex HMAC
+ *
+*******************************************************************************************/
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret generates a random secret for a webhook
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/******************************************************************************************
+ *
+ * Find webhooks of a tenant subscribed to an event
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindWebhooksForEvent(tenant string, event string) ([]Webhook, error) {
+	var hooks []Webhook
+	done := m.operation("FindWebhooksForEvent", WEBHOOKS_COLLECTION)
+	err := db().C(WEBHOOKS_COLLECTION).Find(bson.M{"tenant": tenantFilter(tenant),
+		"events": bson.M{"$in": []string{event, "*"}}}).All(&hooks)
+	return hooks, done(err)
+}
+
+/******************************************************************************************
+ *
+ * Record a delivery attempt
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) LogDelivery(attempt DeliveryAttempt) error {
+	done := m.operation("LogDelivery", DELIVERIES_COLLECTION)
+	return done(db().C(DELIVERIES_COLLECTION).Insert(&attempt))
+}
+
+/******************************************************************************************
+ *
+ * List webhook subscriptions of the tenant, secrets are not returned
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindWebhooks() ([]Webhook, error) {
+	hooks := []Webhook{}
+	done := m.operation("FindWebhooks", WEBHOOKS_COLLECTION)
+	err := db().C(WEBHOOKS_COLLECTION).Find(bson.M{"tenant": tenantFilter(m.Tenant)}).Select(bson.M{"secret": 0}).Sort("created").All(&hooks)
+	return hooks, done(err)
+}
+
+/******************************************************************************************
+ *
+ * Create and delete webhook subscriptions of the tenant
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) InsertWebhook(hook Webhook) error {
+	hook.Tenant = m.Tenant
+	done := m.operation("InsertWebhook", WEBHOOKS_COLLECTION)
+	return done(db().C(WEBHOOKS_COLLECTION).Insert(&hook))
+}
+
+func (m *MoviesDAO) DeleteWebhook(id bson.ObjectId) error {
+	done := m.operation("DeleteWebhook", WEBHOOKS_COLLECTION)
+	if err := db().C(WEBHOOKS_COLLECTION).Remove(bson.M{"_id": id, "tenant": tenantFilter(m.Tenant)}); err != nil {
+		return done(err)
+	}
+	_, err := db().C(DELIVERIES_COLLECTION).RemoveAll(bson.M{"webhook_id": id})
+	return done(err)
+}
+
+func (m *MoviesDAO) DeleteWebhooks() error {
+	var hooks []Webhook
+	done := m.operation("DeleteWebhooks", WEBHOOKS_COLLECTION)
+	if err := done(db().C(WEBHOOKS_COLLECTION).Find(bson.M{"tenant": tenantFilter(m.Tenant)}).All(&hooks)); err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := m.DeleteWebhook(hook.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * List the latest delivery attempts of a webhook of the tenant
+ *
+*******************************************************************************************/
+func (m *MoviesDAO) FindDeliveries(id bson.ObjectId, limit int) ([]DeliveryAttempt, error) {
+	done := m.operation("FindDeliveries", DELIVERIES_COLLECTION)
+	count, err := db().C(WEBHOOKS_COLLECTION).Find(bson.M{"_id": id, "tenant": tenantFilter(m.Tenant)}).Count()
+	if err != nil {
+		return nil, done(err)
+	} else if count == 0 {
+		return nil, done(mgo.ErrNotFound)
+	}
+	attempts := []DeliveryAttempt{}
+	err = db().C(DELIVERIES_COLLECTION).Find(bson.M{"webhook_id": id}).Sort("-time").Limit(limit).All(&attempts)
+	return attempts, done(err)
+}
+
+/******************************************************************************************
+ *
+ * Create a webhook subscription
+ *
+*******************************************************************************************/
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	RequestLog(r).WithFields(log.Fields{"EndPoint": "CreateWebhook"}).Info()
+
+	var hook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		respondWithErrorCode(w, ERR_WEBHOOK_INVALID)
+		return
+	}
+
+	target, err := url.ParseRequestURI(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		respondWithErrorCode(w, ERR_WEBHOOK_INVALID)
+		return
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = []string{"*"}
+	}
+	for _, event := range hook.Events {
+		if !webhookEvents[event] {
+			respondWithErrorCode(w, ERR_WEBHOOK_INVALID)
+			return
+		}
+	}
+	if len(hook.Secret) == 0 {
+		hook.Secret = newWebhookSecret()
+	}
+
+	hook.ID = bson.NewObjectId()
+	hook.Created = time.Now().UTC()
+	hook.Tenant = RequestTenant(r).Name
+	if err := TenantDAO(r).InsertWebhook(hook); err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+
+	// the secret is only returned when the webhook is created
+	respondWithJSON(w, http.StatusCreated, hook)
+}
+
+/******************************************************************************************
+ *
+ * List webhook subscriptions
+ *
+*******************************************************************************************/
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := TenantDAO(r).FindWebhooks()
+	if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, hooks)
+}
+
+/******************************************************************************************
+ *
+ * Delete a webhook subscription and its delivery log
+ *
+*******************************************************************************************/
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := TenantDAO(r).DeleteWebhook(id)
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************************
+ *
+ * List the delivery attempts of a webhook
+ *
+*******************************************************************************************/
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	attempts, err := TenantDAO(r).FindDeliveries(id, 100)
+	if err == mgo.ErrNotFound {
+		respondWithErrorCode(w, ERR_NOT_FOUND)
+		return
+	} else if err != nil {
+		respondWithErrorCode(w, ERR_INTERNAL_SERVER)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, attempts)
+}
@@ -0,0 +1,184 @@
+/******************************************************************************
+ * \file        webhooks_test.go
+ *
+ * \brief       GO File that has tests for webhook delivery
+ *
+ * \author      Reshma Syeda
+ *
+ * ****************************************************************************/
+package main
+
+import(
+		"testing"
+		"encoding/json"
+		"io/ioutil"
+		"net/http"
+		"net/http/httptest"
+		"sync"
+		"sync/atomic"
+		"time"
+		"gopkg.in/mgo.v2/bson"
+)
+
+// memoryWebhookStore keeps subscriptions and delivery attempts in memory
+type memoryWebhookStore struct {
+	sync.Mutex
+	hooks    []Webhook
+	attempts []DeliveryAttempt
+}
+
+func (s *memoryWebhookStore) FindWebhooksForEvent(tenant string, event string) ([]Webhook, error) {
+	var hooks []Webhook
+	for _, hook := range s.hooks {
+		if hook.Tenant != tenant {
+			continue
+		}
+		for _, e := range hook.Events {
+			if e == event || e == "*" {
+				hooks = append(hooks, hook)
+				break
+			}
+		}
+	}
+	return hooks, nil
+}
+
+func (s *memoryWebhookStore) LogDelivery(attempt DeliveryAttempt) error {
+	s.Lock()
+	defer s.Unlock()
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+/******************************************************************************************
+ *
+ * Test signed delivery with retries after failures
+ *
+*******************************************************************************************/
+func TestWebhookDelivery(t *testing.T) {
+	var calls int32
+	var signatureValid, eventValid bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first two attempts
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		signatureValid = r.Header.Get(HEADER_SIGNATURE) == SignPayload("s3cret", body)
+
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+		data, _ := event.Data.(map[string]interface{})
+		eventValid = event.Event == EVENT_UPLOAD_COMPLETED && r.Header.Get(HEADER_EVENT) == EVENT_UPLOAD_COMPLETED &&
+			data["RecordsCreated"] == float64(5)
+	}))
+	defer server.Close()
+
+	store := &memoryWebhookStore{hooks: []Webhook{
+		{ID: bson.NewObjectId(), URL: server.URL, Secret: "s3cret", Events: []string{EVENT_UPLOAD_COMPLETED}},
+		{ID: bson.NewObjectId(), URL: server.URL, Secret: "other", Events: []string{EVENT_MOVIE_DELETED}},
+	}}
+	dispatcher, err := NewWebhookDispatcher(store, WebhookOptions{Backoff: "10ms", MaxAttempts: 5})
+	if err != nil {
+		t.Fatalf("TestWebhookDelivery Failed %v", err)
+	}
+
+	dispatcher.Publish("", EVENT_UPLOAD_COMPLETED, &UploadResults{RecordsRead: 5, RecordsCreated: 5})
+	dispatcher.Flush()
+
+	if !signatureValid || !eventValid {
+		t.Errorf("TestWebhookDelivery Failed, signature %v event %v", signatureValid, eventValid)
+	}
+
+	// every attempt is logged, only the last one succeeded
+	if len(store.attempts) != 3 || store.attempts[0].Success || store.attempts[0].StatusCode != 500 ||
+		!store.attempts[2].Success || store.attempts[2].Attempt != 3 {
+		t.Errorf("TestWebhookDelivery Failed %+v", store.attempts)
+	}
+}
+
+// slowWebhookStore finds subscriptions only after release is closed
+type slowWebhookStore struct {
+	memoryWebhookStore
+	release chan struct{}
+}
+
+func (s *slowWebhookStore) FindWebhooksForEvent(tenant string, event string) ([]Webhook, error) {
+	<-s.release
+	return s.memoryWebhookStore.FindWebhooksForEvent(tenant, event)
+}
+
+/******************************************************************************************
+ *
+ * Test that publishing does not wait for the subscriptions to be found
+ *
+*******************************************************************************************/
+func TestWebhookPublishQueued(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	store := &slowWebhookStore{release: make(chan struct{})}
+	store.hooks = []Webhook{
+		{ID: bson.NewObjectId(), URL: server.URL, Events: []string{"*"}},
+		{ID: bson.NewObjectId(), URL: server.URL, Events: []string{EVENT_MOVIE_CREATED}},
+	}
+	dispatcher, _ := NewWebhookDispatcher(store, WebhookOptions{Workers: 1})
+
+	published := make(chan struct{})
+	go func() {
+		dispatcher.Publish("", EVENT_MOVIE_CREATED, nil)
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("TestWebhookPublishQueued Failed, Publish waited for the store")
+	}
+
+	close(store.release)
+	dispatcher.Flush()
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("TestWebhookPublishQueued Failed, %d deliveries", calls)
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test that delivery stops after the maximum number of attempts
+ *
+*******************************************************************************************/
+func TestWebhookMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := &memoryWebhookStore{hooks: []Webhook{{ID: bson.NewObjectId(), URL: server.URL, Events: []string{"*"}}}}
+	dispatcher, _ := NewWebhookDispatcher(store, WebhookOptions{Backoff: "1ms", MaxAttempts: 3})
+
+	dispatcher.Publish("", EVENT_MOVIES_CLEANED, nil)
+	dispatcher.Flush()
+
+	if len(store.attempts) != 3 {
+		t.Errorf("TestWebhookMaxAttempts Failed, %d attempts", len(store.attempts))
+	}
+}
+
+/******************************************************************************************
+ *
+ * Test exponential backoff
+ *
+*******************************************************************************************/
+func TestWebhookBackoff(t *testing.T) {
+	dispatcher := &WebhookDispatcher{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if dispatcher.backoff(i+1) != delay {
+			t.Errorf("TestWebhookBackoff Failed for attempt %d", i+1)
+		}
+	}
+}